@@ -0,0 +1,239 @@
+package tokenizers
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ErrPromptTooLong is returned by FitPrompt when the template and special tokens
+// alone exceed the limit.
+var ErrPromptTooLong = errors.New("prompt template exceeds the token limit")
+
+// PromptSegment is a labeled part of a prompt, e.g. the system text, retrieved
+// documents, the conversation history or the user query.
+type PromptSegment struct {
+	Label string
+	Text  string
+	// Priority orders the segments getting the tokens left after MinShare,
+	// higher first, segments of the same priority in order.
+	Priority int
+	// MinShare is the share of the budget reserved for the segment, at most
+	// what it needs. The min shares of all segments must sum to at most 1.
+	MinShare float64
+	// MaxShare is the largest share of the budget the segment gets, 0 means no limit.
+	MaxShare float64
+	// Strategy is the part of the text kept when the segment is truncated.
+	Strategy TruncationStrategy
+}
+
+// FittedSegment is a segment of a prompt fitted by FitPrompt.
+type FittedSegment struct {
+	// PromptSegment holds the fitted text.
+	PromptSegment
+	// NumTokens is the number of tokens of the fitted text on its own.
+	NumTokens int
+	Truncated bool
+}
+
+// Prompt is the result of FitPrompt.
+type Prompt struct {
+	// Text is the assembled prompt.
+	Text     string
+	Segments []FittedSegment
+	// NumTokens is the number of tokens of Text, including special tokens.
+	NumTokens int
+}
+
+type promptOpts struct {
+	render     func(segments []PromptSegment) string
+	encodeOpts []EncodeOption
+}
+
+// PromptOption configures FitPrompt.
+type PromptOption func(po *promptOpts)
+
+// WithPromptSeparator joins the segments with sep, "\n" by default.
+func WithPromptSeparator(sep string) PromptOption {
+	return func(po *promptOpts) {
+		po.render = func(segments []PromptSegment) string {
+			texts := make([]string, len(segments))
+			for i, s := range segments {
+				texts[i] = s.Text
+			}
+			return strings.Join(texts, sep)
+		}
+	}
+}
+
+// WithPromptTemplate assembles the prompt with render, e.g. a chat template. The
+// tokens render adds around the segments are taken from the budget.
+func WithPromptTemplate(render func(segments []PromptSegment) string) PromptOption {
+	return func(po *promptOpts) {
+		po.render = render
+	}
+}
+
+// WithPromptEncodeOptions sets the options the assembled prompt is encoded with,
+// e.g. WithAllowedSpecial for special tokens of a chat template.
+func WithPromptEncodeOptions(opts ...EncodeOption) PromptOption {
+	return func(po *promptOpts) {
+		po.encodeOpts = opts
+	}
+}
+
+// FitPrompt truncates the segments so that the assembled prompt, encoded with
+// special tokens if addSpecialTokens is set, has at most limit tokens. Each segment
+// first gets its MinShare of the budget left by the template and special tokens,
+// then the rest of the budget goes to the segments by Priority, up to their MaxShare.
+// The prompt is encoded to verify the count, tokens merged across segments are
+// taken from the segments of the lowest priority, never below their MinShare.
+// Tokens are counted without the truncation and padding of the tokenizer.
+// Segments are returned in order.
+func (t *Tokenizer) FitPrompt(segments []PromptSegment, limit int, addSpecialTokens bool, opts ...PromptOption) (Prompt, error) {
+	po := &promptOpts{}
+	WithPromptSeparator("\n")(po)
+	for _, opt := range opts {
+		opt(po)
+	}
+	if limit < 0 {
+		return Prompt{}, fmt.Errorf("limit must not be negative, got %d", limit)
+	}
+	minShares := 0.0
+	for _, s := range segments {
+		if s.MinShare < 0 || s.MinShare > 1 || s.MaxShare < 0 || s.MaxShare > 1 {
+			return Prompt{}, fmt.Errorf("segment %q: shares must be in [0, 1]", s.Label)
+		}
+		if s.MaxShare > 0 && s.MinShare > s.MaxShare {
+			return Prompt{}, fmt.Errorf("segment %q: min share %g exceeds max share %g", s.Label, s.MinShare, s.MaxShare)
+		}
+		if s.Strategy < TruncateHead || s.Strategy > TruncateMiddle {
+			return Prompt{}, fmt.Errorf("segment %q: invalid truncation strategy %d", s.Label, s.Strategy)
+		}
+		minShares += s.MinShare
+	}
+	if minShares > 1+1e-9 {
+		return Prompt{}, fmt.Errorf("min shares sum to %g, more than 1", minShares)
+	}
+
+	empty := make([]PromptSegment, len(segments))
+	for i, s := range segments {
+		empty[i] = s
+		empty[i].Text = ""
+	}
+	overhead, err := t.countPrompt(po, po.render(empty), addSpecialTokens)
+	if err != nil {
+		return Prompt{}, err
+	}
+	budget := limit - overhead
+	if budget < 0 {
+		return Prompt{}, fmt.Errorf("%w: %d tokens, limit %d", ErrPromptTooLong, overhead, limit)
+	}
+
+	needs := make([]int, len(segments))
+	// the tokens reserved by MinShare, kept when the prompt is shrunk
+	reserved := make([]int, len(segments))
+	for i, s := range segments {
+		if needs[i], err = t.countTokens(s.Text); err != nil {
+			return Prompt{}, err
+		}
+		reserved[i] = min(needs[i], shareOf(s.MinShare, budget))
+	}
+	alloc := allocate(segments, needs, budget)
+	// the segments in the order they give up tokens, lowest priority first
+	order := priorityOrder(segments)
+	slices.Reverse(order)
+
+	fitted := make([]FittedSegment, len(segments))
+	for {
+		for i, s := range segments {
+			fitted[i] = FittedSegment{PromptSegment: s, NumTokens: needs[i]}
+			if alloc[i] >= needs[i] {
+				continue
+			}
+			text, n, err := t.TruncateText(s.Text, alloc[i], s.Strategy)
+			if errors.Is(err, ErrCannotTruncate) {
+				// not even the first token fits, the segment is dropped
+				text, n, err = "", 0, nil
+			}
+			if err != nil {
+				return Prompt{}, err
+			}
+			fitted[i].Text, fitted[i].NumTokens, fitted[i].Truncated = text, n, true
+		}
+		fittedSegments := make([]PromptSegment, len(fitted))
+		for i, f := range fitted {
+			fittedSegments[i] = f.PromptSegment
+		}
+		text := po.render(fittedSegments)
+		numTokens, err := t.countPrompt(po, text, addSpecialTokens)
+		if err != nil {
+			return Prompt{}, err
+		}
+		excess := numTokens - limit
+		if excess <= 0 {
+			return Prompt{Text: text, Segments: fitted, NumTokens: numTokens}, nil
+		}
+		shrunk := false
+		for _, i := range order {
+			if fitted[i].NumTokens > reserved[i] {
+				alloc[i] = max(fitted[i].NumTokens-excess, reserved[i])
+				shrunk = true
+				break
+			}
+		}
+		if !shrunk {
+			return Prompt{}, fmt.Errorf("%w: %d tokens, limit %d", ErrPromptTooLong, numTokens, limit)
+		}
+	}
+}
+
+// countPrompt returns the number of tokens of the assembled prompt text.
+func (t *Tokenizer) countPrompt(po *promptOpts, text string, addSpecialTokens bool) (int, error) {
+	opts := append(slices.Clip(po.encodeOpts), WithoutTruncationAndPadding())
+	encoding, err := t.EncodeWithOptionsErr(text, addSpecialTokens, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return len(encoding.IDs), nil
+}
+
+// allocate splits budget tokens between segments needing needs tokens.
+func allocate(segments []PromptSegment, needs []int, budget int) []int {
+	alloc := make([]int, len(segments))
+	limits := make([]int, len(segments))
+	left := budget
+	for i, s := range segments {
+		limits[i] = needs[i]
+		if s.MaxShare > 0 {
+			limits[i] = min(limits[i], shareOf(s.MaxShare, budget))
+		}
+		alloc[i] = min(limits[i], shareOf(s.MinShare, budget))
+		left -= alloc[i]
+	}
+	for _, i := range priorityOrder(segments) {
+		extra := min(limits[i]-alloc[i], left)
+		alloc[i] += extra
+		left -= extra
+	}
+	return alloc
+}
+
+func shareOf(share float64, budget int) int {
+	return int(math.Floor(share * float64(budget)))
+}
+
+// priorityOrder returns the indexes of segments by priority, highest first.
+func priorityOrder(segments []PromptSegment) []int {
+	order := make([]int, len(segments))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(segments[b].Priority, segments[a].Priority)
+	})
+	return order
+}
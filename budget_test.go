@@ -0,0 +1,183 @@
+package tokenizers_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitPrompt(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	segments := func(update func(segments []tokenizers.PromptSegment)) []tokenizers.PromptSegment {
+		segments := []tokenizers.PromptSegment{
+			{Label: "system", Text: "hello world", Priority: 2},
+			{Label: "context", Text: "the quick brown fox jumps over the lazy dog"},
+			{Label: "query", Text: "brown fox", Priority: 1, Strategy: tokenizers.TruncateTail},
+		}
+		if update != nil {
+			update(segments)
+		}
+		return segments
+	}
+	template := tokenizers.WithPromptTemplate(func(segments []tokenizers.PromptSegment) string {
+		var sb strings.Builder
+		for _, s := range segments {
+			sb.WriteString(s.Label + ": " + s.Text + "\n")
+		}
+		return sb.String()
+	})
+	// labels only for segments with text, they aren't part of the overhead
+	labels := tokenizers.WithPromptTemplate(func(segments []tokenizers.PromptSegment) string {
+		var sb strings.Builder
+		for _, s := range segments {
+			if s.Text != "" {
+				sb.WriteString(s.Label + ": " + s.Text + "\n")
+			}
+		}
+		return sb.String()
+	})
+	tests := []struct {
+		name      string
+		segments  []tokenizers.PromptSegment
+		limit     int
+		opts      []tokenizers.PromptOption
+		want      string
+		wantTexts []string
+		wantLen   int
+	}{
+		{
+			name:      "priority",
+			segments:  segments(nil),
+			limit:     10,
+			want:      "hello world\nthe quick brown fox\nbrown fox",
+			wantTexts: []string{"hello world", "the quick brown fox", "brown fox"},
+			wantLen:   10,
+		},
+		{
+			name:      "max share",
+			segments:  segments(func(s []tokenizers.PromptSegment) { s[0].MaxShare = 0.125 }),
+			limit:     10,
+			want:      "hello\nthe quick brown fox jumps\nbrown fox",
+			wantTexts: []string{"hello", "the quick brown fox jumps", "brown fox"},
+			wantLen:   10,
+		},
+		{
+			name:      "min share",
+			segments:  segments(func(s []tokenizers.PromptSegment) { s[1].MinShare = 0.75 }),
+			limit:     10,
+			want:      "hello world\nthe quick brown fox jumps over\n",
+			wantTexts: []string{"hello world", "the quick brown fox jumps over", ""},
+			wantLen:   10,
+		},
+		{
+			name:      "template",
+			segments:  segments(nil),
+			limit:     16,
+			opts:      []tokenizers.PromptOption{template},
+			want:      "system: hello world\ncontext: the quick brown fox\nquery: brown fox\n",
+			wantTexts: []string{"hello world", "the quick brown fox", "brown fox"},
+			wantLen:   16,
+		},
+		{
+			name:      "min share kept when shrinking",
+			segments:  segments(func(s []tokenizers.PromptSegment) { s[1].MinShare = 0.5 }),
+			limit:     14,
+			opts:      []tokenizers.PromptOption{labels},
+			want:      "system: hello world\ncontext: the quick brown fox jumps over\n",
+			wantTexts: []string{"hello world", "the quick brown fox jumps over", ""},
+			wantLen:   14,
+		},
+		{
+			name:      "fits",
+			segments:  segments(nil),
+			limit:     512,
+			opts:      []tokenizers.PromptOption{tokenizers.WithPromptSeparator(" ")},
+			want:      "hello world the quick brown fox jumps over the lazy dog brown fox",
+			wantTexts: []string{"hello world", "the quick brown fox jumps over the lazy dog", "brown fox"},
+			wantLen:   15,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, err := tk.FitPrompt(tt.segments, tt.limit, true, tt.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, prompt.Text)
+			assert.Equal(t, tt.wantLen, prompt.NumTokens)
+			require.Len(t, prompt.Segments, len(tt.segments))
+			for i, s := range prompt.Segments {
+				assert.Equal(t, tt.segments[i].Label, s.Label)
+				assert.Equal(t, tt.wantTexts[i], s.Text)
+				assert.Equal(t, tt.wantTexts[i] != tt.segments[i].Text, s.Truncated)
+				ids, _, err := tk.EncodeErr(s.Text, false)
+				require.NoError(t, err)
+				assert.Equal(t, len(ids), s.NumTokens)
+			}
+		})
+	}
+
+	_, err = tk.FitPrompt(segments(nil), 1, true)
+	assert.ErrorIs(t, err, tokenizers.ErrPromptTooLong)
+	_, err = tk.FitPrompt(segments(nil), -1, true)
+	assert.Error(t, err)
+	_, err = tk.FitPrompt(segments(func(s []tokenizers.PromptSegment) { s[0].MinShare = 1.5 }), 10, true)
+	assert.Error(t, err)
+	_, err = tk.FitPrompt(segments(func(s []tokenizers.PromptSegment) { s[0].MinShare, s[1].MinShare = 0.6, 0.6 }), 10, true)
+	assert.Error(t, err)
+	_, err = tk.FitPrompt(segments(func(s []tokenizers.PromptSegment) { s[0].MinShare, s[0].MaxShare = 0.5, 0.25 }), 10, true)
+	assert.Error(t, err)
+	_, err = tk.FitPrompt(segments(func(s []tokenizers.PromptSegment) { s[0].Strategy = 3 }), 10, true)
+	assert.Error(t, err)
+
+	// truncation and padding of the tokenizer don't count
+	tk, err = tokenizers.FromFile("./test/data/bert-base-uncased.json",
+		tokenizers.WithTruncation(4, tokenizers.TruncationDirectionRight),
+		tokenizers.WithPadding(32, tokenizers.PaddingDirectionRight), tokenizers.WithPadToken("[PAD]"))
+	require.NoError(t, err)
+	defer tk.Close()
+	prompt, err := tk.FitPrompt(segments(nil), 10, true)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world\nthe quick brown fox\nbrown fox", prompt.Text)
+	assert.Equal(t, 10, prompt.NumTokens)
+	assert.Equal(t, 4, prompt.Segments[1].NumTokens)
+}
+
+func TestFitPromptTiktoken(t *testing.T) {
+	tk, err := tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	data, err := os.ReadFile("./test/data/long_text.txt")
+	require.NoError(t, err)
+	text := string(data)
+	segments := []tokenizers.PromptSegment{
+		{Label: "system", Text: "You are a helpful assistant.", Priority: 3},
+		{Label: "context", Text: text[:len(text)/2], MinShare: 0.25, MaxShare: 0.5, Strategy: tokenizers.TruncateMiddle},
+		{Label: "history", Text: text[len(text)/2:], Priority: 1, Strategy: tokenizers.TruncateTail},
+		{Label: "query", Text: "Summarize the text above", Priority: 2},
+	}
+	// tokens of a segment may merge with the separator, the prompt still fits
+	for _, limit := range []int{16, 64, 100, 1000} {
+		prompt, err := tk.FitPrompt(segments, limit, true, tokenizers.WithPromptSeparator(""))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, prompt.NumTokens, limit)
+		ids, _, err := tk.EncodeErr(prompt.Text, true)
+		require.NoError(t, err)
+		assert.Len(t, ids, prompt.NumTokens)
+		if limit >= 100 {
+			assert.Equal(t, segments[0].Text, prompt.Segments[0].Text)
+			assert.Equal(t, segments[3].Text, prompt.Segments[3].Text)
+			assert.Greater(t, prompt.Segments[1].NumTokens, 0)
+			assert.LessOrEqual(t, prompt.Segments[1].NumTokens, limit/2)
+		}
+	}
+}
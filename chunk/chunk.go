@@ -0,0 +1,168 @@
+// Package chunk splits documents into chunks of a token budget, e.g. for retrieval.
+package chunk
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daulet/tokenizers"
+)
+
+// DefaultSeparators prefer ending chunks at paragraphs, then lines, sentences and words.
+var DefaultSeparators = []string{"\n\n", "\n", ". ", "! ", "? ", " "}
+
+// Options configures Split.
+type Options struct {
+	// MaxTokens is the maximum number of tokens of a chunk.
+	MaxTokens int
+	// Overlap is the number of tokens a chunk shares with the previous chunk,
+	// it must be smaller than MaxTokens.
+	Overlap int
+	// MinTokens is the minimum number of tokens of a chunk, unless the document
+	// is too short or can't be split otherwise.
+	MinTokens int
+	// Separators are the boundaries chunks end at, in order of preference.
+	// Defaults to DefaultSeparators, chunks end at any token if none is found.
+	Separators []string
+}
+
+// Chunk is a part of a document.
+type Chunk struct {
+	// Text is the document in [Start, End).
+	Text  string
+	Start int
+	End   int
+	// IDs are the tokens of the chunk in the encoding of the document, encoding
+	// Text on its own may differ at the edges of the chunk.
+	IDs       []uint32
+	NumTokens int
+}
+
+// Split splits text into chunks of at most opts.MaxTokens tokens without special
+// tokens, cut at token boundaries of the original text. The result only depends
+// on the text, the tokenizer and the options, the truncation and padding of the
+// tokenizer are ignored.
+func Split(tk *tokenizers.Tokenizer, text string, opts Options) ([]Chunk, error) {
+	if opts.MaxTokens <= 0 {
+		return nil, fmt.Errorf("max tokens must be positive, got %d", opts.MaxTokens)
+	}
+	if opts.Overlap < 0 || opts.Overlap >= opts.MaxTokens {
+		return nil, fmt.Errorf("overlap %d must be in [0, %d)", opts.Overlap, opts.MaxTokens)
+	}
+	if opts.MinTokens < 0 || opts.MinTokens > opts.MaxTokens {
+		return nil, fmt.Errorf("min tokens %d must be in [0, %d]", opts.MinTokens, opts.MaxTokens)
+	}
+	if opts.Separators == nil {
+		opts.Separators = DefaultSeparators
+	}
+	encoding, err := tk.EncodeWithOptionsErr(text, false, tokenizers.WithReturnOffsets(), tokenizers.WithoutTruncationAndPadding())
+	if err != nil {
+		return nil, err
+	}
+
+	s := splitter{text: text, offsets: clampOffsets(encoding.Offsets, len(text)), opts: opts}
+	n := len(encoding.IDs)
+	var chunks []Chunk
+	for start := 0; start < n; {
+		end := min(start+opts.MaxTokens, n)
+		if end < n {
+			end = s.cut(start, end)
+		}
+		ids := encoding.IDs[start:end]
+		startByte := int(s.offsets[start][0])
+		endByte := max(int(s.offsets[end-1][1]), startByte)
+		chunks = append(chunks, Chunk{
+			Text:      text[startByte:endByte],
+			Start:     startByte,
+			End:       endByte,
+			IDs:       ids[:len(ids):len(ids)],
+			NumTokens: len(ids),
+		})
+		if end == n {
+			break
+		}
+		next := max(end-opts.Overlap, start+1)
+		for next < end && !s.boundary(next) {
+			next++
+		}
+		start = next
+	}
+	return chunks, nil
+}
+
+// clampOffsets returns the offsets within a text of n bytes, none ending before it
+// starts.
+func clampOffsets(offsets []tokenizers.Offset, n int) []tokenizers.Offset {
+	clamped := make([]tokenizers.Offset, len(offsets))
+	for i, o := range offsets {
+		start := min(o[0], uint(n))
+		clamped[i] = tokenizers.Offset{start, min(max(o[1], start), uint(n))}
+	}
+	return clamped
+}
+
+type splitter struct {
+	text    string
+	offsets []tokenizers.Offset
+	opts    Options
+}
+
+// cut returns the end of the chunk starting at token start, at most end.
+func (s *splitter) cut(start, end int) int {
+	// leave at least MinTokens tokens to the next chunk too
+	lo := start + max(s.opts.MinTokens, 1)
+	hi := min(end, len(s.offsets)+s.opts.Overlap-s.opts.MinTokens)
+	if hi < lo {
+		hi = end
+	}
+	for _, sep := range s.opts.Separators {
+		for j := hi; j >= lo; j-- {
+			if s.boundary(j) && s.separatedBy(j, sep) {
+				return j
+			}
+		}
+	}
+	for j := end; j > start; j-- {
+		if s.boundary(j) {
+			return j
+		}
+	}
+	return end
+}
+
+// boundary reports whether the text can be cut before token j, i.e. tokens j-1
+// and j don't overlap and the cut isn't inside a character.
+func (s *splitter) boundary(j int) bool {
+	if j <= 0 || j >= len(s.offsets) {
+		return true
+	}
+	prevEnd, start := int(s.offsets[j-1][1]), int(s.offsets[j][0])
+	return prevEnd <= start && s.runeStart(prevEnd) && s.runeStart(start)
+}
+
+func (s *splitter) runeStart(pos int) bool {
+	return pos >= len(s.text) || utf8.RuneStart(s.text[pos])
+}
+
+// separatedBy reports whether sep touches the text between tokens j-1 and j,
+// separators may be part of either token, e.g. ".\n\n" or " word".
+func (s *splitter) separatedBy(j int, sep string) bool {
+	gapStart, gapEnd := int(s.offsets[j-1][1]), int(s.offsets[j][0])
+	lo, hi := int(s.offsets[j-1][0]), min(int(s.offsets[j][1]), len(s.text))
+	for i := lo; i < hi; {
+		k := strings.Index(s.text[i:hi], sep)
+		if k < 0 {
+			return false
+		}
+		k += i
+		if k > gapEnd {
+			return false
+		}
+		if k+len(sep) >= gapStart {
+			return true
+		}
+		i = k + 1
+	}
+	return false
+}
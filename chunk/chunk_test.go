@@ -0,0 +1,181 @@
+package chunk_test
+
+import (
+	"os"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/daulet/tokenizers"
+	"github.com/daulet/tokenizers/chunk"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	tk, err := tokenizers.FromFile("../test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+
+	tests := []struct {
+		name string
+		text string
+		opts chunk.Options
+		want []string
+	}{
+		{
+			name: "paragraph",
+			text: "one two.\n\nthree four five six",
+			opts: chunk.Options{MaxTokens: 5},
+			want: []string{"one two.", "three four five six"},
+		},
+		{
+			name: "min tokens",
+			text: "one two.\n\nthree four five six",
+			opts: chunk.Options{MaxTokens: 5, MinTokens: 4},
+			want: []string{"one two.\n\nthree four", "five six"},
+		},
+		{
+			name: "words",
+			text: "one two three four five",
+			opts: chunk.Options{MaxTokens: 4},
+			want: []string{"one two three four", "five"},
+		},
+		{
+			name: "min tokens of the last chunk",
+			text: "one two three four five",
+			opts: chunk.Options{MaxTokens: 4, MinTokens: 2},
+			want: []string{"one two three", "four five"},
+		},
+		{
+			name: "overlap",
+			text: "one two three four five",
+			opts: chunk.Options{MaxTokens: 3, Overlap: 1},
+			want: []string{"one two three", "three four five"},
+		},
+		{
+			name: "no separator",
+			text: "tokenization",
+			opts: chunk.Options{MaxTokens: 1, Separators: []string{}},
+			want: []string{"token", "ization"},
+		},
+		{
+			name: "empty",
+			text: "",
+			opts: chunk.Options{MaxTokens: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := chunk.Split(tk, tt.text, tt.opts)
+			require.NoError(t, err)
+			var texts []string
+			for _, c := range chunks {
+				texts = append(texts, c.Text)
+			}
+			assert.Equal(t, tt.want, texts)
+		})
+	}
+
+	for _, opts := range []chunk.Options{{}, {MaxTokens: 2, Overlap: 2}, {MaxTokens: 2, MinTokens: 3}} {
+		_, err := chunk.Split(tk, "one two", opts)
+		assert.Error(t, err)
+	}
+}
+
+func TestSplitLongText(t *testing.T) {
+	data, err := os.ReadFile("../test/data/long_text.txt")
+	require.NoError(t, err)
+	text := string(data)
+	opts := chunk.Options{MaxTokens: 128, Overlap: 16, MinTokens: 32}
+
+	tk, err := tokenizers.FromFile("../test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	chunks, err := chunk.Split(tk, text, opts)
+	require.NoError(t, err)
+	require.Greater(t, len(chunks), 1)
+	assertChunks(t, text, chunks, opts)
+	for i, c := range chunks[:len(chunks)-1] {
+		// chunks end at separators, whitespace is between tokens
+		r, _ := utf8.DecodeRuneInString(text[c.End:])
+		assert.True(t, unicode.IsSpace(r), "chunk %d ends inside a word", i)
+		assert.Equal(t, c.IDs[c.NumTokens-opts.Overlap:], chunks[i+1].IDs[:opts.Overlap])
+	}
+	again, err := chunk.Split(tk, text, opts)
+	require.NoError(t, err)
+	assert.Equal(t, chunks, again)
+
+	tk, err = tokenizers.FromTiktoken(
+		"../test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"../test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	// characters encoded as several byte tokens are never split
+	text = "🦜🦜🦜🦜 " + text
+	chunks, err = chunk.Split(tk, text, chunk.Options{MaxTokens: 4})
+	require.NoError(t, err)
+	assertChunks(t, text, chunks, chunk.Options{MaxTokens: 4})
+	chunks, err = chunk.Split(tk, text, opts)
+	require.NoError(t, err)
+	assertChunks(t, text, chunks, opts)
+	assert.Equal(t, 0, chunks[0].Start)
+	assert.Equal(t, len(text), chunks[len(chunks)-1].End)
+}
+
+func TestSplitPaddedInvalidUTF8(t *testing.T) {
+	// padding and truncation of the tokenizer are ignored
+	tk, err := tokenizers.FromFile("../test/data/bert-base-uncased.json",
+		tokenizers.WithTruncation(4, tokenizers.TruncationDirectionRight),
+		tokenizers.WithPadding(16, tokenizers.PaddingDirectionRight), tokenizers.WithPadToken("[PAD]"))
+	require.NoError(t, err)
+	defer tk.Close()
+	text := "\xff\xff one two three four five"
+	opts := chunk.Options{MaxTokens: 2}
+	chunks, err := chunk.Split(tk, text, opts)
+	require.NoError(t, err)
+	var texts []string
+	for _, c := range chunks {
+		texts = append(texts, c.Text)
+	}
+	assert.Equal(t, []string{"one two", "three four", "five"}, texts)
+	assertChunks(t, text, chunks, opts)
+
+	tk, err = tokenizers.FromTiktoken(
+		"../test/data/kimi-k2-instruct/tiktoken.model",
+		"../test/data/kimi-k2-instruct/tokenizer_config.json",
+		tokenizers.PatternKimiK2,
+		// pad_token of tokenizer_config.json
+		tokenizers.WithPadding(16, tokenizers.PaddingDirectionRight),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	// replacement characters are encoded for the invalid bytes
+	text = "\xff\xff one two"
+	chunks, err = chunk.Split(tk, text, opts)
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+	for _, c := range chunks {
+		assert.Equal(t, text[c.Start:c.End], c.Text)
+		assert.LessOrEqual(t, c.NumTokens, opts.MaxTokens)
+	}
+	assert.Equal(t, len(text), chunks[len(chunks)-1].End)
+}
+
+func assertChunks(t *testing.T, text string, chunks []chunk.Chunk, opts chunk.Options) {
+	t.Helper()
+	for i, c := range chunks {
+		assert.Equal(t, text[c.Start:c.End], c.Text)
+		assert.True(t, utf8.ValidString(c.Text), "chunk %d", i)
+		assert.Len(t, c.IDs, c.NumTokens)
+		assert.LessOrEqual(t, c.NumTokens, opts.MaxTokens)
+		if i > 0 {
+			assert.Greater(t, c.Start, chunks[i-1].Start)
+			assert.Greater(t, c.End, chunks[i-1].End)
+			assert.LessOrEqual(t, c.Start, chunks[i-1].End, "chunks leave out text")
+		}
+	}
+}
@@ -1,13 +1,19 @@
 use std::{collections::HashSet, ffi::CStr};
+use std::sync::OnceLock;
 use std::path::PathBuf;
 use std::ptr;
 use std::collections::HashMap;
 use tokenizers::tokenizer::Tokenizer;
+use tokenizers::tokenizer::{PaddingDirection, PaddingParams, PaddingStrategy, TruncationParams};
+use tokenizers::tokenizer::{NormalizedString, Normalizer, OffsetReferential, OffsetType, PostProcessor, PreTokenizedString, PreTokenizer};
 use serde::{Deserialize, Serialize};
 use tiktoken_rs;
 
 const TOKENIZERS_VERSION: &[u8] = concat!(env!("CARGO_PKG_VERSION"), "\0").as_bytes();
 
+// Pad tokens of common vocabularies, looked up when the tokenizer has no padding.
+const PAD_TOKENS: [&str; 4] = ["[PAD]", "<pad>", "<|pad|>", "<PAD>"];
+
 // Version-specific symbol that will cause link failure if version doesn't match
 // Bump minor.patch version every time we bump tokenizers dependency version.
 // Can't bump major version because Go doesn't like major version >= 2.
@@ -47,10 +53,321 @@ impl TruncationDirection {
     }
 }
 
+/// A set of special tokens, "all" or an explicit set.
+pub enum SpecialTokenSet<'a> {
+    All,
+    Tokens(HashSet<&'a str>),
+}
+
+impl SpecialTokenSet<'_> {
+    fn contains(&self, token: &str) -> bool {
+        match self {
+            SpecialTokenSet::All => true,
+            SpecialTokenSet::Tokens(tokens) => tokens.contains(token),
+        }
+    }
+}
+
+/// Special tokens that may be parsed from the text being encoded and the ones
+/// that must not appear in it, mirroring allowed_special and disallowed_special
+/// of OpenAI's tiktoken. Special tokens that are neither are encoded as text.
+pub struct SpecialTokenPolicy<'a> {
+    pub allowed: SpecialTokenSet<'a>,
+    pub disallowed: SpecialTokenSet<'a>,
+}
+
+/// Returned when the text contains a special token disallowed by SpecialTokenPolicy.
+#[derive(Debug)]
+pub struct DisallowedSpecialTokenError {
+    pub token: String,
+}
+
+impl std::fmt::Display for DisallowedSpecialTokenError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "encountered disallowed special token {:?}", self.token)
+    }
+}
+
+impl std::error::Error for DisallowedSpecialTokenError {}
+
+impl SpecialTokenPolicy<'_> {
+    /// Returns the special tokens allowed by the policy,
+    /// or an error if the text contains a disallowed one.
+    fn resolve<'t>(&self, text: &str, special_tokens: impl Iterator<Item = &'t str>) -> Result<HashSet<&'t str>, DisallowedSpecialTokenError> {
+        let mut allowed = HashSet::new();
+        for token in special_tokens {
+            let is_allowed = self.allowed.contains(token);
+            // like tiktoken, "all" disallowed special tokens exclude the allowed ones
+            let is_disallowed = match &self.disallowed {
+                SpecialTokenSet::All => !is_allowed,
+                SpecialTokenSet::Tokens(tokens) => tokens.contains(token),
+            };
+            if is_disallowed && text.contains(token) {
+                return Err(DisallowedSpecialTokenError { token: token.to_string() });
+            }
+            if is_allowed {
+                allowed.insert(token);
+            }
+        }
+        Ok(allowed)
+    }
+}
+
+/// A tiktoken encoder with the vocabulary details CoreBPE doesn't expose.
+pub struct TiktokenTokenizer {
+    pub bpe: tiktoken_rs::CoreBPE,
+    pub vocab_size: u32,
+    /// Special tokens that can be parsed from the text
+    pub special_tokens: HashSet<String>,
+    /// IDs of special tokens, skipped when decoding
+    pub special_token_ids: HashSet<u32>,
+    /// Bytes of every token, including special tokens
+    pub decoder: HashMap<u32, Vec<u8>>,
+    /// IDs of the bytes of every token, special tokens take precedence
+    pub token_ids: HashMap<Vec<u8>, u32>,
+    /// Default pad token, e.g. pad_token of tokenizer_config.json
+    pub pad_token: Option<String>,
+    pub truncation: Option<TruncationParams>,
+    pub padding: Option<PaddingParams>,
+    /// Encode special tokens in the text as ordinary text, like HuggingFace's encode_special_tokens
+    pub encode_special_tokens: bool,
+    /// Pre-tokenization pattern splitting the text into words
+    pub regex: fancy_regex::Regex,
+}
+
+impl TiktokenTokenizer {
+    /// Returns the details of an encoding: tokens as byte-level strings like HuggingFace
+    /// ByteLevel tokenizers produce, byte offsets into the text, masks and word IDs.
+    fn details(&self, text: &str, ids: Vec<u32>) -> EncodingDetails {
+        let byte_level = bytes_to_unicode();
+        let mut tokens: Vec<String> = Vec::with_capacity(ids.len());
+        let mut offsets = Vec::with_capacity(ids.len());
+        let mut special_tokens_mask = Vec::with_capacity(ids.len());
+        let mut start = 0;
+        for id in &ids {
+            let bytes = self.decoder.get(id).map(Vec::as_slice).unwrap_or_default();
+            let is_special = self.special_token_ids.contains(id);
+            if is_special {
+                tokens.push(String::from_utf8_lossy(bytes).into_owned());
+            } else {
+                tokens.push(bytes.iter().map(|b| byte_level[*b as usize]).collect());
+            }
+            offsets.push((start, start + bytes.len()));
+            start += bytes.len();
+            special_tokens_mask.push(is_special as u32);
+        }
+        EncodingDetails {
+            type_ids: Some(vec![0; ids.len()]),
+            tokens: Some(tokens),
+            special_tokens_mask: Some(special_tokens_mask),
+            attention_mask: Some(vec![1; ids.len()]),
+            word_ids: Some(self.word_ids(text, &ids, &offsets)),
+            sequence_ids: Some(vec![Some(0); ids.len()]),
+            offsets: Some(offsets),
+            ids,
+            overflowing: Vec::new(),
+        }
+    }
+
+    /// Returns the word of every token, words being the pieces the pattern splits the
+    /// text into between special tokens, like the Split pre-tokenizer of HuggingFace.
+    /// Special tokens parsed from the text are words of their own.
+    fn word_ids(&self, text: &str, ids: &[u32], offsets: &[(usize, usize)]) -> Vec<Option<u32>> {
+        // byte offsets at which words start, in increasing order
+        let mut words = Vec::new();
+        let split = |words: &mut Vec<usize>, start: usize, end: usize| {
+            for piece in self.regex.find_iter(&text[start..end]).flatten() {
+                words.push(start + piece.start());
+            }
+        };
+        let mut segment_start = 0;
+        for (id, &(start, end)) in ids.iter().zip(offsets) {
+            if self.special_token_ids.contains(id) {
+                split(&mut words, segment_start, start);
+                words.push(start);
+                segment_start = end;
+            }
+        }
+        split(&mut words, segment_start, text.len());
+        offsets.iter()
+            .map(|&(start, _)| Some(words.partition_point(|&word| word <= start).saturating_sub(1) as u32))
+            .collect()
+    }
+
+    /// Encodes the words one by one like HuggingFace's pre-tokenized input: word IDs
+    /// are the indices of the words and offsets are relative to each word.
+    fn encode_words(&self, words: &[&str], add_special_tokens: bool, sequence_id: usize) -> Result<EncodingDetails, Box<dyn std::error::Error>> {
+        let mut details = self.details("", Vec::new());
+        for (word_id, word) in words.iter().enumerate() {
+            let allowed = self.allowed_special(word, add_special_tokens, None)?;
+            let (ids, _) = self.bpe.encode(word, &allowed);
+            let mut word_details = self.details(word, ids);
+            let len = word_details.ids.len();
+            word_details.type_ids = Some(vec![sequence_id as u32; len]);
+            word_details.word_ids = Some(vec![Some(word_id as u32); len]);
+            word_details.sequence_ids = Some(vec![Some(sequence_id); len]);
+            details.extend(word_details);
+        }
+        Ok(details)
+    }
+
+    /// Fills the attributes missing from an encoding of the given sequence, tokens and
+    /// special tokens mask are derived from the IDs.
+    fn complete(&self, details: EncodingDetails, sequence_id: usize) -> EncodingDetails {
+        let len = details.ids.len();
+        let defaults = self.details("", details.ids.clone());
+        EncodingDetails {
+            type_ids: details.type_ids.or(Some(vec![sequence_id as u32; len])),
+            tokens: details.tokens.or(defaults.tokens),
+            special_tokens_mask: details.special_tokens_mask.or(defaults.special_tokens_mask),
+            attention_mask: details.attention_mask.or(defaults.attention_mask),
+            offsets: details.offsets.or(Some(vec![(0, 0); len])),
+            word_ids: details.word_ids.or(Some(vec![None; len])),
+            sequence_ids: Some(vec![Some(sequence_id); len]),
+            ids: details.ids,
+            overflowing: Vec::new(),
+        }
+    }
+
+    /// Returns the special tokens to parse from the text.
+    fn allowed_special<'a>(&'a self, text: &str, add_special_tokens: bool, policy: Option<&SpecialTokenPolicy>) -> Result<HashSet<&'a str>, DisallowedSpecialTokenError> {
+        let allowed = match policy {
+            Some(policy) => policy.resolve(text, self.special_tokens.iter().map(String::as_str))?,
+            None => UnifiedTokenizer::get_special_tokens_refs(&self.special_tokens, add_special_tokens),
+        };
+        if self.encode_special_tokens {
+            return Ok(HashSet::new());
+        }
+        Ok(allowed)
+    }
+
+    fn token_to_id(&self, token: &str) -> Option<u32> {
+        self.token_ids.get(token.as_bytes()).copied()
+    }
+
+    /// Applies truncation and padding like HuggingFace's post-processing does.
+    fn truncate_and_pad(&self, details: &mut EncodingDetails) {
+        self.truncate(details);
+        if let Some(padding) = &self.padding {
+            let mut length = match &padding.strategy {
+                PaddingStrategy::Fixed(length) => *length,
+                PaddingStrategy::BatchLongest => details.ids.len(),
+            };
+            if let Some(multiple) = padding.pad_to_multiple_of {
+                if multiple > 0 && length % multiple != 0 {
+                    length += multiple - length % multiple;
+                }
+            }
+            details.pad(length, padding.pad_id, padding.pad_type_id, &padding.pad_token, &padding.direction);
+        }
+    }
+
+    fn truncate(&self, details: &mut EncodingDetails) {
+        if let Some(truncation) = &self.truncation {
+            details.truncate(truncation.max_length, truncation.stride, &truncation.direction);
+        }
+    }
+}
+
+/// Returns the mapping of bytes to printable characters used by GPT-2 byte-level BPE.
+fn bytes_to_unicode() -> [char; 256] {
+    let mut chars = ['\0'; 256];
+    let mut n = 0;
+    for b in 0..=255u32 {
+        let printable = (b'!' as u32..=b'~' as u32).contains(&b)
+            || (0xA1..=0xAC).contains(&b)
+            || (0xAE..=0xFF).contains(&b);
+        chars[b as usize] = if printable {
+            char::from_u32(b).unwrap()
+        } else {
+            n += 1;
+            char::from_u32(255 + n).unwrap()
+        };
+    }
+    chars
+}
+
+/// Settings a copy of a HuggingFace tokenizer differs in.
+#[derive(Clone, Copy, Default, PartialEq)]
+struct Variant {
+    /// Without the padding of the tokenizer
+    unpadded: bool,
+    /// Without the truncation of the tokenizer
+    untruncated: bool,
+    /// Encoding special tokens as text
+    literal: bool,
+}
+
+impl Variant {
+    const COUNT: usize = 8;
+
+    fn index(&self) -> usize {
+        self.unpadded as usize | (self.untruncated as usize) << 1 | (self.literal as usize) << 2
+    }
+}
+
+/// A HuggingFace tokenizer with the copies of it encoding differently, made on first
+/// use and dropped when the tokenizer is changed. Copying a tokenizer copies its whole
+/// vocabulary, so copies are never made per call.
+pub struct HuggingFaceTokenizer {
+    tokenizer: Tokenizer,
+    // indexed by Variant::index
+    variants: [OnceLock<Tokenizer>; Variant::COUNT],
+}
+
+impl HuggingFaceTokenizer {
+    pub fn new(tokenizer: Tokenizer) -> Self {
+        HuggingFaceTokenizer { tokenizer, variants: Default::default() }
+    }
+
+    /// Returns the tokenizer with the settings of the variant, the tokenizer itself if
+    /// it already has them.
+    fn variant(&self, variant: Variant) -> &Tokenizer {
+        let variant = Variant {
+            unpadded: variant.unpadded && self.tokenizer.get_padding().is_some(),
+            untruncated: variant.untruncated && self.tokenizer.get_truncation().is_some(),
+            literal: variant.literal && !self.tokenizer.get_encode_special_tokens(),
+        };
+        if variant == Variant::default() {
+            return &self.tokenizer;
+        }
+        self.variants[variant.index()].get_or_init(|| {
+            let mut tokenizer = self.tokenizer.clone();
+            if variant.unpadded {
+                tokenizer.with_padding(None);
+            }
+            if variant.untruncated {
+                // only setting invalid truncation parameters fails
+                let _ = tokenizer.with_truncation(None);
+            }
+            if variant.literal {
+                tokenizer.set_encode_special_tokens(true);
+            }
+            tokenizer
+        })
+    }
+}
+
+impl std::ops::Deref for HuggingFaceTokenizer {
+    type Target = Tokenizer;
+
+    fn deref(&self) -> &Tokenizer {
+        &self.tokenizer
+    }
+}
+
+impl std::ops::DerefMut for HuggingFaceTokenizer {
+    fn deref_mut(&mut self) -> &mut Tokenizer {
+        // the copies would be out of date
+        self.variants = Default::default();
+        &mut self.tokenizer
+    }
+}
+
 // Unified tokenizer interface
 pub enum UnifiedTokenizer {
-    HuggingFace(Tokenizer),
-    Tiktoken(tiktoken_rs::CoreBPE, u32, HashSet<String>, HashSet<u32>),
+    HuggingFace(HuggingFaceTokenizer),
+    Tiktoken(TiktokenTokenizer),
 }
 
 impl UnifiedTokenizer {
@@ -71,40 +388,301 @@ impl UnifiedTokenizer {
                     .map_err(|e| format!("Encoding error: {}", e))?;
                 Ok(encoding.get_ids().to_vec())
             }
-            UnifiedTokenizer::Tiktoken(bpe, _vocab_size, special_tokens, _special_token_ids) => {
-                let special_tokens_refs = Self::get_special_tokens_refs(special_tokens, add_special_tokens);
-                let (tokens, _) = bpe.encode(text, &special_tokens_refs);
+            UnifiedTokenizer::Tiktoken(tiktoken) if tiktoken.truncation.is_some() || tiktoken.padding.is_some() => {
+                Ok(self.encode_with_details(text, add_special_tokens, None)?.ids)
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                let special_tokens_refs = tiktoken.allowed_special(text, add_special_tokens, None)?;
+                let (tokens, _) = tiktoken.bpe.encode(text, &special_tokens_refs);
                 Ok(tokens)
             }
         }
     }
 
-    pub fn encode_with_details(&self, text: &str, add_special_tokens: bool) -> Result<EncodingDetails, Box<dyn std::error::Error>> {
+    /// Encodes the text with the variant of the HuggingFace tokenizer, parsing only the
+    /// special tokens allowed by the policy. The tokenizer can either parse all special
+    /// tokens or none, so text containing both allowed and not allowed special tokens is
+    /// rejected.
+    fn encode_huggingface(huggingface: &HuggingFaceTokenizer, variant: Variant, text: &str, add_special_tokens: bool, policy: Option<&SpecialTokenPolicy>) -> Result<tokenizers::Encoding, Box<dyn std::error::Error>> {
+        let tokenizer = huggingface.variant(variant);
+        let policy = match policy {
+            Some(policy) => policy,
+            None => return tokenizer.encode(text, add_special_tokens)
+                .map_err(|e| format!("Encoding error: {}", e).into()),
+        };
+        let added_tokens = tokenizer.get_added_tokens_decoder();
+        let special_tokens: Vec<&str> = added_tokens.values()
+            .filter(|token| token.special)
+            .map(|token| token.content.as_str())
+            .collect();
+        let allowed = policy.resolve(text, special_tokens.iter().copied())?;
+        let has_allowed = special_tokens.iter().any(|token| allowed.contains(token) && text.contains(token));
+        let has_not_allowed = special_tokens.iter().any(|token| !allowed.contains(token) && text.contains(token));
+        if !has_not_allowed {
+            return tokenizer.encode(text, add_special_tokens)
+                .map_err(|e| format!("Encoding error: {}", e).into());
+        }
+        if has_allowed {
+            return Err("text contains both allowed and not allowed special tokens, which HuggingFace tokenizers can't encode together".into());
+        }
+        huggingface.variant(Variant { literal: true, ..variant })
+            .encode(text, add_special_tokens)
+            .map_err(|e| format!("Encoding error: {}", e).into())
+    }
+
+    pub fn encode_with_details(&self, text: &str, add_special_tokens: bool, policy: Option<&SpecialTokenPolicy>) -> Result<EncodingDetails, Box<dyn std::error::Error>> {
         match self {
             UnifiedTokenizer::HuggingFace(tokenizer) => {
-                let encoding = tokenizer.encode(text, add_special_tokens)
+                let encoding = Self::encode_huggingface(tokenizer, Variant::default(), text, add_special_tokens, policy)?;
+                Ok(EncodingDetails::from(&encoding))
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                let special_tokens_refs = tiktoken.allowed_special(text, add_special_tokens, policy)?;
+                let (tokens, _) = tiktoken.bpe.encode(text, &special_tokens_refs);
+                let mut details = tiktoken.details(text, tokens);
+                tiktoken.truncate_and_pad(&mut details);
+                Ok(details)
+            }
+        }
+    }
+
+    /// Encodes input already split into words, and optionally a pair of such inputs,
+    /// like HuggingFace's encode with is_pretokenized.
+    pub fn encode_words(&self, words: &[&str], pair: Option<&[&str]>, add_special_tokens: bool) -> Result<EncodingDetails, Box<dyn std::error::Error>> {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => {
+                let encoding = match pair {
+                    Some(pair) => tokenizer.encode((words, pair), add_special_tokens),
+                    None => tokenizer.encode(words, add_special_tokens),
+                }.map_err(|e| format!("Encoding error: {}", e))?;
+                Ok(EncodingDetails::from(&encoding))
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                let mut details = tiktoken.encode_words(words, add_special_tokens, 0)?;
+                if let Some(pair) = pair {
+                    details.extend(tiktoken.encode_words(pair, add_special_tokens, 1)?);
+                }
+                tiktoken.truncate_and_pad(&mut details);
+                Ok(details)
+            }
+        }
+    }
+
+    /// Converts an encoding of the given sequence to a HuggingFace encoding, missing
+    /// attributes are filled like encoding would.
+    fn huggingface_encoding(tokenizer: &Tokenizer, details: EncodingDetails, sequence_id: usize) -> tokenizers::Encoding {
+        let len = details.ids.len();
+        let tokens = details.tokens.unwrap_or_else(|| {
+            details.ids.iter().map(|id| tokenizer.id_to_token(*id).unwrap_or_default()).collect()
+        });
+        let mut encoding = tokenizers::Encoding::new(
+            details.ids,
+            details.type_ids.unwrap_or_else(|| vec![sequence_id as u32; len]),
+            tokens,
+            details.word_ids.unwrap_or_else(|| vec![None; len]),
+            details.offsets.unwrap_or_else(|| vec![(0, 0); len]),
+            details.special_tokens_mask.unwrap_or_else(|| vec![0; len]),
+            details.attention_mask.unwrap_or_else(|| vec![1; len]),
+            Vec::new(),
+            Default::default(),
+        );
+        encoding.set_sequence_id(sequence_id);
+        encoding
+    }
+
+    /// Runs the post-processor on already encoded sequences, then truncation and padding.
+    /// Tiktoken tokenizers have no post-processor, the sequences are concatenated.
+    pub fn post_process(&self, first: EncodingDetails, second: Option<EncodingDetails>, add_special_tokens: bool) -> Result<EncodingDetails, Box<dyn std::error::Error>> {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => {
+                let first = Self::huggingface_encoding(tokenizer, first, 0);
+                let second = second.map(|second| Self::huggingface_encoding(tokenizer, second, 1));
+                let encoding = tokenizer.post_process(first, second, add_special_tokens)
+                    .map_err(|e| format!("Post-processing error: {}", e))?;
+                Ok(EncodingDetails::from(&encoding))
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                let mut details = tiktoken.complete(first, 0);
+                if let Some(second) = second {
+                    details.extend(tiktoken.complete(second, 1));
+                }
+                tiktoken.truncate_and_pad(&mut details);
+                Ok(details)
+            }
+        }
+    }
+
+    /// Returns the number of special tokens the post-processor adds to a sequence or a pair.
+    pub fn num_special_tokens_to_add(&self, is_pair: bool) -> usize {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => tokenizer.get_post_processor()
+                .map_or(0, |processor| processor.added_tokens(is_pair)),
+            UnifiedTokenizer::Tiktoken(_) => 0,
+        }
+    }
+
+    /// Encodes the text like encode_with_details without the padding of the tokenizer,
+    /// and without its truncation unless truncate is set.
+    pub fn encode_unpadded(&self, text: &str, add_special_tokens: bool, policy: Option<&SpecialTokenPolicy>, truncate: bool) -> Result<EncodingDetails, Box<dyn std::error::Error>> {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => {
+                let variant = Variant { unpadded: true, untruncated: !truncate, ..Default::default() };
+                let encoding = Self::encode_huggingface(tokenizer, variant, text, add_special_tokens, policy)?;
+                Ok(EncodingDetails::from(&encoding))
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                let special_tokens_refs = tiktoken.allowed_special(text, add_special_tokens, policy)?;
+                let (tokens, _) = tiktoken.bpe.encode(text, &special_tokens_refs);
+                let mut details = tiktoken.details(text, tokens);
+                if truncate {
+                    tiktoken.truncate(&mut details);
+                }
+                Ok(details)
+            }
+        }
+    }
+
+    /// Returns the ID tensors are padded with: the pad ID of the padding of the tokenizer,
+    /// else the ID of its pad token, else 0.
+    fn tensor_pad_id(&self) -> u32 {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => tokenizer.get_padding()
+                .map(|padding| padding.pad_id)
+                .or_else(|| PAD_TOKENS.iter().find_map(|token| tokenizer.token_to_id(token))),
+            UnifiedTokenizer::Tiktoken(tiktoken) => tiktoken.padding.as_ref()
+                .map(|padding| padding.pad_id)
+                .or_else(|| tiktoken.pad_token.iter().map(String::as_str).chain(PAD_TOKENS)
+                    .find_map(|token| tiktoken.token_to_id(token))),
+        }.unwrap_or(0)
+    }
+
+    /// Encodes the texts into row-major tensors padded to the longest encoding, rounded
+    /// up to a multiple of pad_to_multiple_of unless it's 0. The encodings are truncated
+    /// by the tokenizer but padded only here: direction and pad to multiple of default to
+    /// the padding of the tokenizer, whose fixed length is kept unless pad_to_multiple_of
+    /// is set.
+    pub fn encode_batch_tensors(&self, texts: &[&str], add_special_tokens: bool, direction: Option<PaddingDirection>, pad_to_multiple_of: Option<usize>) -> Result<BatchTensors, Box<dyn std::error::Error>> {
+        let huggingface_encodings;
+        let tiktoken_encodings;
+        let (rows, padding): (Vec<(&[u32], &[u32], &[u32])>, Option<&PaddingParams>) = match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => {
+                huggingface_encodings = tokenizer.variant(Variant { unpadded: true, ..Default::default() })
+                    .encode_batch(texts.to_vec(), add_special_tokens)
                     .map_err(|e| format!("Encoding error: {}", e))?;
-                Ok(EncodingDetails {
-                    ids: encoding.get_ids().to_vec(),
-                    type_ids: Some(encoding.get_type_ids().to_vec()),
-                    tokens: Some(encoding.get_tokens().iter().map(|s| s.to_string()).collect()),
-                    special_tokens_mask: Some(encoding.get_special_tokens_mask().to_vec()),
-                    attention_mask: Some(encoding.get_attention_mask().to_vec()),
-                    offsets: Some(encoding.get_offsets().to_vec()),
-                })
+                let rows = huggingface_encodings.iter()
+                    .map(|encoding| (encoding.get_ids(), encoding.get_type_ids(), encoding.get_attention_mask()))
+                    .collect();
+                (rows, tokenizer.get_padding())
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                tiktoken_encodings = texts.iter()
+                    .map(|text| self.encode_unpadded(text, add_special_tokens, None, true))
+                    .collect::<Result<Vec<_>, _>>()?;
+                let rows = tiktoken_encodings.iter()
+                    .map(|details| (
+                        details.ids.as_slice(),
+                        details.type_ids.as_deref().unwrap_or_default(),
+                        details.attention_mask.as_deref().unwrap_or_default(),
+                    ))
+                    .collect();
+                (rows, tiktoken.padding.as_ref())
+            }
+        };
+        let left = match direction.as_ref().or(padding.map(|padding| &padding.direction)) {
+            Some(direction) => matches!(direction, PaddingDirection::Left),
+            None => false,
+        };
+        let fixed_length = match padding.map(|padding| &padding.strategy) {
+            Some(PaddingStrategy::Fixed(length)) if pad_to_multiple_of.is_none() => *length,
+            _ => 0,
+        };
+        let pad_to_multiple_of = pad_to_multiple_of.or(padding.and_then(|padding| padding.pad_to_multiple_of)).unwrap_or(0);
+        let pad_id = self.tensor_pad_id();
+        let pad_type_id = padding.map_or(0, |padding| padding.pad_type_id);
+
+        let mut cols = rows.iter().map(|(ids, _, _)| ids.len()).max().map_or(0, |longest| longest.max(fixed_length));
+        if pad_to_multiple_of > 0 && cols % pad_to_multiple_of != 0 {
+            cols += pad_to_multiple_of - cols % pad_to_multiple_of;
+        }
+        let size = rows.len() * cols;
+        let mut tensors = BatchTensors {
+            input_ids: vec![pad_id as i64; size],
+            attention_mask: vec![0; size],
+            token_type_ids: vec![pad_type_id as i64; size],
+            rows: rows.len(),
+            cols,
+        };
+        for (row, (ids, type_ids, attention_mask)) in rows.iter().enumerate() {
+            let start = row * cols + if left { cols - ids.len() } else { 0 };
+            let end = start + ids.len();
+            let copy = |dst: &mut [i64], src: &[u32]| {
+                for (dst, src) in dst.iter_mut().zip(src) {
+                    *dst = *src as i64;
+                }
+            };
+            copy(&mut tensors.input_ids[start..end], ids);
+            copy(&mut tensors.token_type_ids[start..end], type_ids);
+            copy(&mut tensors.attention_mask[start..end], attention_mask);
+        }
+        Ok(tensors)
+    }
+
+    /// Runs the normalizer of a HuggingFace tokenizer, the normalized string keeps the
+    /// alignments to the text.
+    fn normalized_string(tokenizer: &Tokenizer, text: &str) -> Result<NormalizedString, Box<dyn std::error::Error>> {
+        let mut normalized = NormalizedString::from(text);
+        if let Some(normalizer) = tokenizer.get_normalizer() {
+            normalizer.normalize(&mut normalized)
+                .map_err(|e| format!("Normalization error: {}", e))?;
+        }
+        Ok(normalized)
+    }
+
+    /// Returns the normalized text and the byte offsets in the text of every byte of
+    /// it. Tiktoken tokenizers don't normalize the text.
+    pub fn normalize(&self, text: &str) -> Result<(String, Vec<(usize, usize)>), Box<dyn std::error::Error>> {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => {
+                let normalized = Self::normalized_string(tokenizer, text)?;
+                let alignments = Self::char_alignments(normalized.get(), |start, end| {
+                    normalized.convert_offsets(tokenizers::tokenizer::normalizer::Range::Normalized(start..end))
+                        .map_or((0, 0), |range| (range.start, range.end))
+                });
+                Ok((normalized.get().to_string(), alignments))
             }
-            UnifiedTokenizer::Tiktoken(bpe, _vocab_size, special_tokens, _special_token_ids) => {
-                let special_tokens_refs = Self::get_special_tokens_refs(special_tokens, add_special_tokens);
-                let (tokens, _) = bpe.encode(text, &special_tokens_refs);
-                // Tiktoken doesn't provide the same level of detail as HuggingFace
-                Ok(EncodingDetails {
-                    ids: tokens,
-                    type_ids: None,
-                    tokens: None,
-                    special_tokens_mask: None,
-                    attention_mask: None,
-                    offsets: None,
-                })
+            UnifiedTokenizer::Tiktoken(_) => Ok((text.to_string(), Self::char_alignments(text, |start, end| (start, end)))),
+        }
+    }
+
+    /// Repeats the offsets of every character of text for each of its bytes.
+    fn char_alignments(text: &str, original: impl Fn(usize, usize) -> (usize, usize)) -> Vec<(usize, usize)> {
+        let mut alignments = Vec::with_capacity(text.len());
+        for (start, c) in text.char_indices() {
+            let offsets = original(start, start + c.len_utf8());
+            alignments.extend(std::iter::repeat(offsets).take(c.len_utf8()));
+        }
+        alignments
+    }
+
+    /// Splits the normalized text with the pre-tokenizer, or with the pattern for tiktoken
+    /// tokenizers. Returns every piece with its byte offsets in the text.
+    pub fn pre_tokenize(&self, text: &str) -> Result<Vec<(String, (usize, usize))>, Box<dyn std::error::Error>> {
+        match self {
+            UnifiedTokenizer::HuggingFace(tokenizer) => {
+                let mut pre_tokenized = PreTokenizedString::from(Self::normalized_string(tokenizer, text)?);
+                if let Some(pre_tokenizer) = tokenizer.get_pre_tokenizer() {
+                    pre_tokenizer.pre_tokenize(&mut pre_tokenized)
+                        .map_err(|e| format!("Pre-tokenization error: {}", e))?;
+                }
+                Ok(pre_tokenized.get_splits(OffsetReferential::Original, OffsetType::Byte).into_iter()
+                    .map(|(piece, offsets, _)| (piece.to_string(), offsets))
+                    .collect())
+            }
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                let pieces = tiktoken.regex.find_iter(text)
+                    .map(|piece| piece.map(|piece| (piece.as_str().to_string(), (piece.start(), piece.end()))))
+                    .collect::<Result<Vec<_>, _>>()?;
+                Ok(pieces)
             }
         }
     }
@@ -115,7 +693,7 @@ impl UnifiedTokenizer {
                 tokenizer.decode(ids, skip_special_tokens)
                     .map_err(|e| format!("Decoding error: {}", e).into())
             }
-            UnifiedTokenizer::Tiktoken(bpe, _vocab_size, _special_tokens, special_token_ids) => {
+            UnifiedTokenizer::Tiktoken(TiktokenTokenizer { bpe, special_token_ids, .. }) => {
                 let tokens_to_decode = if skip_special_tokens {
                     ids.iter()
                         .filter(|id| !special_token_ids.contains(id))
@@ -158,7 +736,7 @@ impl UnifiedTokenizer {
     pub fn vocab_size(&self) -> u32 {
         match self {
             UnifiedTokenizer::HuggingFace(tokenizer) => tokenizer.get_vocab_size(true) as u32,
-            UnifiedTokenizer::Tiktoken(_bpe, vocab_size, _special_tokens, _special_token_ids) => *vocab_size
+            UnifiedTokenizer::Tiktoken(tiktoken) => tiktoken.vocab_size
         }
     }
 
@@ -167,20 +745,23 @@ impl UnifiedTokenizer {
             UnifiedTokenizer::HuggingFace(ref mut tokenizer) => {
                 tokenizer.set_encode_special_tokens(encode_special_tokens);
             }
-            UnifiedTokenizer::Tiktoken(_, _, _, _) => {
-                // Silently ignore for Tiktoken since it doesn't support this operation
-                // This is safer than panicking in a library
+            UnifiedTokenizer::Tiktoken(tiktoken) => {
+                tiktoken.encode_special_tokens = encode_special_tokens;
             }
         }
     }
-    
-    pub fn supports_encode_special_tokens(&self) -> bool {
-        matches!(self, UnifiedTokenizer::HuggingFace(_))
-    }
-
+}
 
+/// Row-major tensors of shape [rows, cols] of a batch of encodings.
+pub struct BatchTensors {
+    pub input_ids: Vec<i64>,
+    pub attention_mask: Vec<i64>,
+    pub token_type_ids: Vec<i64>,
+    pub rows: usize,
+    pub cols: usize,
 }
 
+#[derive(Default)]
 pub struct EncodingDetails {
     pub ids: Vec<u32>,
     pub type_ids: Option<Vec<u32>>,
@@ -188,11 +769,287 @@ pub struct EncodingDetails {
     pub special_tokens_mask: Option<Vec<u32>>,
     pub attention_mask: Option<Vec<u32>>,
     pub offsets: Option<Vec<(usize, usize)>>,
+    pub word_ids: Option<Vec<Option<u32>>>,
+    pub sequence_ids: Option<Vec<Option<usize>>>,
+    /// Parts of the encoding removed by truncation
+    pub overflowing: Vec<EncodingDetails>,
+}
+
+impl From<&tokenizers::Encoding> for EncodingDetails {
+    fn from(encoding: &tokenizers::Encoding) -> Self {
+        EncodingDetails {
+            ids: encoding.get_ids().to_vec(),
+            type_ids: Some(encoding.get_type_ids().to_vec()),
+            tokens: Some(encoding.get_tokens().iter().map(|s| s.to_string()).collect()),
+            special_tokens_mask: Some(encoding.get_special_tokens_mask().to_vec()),
+            attention_mask: Some(encoding.get_attention_mask().to_vec()),
+            offsets: Some(encoding.get_offsets().to_vec()),
+            word_ids: Some(encoding.get_word_ids().to_vec()),
+            sequence_ids: Some(encoding.get_sequence_ids()),
+            overflowing: encoding.get_overflowing().iter().map(EncodingDetails::from).collect(),
+        }
+    }
+}
+
+impl EncodingDetails {
+    fn slice(&self, start: usize, stop: usize) -> EncodingDetails {
+        EncodingDetails {
+            ids: self.ids[start..stop].to_vec(),
+            type_ids: self.type_ids.as_ref().map(|v| v[start..stop].to_vec()),
+            tokens: self.tokens.as_ref().map(|v| v[start..stop].to_vec()),
+            special_tokens_mask: self.special_tokens_mask.as_ref().map(|v| v[start..stop].to_vec()),
+            attention_mask: self.attention_mask.as_ref().map(|v| v[start..stop].to_vec()),
+            offsets: self.offsets.as_ref().map(|v| v[start..stop].to_vec()),
+            word_ids: self.word_ids.as_ref().map(|v| v[start..stop].to_vec()),
+            sequence_ids: self.sequence_ids.as_ref().map(|v| v[start..stop].to_vec()),
+            overflowing: Vec::new(),
+        }
+    }
+
+    /// Appends the tokens of other, attributes missing from either encoding are dropped.
+    fn extend(&mut self, other: EncodingDetails) {
+        fn extend_vec<T>(v: &mut Option<Vec<T>>, other: Option<Vec<T>>) {
+            match (v.as_mut(), other) {
+                (Some(v), Some(other)) => v.extend(other),
+                _ => *v = None,
+            }
+        }
+        self.ids.extend(other.ids);
+        extend_vec(&mut self.type_ids, other.type_ids);
+        extend_vec(&mut self.tokens, other.tokens);
+        extend_vec(&mut self.special_tokens_mask, other.special_tokens_mask);
+        extend_vec(&mut self.attention_mask, other.attention_mask);
+        extend_vec(&mut self.offsets, other.offsets);
+        extend_vec(&mut self.word_ids, other.word_ids);
+        extend_vec(&mut self.sequence_ids, other.sequence_ids);
+    }
+
+    /// Truncates the encoding to max_length tokens like HuggingFace's Encoding::truncate,
+    /// the removed tokens are split into overflowing encodings overlapping by stride tokens.
+    /// The stride must be smaller than max_length.
+    fn truncate(&mut self, max_length: usize, stride: usize, direction: &tokenizers::tokenizer::TruncationDirection) {
+        let len = self.ids.len();
+        if len <= max_length {
+            return;
+        }
+        if max_length == 0 {
+            let all = std::mem::take(self);
+            self.type_ids = all.type_ids.as_ref().map(|_| Vec::new());
+            self.tokens = all.tokens.as_ref().map(|_| Vec::new());
+            self.special_tokens_mask = all.special_tokens_mask.as_ref().map(|_| Vec::new());
+            self.attention_mask = all.attention_mask.as_ref().map(|_| Vec::new());
+            self.offsets = all.offsets.as_ref().map(|_| Vec::new());
+            self.word_ids = all.word_ids.as_ref().map(|_| Vec::new());
+            self.sequence_ids = all.sequence_ids.as_ref().map(|_| Vec::new());
+            self.overflowing.push(all);
+            return;
+        }
+
+        let step = max_length - stride;
+        let mut ranges = Vec::new();
+        match direction {
+            tokenizers::tokenizer::TruncationDirection::Right => {
+                let mut start = 0;
+                loop {
+                    let stop = std::cmp::min(start + max_length, len);
+                    ranges.push((start, stop));
+                    if stop == len {
+                        break;
+                    }
+                    start += step;
+                }
+            }
+            tokenizers::tokenizer::TruncationDirection::Left => {
+                let mut stop = len;
+                loop {
+                    let start = stop.saturating_sub(max_length);
+                    ranges.push((start, stop));
+                    if start == 0 {
+                        break;
+                    }
+                    stop -= step;
+                }
+            }
+        }
+        let mut parts: Vec<EncodingDetails> = ranges.into_iter().map(|(start, stop)| self.slice(start, stop)).collect();
+        let mut truncated = parts.remove(0);
+        truncated.overflowing = parts;
+        *self = truncated;
+    }
+
+    /// Pads the encoding and its overflowing encodings to length tokens.
+    fn pad(&mut self, length: usize, pad_id: u32, pad_type_id: u32, pad_token: &str, direction: &PaddingDirection) {
+        for overflowing in &mut self.overflowing {
+            overflowing.pad(length, pad_id, pad_type_id, pad_token, direction);
+        }
+        if self.ids.len() >= length {
+            return;
+        }
+        let n = length - self.ids.len();
+        let left = matches!(direction, PaddingDirection::Left);
+        fn pad_vec<T: Clone>(v: &mut Vec<T>, n: usize, value: T, left: bool) {
+            if left {
+                v.splice(0..0, std::iter::repeat(value).take(n));
+            } else {
+                v.extend(std::iter::repeat(value).take(n));
+            }
+        }
+        pad_vec(&mut self.ids, n, pad_id, left);
+        if let Some(type_ids) = &mut self.type_ids {
+            pad_vec(type_ids, n, pad_type_id, left);
+        }
+        if let Some(tokens) = &mut self.tokens {
+            pad_vec(tokens, n, pad_token.to_string(), left);
+        }
+        if let Some(special_tokens_mask) = &mut self.special_tokens_mask {
+            pad_vec(special_tokens_mask, n, 1, left);
+        }
+        if let Some(attention_mask) = &mut self.attention_mask {
+            pad_vec(attention_mask, n, 0, left);
+        }
+        if let Some(offsets) = &mut self.offsets {
+            pad_vec(offsets, n, (0, 0), left);
+        }
+        if let Some(word_ids) = &mut self.word_ids {
+            pad_vec(word_ids, n, None, left);
+        }
+        if let Some(sequence_ids) = &mut self.sequence_ids {
+            pad_vec(sequence_ids, n, None, left);
+        }
+    }
 }
 
 #[repr(C)]
 pub struct tokenizers_options {
     encode_special_tokens: bool,
+    // Format of the names given to missing tiktoken ranks, "%d" is replaced by
+    // the index of the reserved token. Defaults to DEFAULT_RESERVED_TOKEN_FORMAT if null.
+    reserved_token_format: *const libc::c_char,
+
+    truncation: bool,
+    max_length: usize,
+    truncation_direction: u8,
+    stride: usize,
+
+    padding: bool,
+    // 0 pads to the longest encoding of the batch
+    pad_length: usize,
+    padding_direction: u8,
+    // 0 disables rounding
+    pad_to_multiple_of: usize,
+    // Null to use the default pad token of the tokenizer
+    pad_token: *const libc::c_char,
+
+    // Tokens added to the vocabulary of Hugging Face tokenizers
+    added_tokens: *const *const libc::c_char,
+    added_tokens_len: usize,
+    added_special_tokens: *const *const libc::c_char,
+    added_special_tokens_len: usize,
+}
+
+/// Reads a list of strings passed over FFI, skipping null entries.
+fn c_string_list<'a>(strings: *const *const libc::c_char, len: usize) -> Result<Vec<&'a str>, std::str::Utf8Error> {
+    if strings.is_null() {
+        return Ok(Vec::new());
+    }
+    unsafe { std::slice::from_raw_parts(strings, len) }.iter()
+        .filter(|s| !s.is_null())
+        .map(|&s| unsafe { CStr::from_ptr(s) }.to_str())
+        .collect()
+}
+
+/// Applies options shared by all kinds of tokenizers.
+fn apply_options(unified: &mut UnifiedTokenizer, opts: &tokenizers_options) -> Result<(), String> {
+    unified.set_encode_special_tokens(opts.encode_special_tokens);
+
+    let truncation = if opts.truncation {
+        let direction = TruncationDirection::from_u8(opts.truncation_direction)
+            .ok_or_else(|| format!("Invalid truncation direction: {}", opts.truncation_direction))?
+            .to_tokenizers_direction();
+        if opts.max_length > 0 && opts.stride >= opts.max_length {
+            return Err(format!("Truncation stride {} must be smaller than max length {}", opts.stride, opts.max_length));
+        }
+        Some(TruncationParams {
+            max_length: opts.max_length,
+            stride: opts.stride,
+            direction,
+            ..Default::default()
+        })
+    } else {
+        None
+    };
+
+    let padding_token = if opts.pad_token.is_null() {
+        None
+    } else {
+        let token = unsafe { CStr::from_ptr(opts.pad_token) }.to_str()
+            .map_err(|e| format!("Invalid UTF-8 in pad token: {}", e))?;
+        Some(token.to_string())
+    };
+    let added_tokens = c_string_list(opts.added_tokens, opts.added_tokens_len)
+        .map_err(|e| format!("Invalid UTF-8 in added token: {}", e))?;
+    let added_special_tokens = c_string_list(opts.added_special_tokens, opts.added_special_tokens_len)
+        .map_err(|e| format!("Invalid UTF-8 in added token: {}", e))?;
+    let padding_params = |pad_token: String, pad_id: u32| -> Result<PaddingParams, String> {
+        let direction = match opts.padding_direction {
+            0 => PaddingDirection::Left,
+            1 => PaddingDirection::Right,
+            dir => return Err(format!("Invalid padding direction: {}", dir)),
+        };
+        Ok(PaddingParams {
+            strategy: if opts.pad_length == 0 { PaddingStrategy::BatchLongest } else { PaddingStrategy::Fixed(opts.pad_length) },
+            direction,
+            pad_to_multiple_of: if opts.pad_to_multiple_of == 0 { None } else { Some(opts.pad_to_multiple_of) },
+            pad_id,
+            pad_type_id: 0,
+            pad_token,
+        })
+    };
+
+    match unified {
+        UnifiedTokenizer::HuggingFace(tokenizer) => {
+            if !opts.reserved_token_format.is_null() {
+                return Err("Reserved token format is only supported by tiktoken tokenizers".to_string());
+            }
+            // added before padding, so that the pad token may be one of them
+            let to_added = |tokens: &[&str], special: bool| -> Vec<tokenizers::AddedToken> {
+                tokens.iter().map(|&token| tokenizers::AddedToken::from(token, special)).collect()
+            };
+            if !added_tokens.is_empty() {
+                tokenizer.add_tokens(&to_added(&added_tokens, false));
+            }
+            if !added_special_tokens.is_empty() {
+                tokenizer.add_special_tokens(&to_added(&added_special_tokens, true));
+            }
+            if truncation.is_some() {
+                tokenizer.with_truncation(truncation)
+                    .map_err(|e| format!("Failed to set truncation parameters: {}", e))?;
+            }
+            if opts.padding {
+                let pad_token = padding_token
+                    .or_else(|| tokenizer.get_padding().map(|padding| padding.pad_token.clone()))
+                    .ok_or("Padding requires a pad token")?;
+                let pad_id = tokenizer.token_to_id(&pad_token)
+                    .ok_or_else(|| format!("Pad token {} is not in the vocabulary", pad_token))?;
+                tokenizer.with_padding(Some(padding_params(pad_token, pad_id)?));
+            }
+        }
+        UnifiedTokenizer::Tiktoken(tiktoken) => {
+            if !added_tokens.is_empty() || !added_special_tokens.is_empty() {
+                return Err("Added tokens are only supported by Hugging Face tokenizers".to_string());
+            }
+            tiktoken.truncation = truncation;
+            if opts.padding {
+                let pad_token = padding_token
+                    .or_else(|| tiktoken.pad_token.clone())
+                    .ok_or("Padding requires a pad token")?;
+                let pad_id = tiktoken.token_to_id(&pad_token)
+                    .ok_or_else(|| format!("Pad token {} is not in the vocabulary", pad_token))?;
+                tiktoken.padding = Some(padding_params(pad_token, pad_id)?);
+            }
+        }
+    }
+    Ok(())
 }
 
 #[repr(C)]
@@ -203,39 +1060,52 @@ pub struct tokenizers_buffer {
     attention_mask: *mut u32,
     tokens: *mut *mut libc::c_char,
     offsets: *mut usize,
+    // -1 for tokens without a word or sequence, e.g. special tokens added by the post-processor
+    word_ids: *mut i32,
+    sequence_ids: *mut i32,
     len: usize,
+    // Set if encoding failed, freed with tokenizers_free_string.
+    error: *mut libc::c_char,
+    // Set if the text contains a disallowed special token, freed with tokenizers_free_string.
+    disallowed_special: *mut libc::c_char,
+    // Parts of the encoding removed by truncation.
+    overflowing: *mut tokenizers_buffer,
+    overflowing_len: usize,
 }
 
-#[no_mangle]
-pub extern "C" fn tokenizers_from_bytes(bytes: *const u8, len: u32, opts: &tokenizers_options, error: *mut *mut libc::c_char) -> *mut libc::c_void {
-    if bytes.is_null() {
-        if !error.is_null() {
-            let err_msg = std::ffi::CString::new("Bytes pointer is null").unwrap();
-            unsafe { *error = err_msg.into_raw(); }
+impl tokenizers_buffer {
+    fn empty() -> Self {
+        tokenizers_buffer {
+            ids: ptr::null_mut(),
+            type_ids: ptr::null_mut(),
+            special_tokens_mask: ptr::null_mut(),
+            attention_mask: ptr::null_mut(),
+            tokens: ptr::null_mut(),
+            offsets: ptr::null_mut(),
+            word_ids: ptr::null_mut(),
+            sequence_ids: ptr::null_mut(),
+            len: 0,
+            error: ptr::null_mut(),
+            disallowed_special: ptr::null_mut(),
+            overflowing: ptr::null_mut(),
+            overflowing_len: 0,
         }
-        return ptr::null_mut();
     }
-    
-    let bytes_slice = unsafe { std::slice::from_raw_parts(bytes, len as usize) };
-    match Tokenizer::from_bytes(bytes_slice) {
-        Ok(mut tokenizer) => {
-            tokenizer.set_encode_special_tokens(opts.encode_special_tokens);
-            let unified = UnifiedTokenizer::HuggingFace(tokenizer);
-            Box::into_raw(Box::new(unified)).cast()
-        }
-        Err(e) => {
-            if !error.is_null() {
-                let err_msg = std::ffi::CString::new(format!("Failed to create tokenizer from bytes: {}", e)).unwrap();
-                unsafe { *error = err_msg.into_raw(); }
-            }
-            ptr::null_mut()
+
+    fn error(err: &(dyn std::error::Error + 'static)) -> Self {
+        let mut buffer = Self::empty();
+        buffer.error = std::ffi::CString::new(err.to_string().replace('\0', ""))
+            .map_or(ptr::null_mut(), std::ffi::CString::into_raw);
+        if let Some(err) = err.downcast_ref::<DisallowedSpecialTokenError>() {
+            buffer.disallowed_special = std::ffi::CString::new(err.token.replace('\0', ""))
+                .map_or(ptr::null_mut(), std::ffi::CString::into_raw);
         }
+        buffer
     }
 }
 
-// TODO merge with from_bytes and pass truncation params as an argument to TokenizerOptions
 #[no_mangle]
-pub extern "C" fn tokenizers_from_bytes_with_truncation(bytes: *const u8, len: u32, max_len: usize, dir: u8, error: *mut *mut libc::c_char) -> *mut libc::c_void {
+pub extern "C" fn tokenizers_from_bytes(bytes: *const u8, len: u32, opts: &tokenizers_options, error: *mut *mut libc::c_char) -> *mut libc::c_void {
     if bytes.is_null() {
         if !error.is_null() {
             let err_msg = std::ffi::CString::new("Bytes pointer is null").unwrap();
@@ -245,37 +1115,17 @@ pub extern "C" fn tokenizers_from_bytes_with_truncation(bytes: *const u8, len: u
     }
     
     let bytes_slice = unsafe { std::slice::from_raw_parts(bytes, len as usize) };
-    let direction = match TruncationDirection::from_u8(dir) {
-        Some(d) => d.to_tokenizers_direction(),
-        None => {
-            if !error.is_null() {
-                let err_msg = std::ffi::CString::new(format!("Invalid truncation direction: {}", dir)).unwrap();
-                unsafe { *error = err_msg.into_raw(); }
-            }
-            return ptr::null_mut();
-        }
-    };
-    
     match Tokenizer::from_bytes(bytes_slice) {
-        Ok(mut tokenizer) => {
-            let truncation_params = tokenizers::tokenizer::TruncationParams {
-                max_length: max_len,
-                direction,
-                ..Default::default()
-            };
-            match tokenizer.with_truncation(Some(truncation_params)) {
-                Ok(tokenizer_with_truncation) => {
-                    let unified = UnifiedTokenizer::HuggingFace(tokenizer_with_truncation.to_owned().into());
-                    Box::into_raw(Box::new(unified)).cast()
-                }
-                Err(e) => {
-                    if !error.is_null() {
-                        let err_msg = std::ffi::CString::new(format!("Failed to set truncation parameters: {}", e)).unwrap();
-                        unsafe { *error = err_msg.into_raw(); }
-                    }
-                    ptr::null_mut()
+        Ok(tokenizer) => {
+            let mut unified = UnifiedTokenizer::HuggingFace(HuggingFaceTokenizer::new(tokenizer));
+            if let Err(e) = apply_options(&mut unified, opts) {
+                if !error.is_null() {
+                    let err_msg = std::ffi::CString::new(e).unwrap_or_default();
+                    unsafe { *error = err_msg.into_raw(); }
                 }
+                return ptr::null_mut();
             }
+            Box::into_raw(Box::new(unified)).cast()
         }
         Err(e) => {
             if !error.is_null() {
@@ -288,7 +1138,7 @@ pub extern "C" fn tokenizers_from_bytes_with_truncation(bytes: *const u8, len: u
 }
 
 #[no_mangle]
-pub extern "C" fn tokenizers_from_file(config: *const libc::c_char, error: *mut *mut libc::c_char) -> *mut libc::c_void {
+pub extern "C" fn tokenizers_from_file(config: *const libc::c_char, opts: &tokenizers_options, error: *mut *mut libc::c_char) -> *mut libc::c_void {
     if config.is_null() {
         if !error.is_null() {
             let err_msg = std::ffi::CString::new("Config path is null").unwrap();
@@ -312,9 +1162,15 @@ pub extern "C" fn tokenizers_from_file(config: *const libc::c_char, error: *mut
     let config_path = PathBuf::from(config_str);
     match Tokenizer::from_file(&config_path) {
         Ok(tokenizer) => {
-            let unified = UnifiedTokenizer::HuggingFace(tokenizer);
-            let ptr = Box::into_raw(Box::new(unified));
-            ptr.cast()
+            let mut unified = UnifiedTokenizer::HuggingFace(HuggingFaceTokenizer::new(tokenizer));
+            if let Err(e) = apply_options(&mut unified, opts) {
+                if !error.is_null() {
+                    let err_msg = std::ffi::CString::new(e).unwrap_or_default();
+                    unsafe { *error = err_msg.into_raw(); }
+                }
+                return ptr::null_mut();
+            }
+            Box::into_raw(Box::new(unified)).cast()
         }
         Err(e) => {
             if !error.is_null() {
@@ -378,13 +1234,125 @@ pub extern "C" fn tokenizers_from_tiktoken(
     };
     
     match create_tiktoken_encoder(model_file_str, config_file_str, pattern_str) {
-        Ok((bpe, vocab_size, special_tokens, special_token_ids)) => {
-            let unified = UnifiedTokenizer::Tiktoken(bpe, vocab_size, special_tokens, special_token_ids);
+        Ok(tiktoken) => {
+            let unified = UnifiedTokenizer::Tiktoken(tiktoken);
+            Box::into_raw(Box::new(unified)).cast()
+        }
+        Err(e) => {
+            if !error.is_null() {
+                let err_msg = std::ffi::CString::new(format!("Failed to create tiktoken tokenizer: {}", e)).unwrap();
+                unsafe { *error = err_msg.into_raw(); }
+            }
+            ptr::null_mut()
+        }
+    }
+}
+
+#[no_mangle]
+pub extern "C" fn tokenizers_from_tiktoken_bytes(
+    model: *const u8,
+    model_len: u32,
+    config: *const u8,
+    config_len: u32,
+    pattern: *const libc::c_char,
+    opts: &tokenizers_options,
+    error: *mut *mut libc::c_char,
+) -> *mut libc::c_void {
+    if model.is_null() || config.is_null() || pattern.is_null() {
+        if !error.is_null() {
+            let err_msg = std::ffi::CString::new("One or more required parameters are null").unwrap();
+            unsafe { *error = err_msg.into_raw(); }
+        }
+        return ptr::null_mut();
+    }
+
+    let model_slice = unsafe { std::slice::from_raw_parts(model, model_len as usize) };
+    let config_slice = unsafe { std::slice::from_raw_parts(config, config_len as usize) };
+    let pattern_cstr = unsafe { CStr::from_ptr(pattern) };
+    let pattern_str = match pattern_cstr.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            if !error.is_null() {
+                let err_msg = std::ffi::CString::new(format!("Invalid UTF-8 in pattern: {}", e)).unwrap();
+                unsafe { *error = err_msg.into_raw(); }
+            }
+            return ptr::null_mut();
+        }
+    };
+    let reserved_token_format = if opts.reserved_token_format.is_null() {
+        DEFAULT_RESERVED_TOKEN_FORMAT
+    } else {
+        match unsafe { CStr::from_ptr(opts.reserved_token_format) }.to_str() {
+            Ok(s) => s,
+            Err(e) => {
+                if !error.is_null() {
+                    let err_msg = std::ffi::CString::new(format!("Invalid UTF-8 in reserved token format: {}", e)).unwrap();
+                    unsafe { *error = err_msg.into_raw(); }
+                }
+                return ptr::null_mut();
+            }
+        }
+    };
+
+    match create_tiktoken_encoder_from_bytes(model_slice, config_slice, pattern_str, reserved_token_format) {
+        Ok(tiktoken) => {
+            let mut unified = UnifiedTokenizer::Tiktoken(tiktoken);
+            if let Err(e) = apply_options(&mut unified, opts) {
+                if !error.is_null() {
+                    let err_msg = std::ffi::CString::new(e).unwrap_or_default();
+                    unsafe { *error = err_msg.into_raw(); }
+                }
+                return ptr::null_mut();
+            }
+            Box::into_raw(Box::new(unified)).cast()
+        }
+        Err(e) => {
+            if !error.is_null() {
+                let err_msg = std::ffi::CString::new(format!("Failed to create tiktoken tokenizer: {}", e)).unwrap();
+                unsafe { *error = err_msg.into_raw(); }
+            }
+            ptr::null_mut()
+        }
+    }
+}
+
+#[no_mangle]
+pub extern "C" fn tokenizers_from_tekken(path: *const libc::c_char, opts: &tokenizers_options, error: *mut *mut libc::c_char) -> *mut libc::c_void {
+    if path.is_null() {
+        if !error.is_null() {
+            let err_msg = std::ffi::CString::new("Tekken path is null").unwrap();
+            unsafe { *error = err_msg.into_raw(); }
+        }
+        return ptr::null_mut();
+    }
+
+    let path_cstr = unsafe { CStr::from_ptr(path) };
+    let path_str = match path_cstr.to_str() {
+        Ok(s) => s,
+        Err(e) => {
+            if !error.is_null() {
+                let err_msg = std::ffi::CString::new(format!("Invalid UTF-8 in tekken path: {}", e)).unwrap();
+                unsafe { *error = err_msg.into_raw(); }
+            }
+            return ptr::null_mut();
+        }
+    };
+
+    match create_tekken_encoder(path_str) {
+        Ok(tiktoken) => {
+            let mut unified = UnifiedTokenizer::Tiktoken(tiktoken);
+            if let Err(e) = apply_options(&mut unified, opts) {
+                if !error.is_null() {
+                    let err_msg = std::ffi::CString::new(e).unwrap_or_default();
+                    unsafe { *error = err_msg.into_raw(); }
+                }
+                return ptr::null_mut();
+            }
             Box::into_raw(Box::new(unified)).cast()
         }
         Err(e) => {
             if !error.is_null() {
-                let err_msg = std::ffi::CString::new(format!("Failed to create tiktoken tokenizer: {}", e)).unwrap();
+                let err_msg = std::ffi::CString::new(format!("Failed to create tekken tokenizer: {}", e)).unwrap();
                 unsafe { *error = err_msg.into_raw(); }
             }
             ptr::null_mut()
@@ -401,34 +1369,48 @@ pub struct tokenizers_encode_options {
     return_special_tokens_mask: bool,
     return_attention_mask: bool,
     return_offsets: bool,
+    return_word_ids: bool,
+
+    // Special token policy, see SpecialTokenPolicy. Ignored unless special_policy is set,
+    // the *_all flags take precedence over the token lists.
+    special_policy: bool,
+    allowed_special: *const *const libc::c_char,
+    allowed_special_len: usize,
+    allow_all_special: bool,
+    disallowed_special: *const *const libc::c_char,
+    disallowed_special_len: usize,
+    disallow_all_special: bool,
+
+    // Ignores the truncation and padding of the tokenizer, only used by tokenizers_encode.
+    without_truncation_padding: bool,
+}
+
+/// Reads a special token set passed over FFI.
+fn special_token_set<'a>(all: bool, tokens: *const *const libc::c_char, len: usize) -> Result<SpecialTokenSet<'a>, std::str::Utf8Error> {
+    if all {
+        return Ok(SpecialTokenSet::All);
+    }
+    let mut set = HashSet::with_capacity(len);
+    if !tokens.is_null() {
+        for &token in unsafe { std::slice::from_raw_parts(tokens, len) } {
+            if !token.is_null() {
+                set.insert(unsafe { CStr::from_ptr(token) }.to_str()?);
+            }
+        }
+    }
+    Ok(SpecialTokenSet::Tokens(set))
 }
 
 #[no_mangle]
 pub extern "C" fn tokenizers_encode(ptr: *mut libc::c_void, message: *const libc::c_char, options: &tokenizers_encode_options) -> tokenizers_buffer {
     if ptr.is_null() || message.is_null() {
-        return tokenizers_buffer { 
-            ids: ptr::null_mut(), 
-            tokens: ptr::null_mut(), 
-            len: 0, 
-            type_ids: ptr::null_mut(), 
-            special_tokens_mask: ptr::null_mut(), 
-            attention_mask: ptr::null_mut(), 
-            offsets: ptr::null_mut()
-        };
+        return tokenizers_buffer::empty();
     }
     
     let unified_tokenizer = unsafe {
         match ptr.cast::<UnifiedTokenizer>().as_ref() {
             Some(tokenizer) => tokenizer,
-            None => return tokenizers_buffer { 
-                ids: ptr::null_mut(), 
-                tokens: ptr::null_mut(), 
-                len: 0, 
-                type_ids: ptr::null_mut(), 
-                special_tokens_mask: ptr::null_mut(), 
-                attention_mask: ptr::null_mut(), 
-                offsets: ptr::null_mut()
-            }
+            None => return tokenizers_buffer::empty(),
         }
     };
     
@@ -440,19 +1422,104 @@ pub extern "C" fn tokenizers_encode(ptr: *mut libc::c_void, message: *const libc
     let message_cow = String::from_utf8_lossy(message_bytes);
     let message = message_cow.as_ref();
 
-    let encoding_details = match std::panic::catch_unwind(|| { unified_tokenizer.encode_with_details(message, options.add_special_tokens) }) {
-        Ok(Ok(details)) => details,
-        Ok(Err(_)) | Err(_) => return tokenizers_buffer {
-            ids: ptr::null_mut(),
-            tokens: ptr::null_mut(),
-            len: 0,
-            type_ids: ptr::null_mut(),
-            special_tokens_mask: ptr::null_mut(),
-            attention_mask: ptr::null_mut(),
-            offsets: ptr::null_mut()
+    let policy = if options.special_policy {
+        let allowed = special_token_set(options.allow_all_special, options.allowed_special, options.allowed_special_len);
+        let disallowed = special_token_set(options.disallow_all_special, options.disallowed_special, options.disallowed_special_len);
+        match (allowed, disallowed) {
+            (Ok(allowed), Ok(disallowed)) => Some(SpecialTokenPolicy { allowed, disallowed }),
+            (Err(e), _) | (_, Err(e)) => return tokenizers_buffer::error(&e),
         }
+    } else {
+        None
+    };
+
+    let encode = || if options.without_truncation_padding {
+        unified_tokenizer.encode_unpadded(message, options.add_special_tokens, policy.as_ref(), false)
+    } else {
+        unified_tokenizer.encode_with_details(message, options.add_special_tokens, policy.as_ref())
+    };
+    let encoding_details = match std::panic::catch_unwind(encode) {
+        Ok(Ok(details)) => details,
+        Ok(Err(e)) => return tokenizers_buffer::error(e.as_ref()),
+        Err(_) => return tokenizers_buffer::empty(),
     };
     
+    encoding_buffer(encoding_details, options)
+}
+
+#[no_mangle]
+pub extern "C" fn tokenizers_encode_words(
+    ptr: *mut libc::c_void,
+    words: *const *const libc::c_char,
+    words_len: usize,
+    pair: *const *const libc::c_char,
+    pair_len: usize,
+    is_pair: bool,
+    options: &tokenizers_encode_options,
+) -> tokenizers_buffer {
+    if ptr.is_null() {
+        return tokenizers_buffer::empty();
+    }
+
+    let unified_tokenizer = unsafe {
+        match ptr.cast::<UnifiedTokenizer>().as_ref() {
+            Some(tokenizer) => tokenizer,
+            None => return tokenizers_buffer::empty(),
+        }
+    };
+
+    let words = match c_string_list(words, words_len) {
+        Ok(words) => words,
+        Err(e) => return tokenizers_buffer::error(&e),
+    };
+    let pair = if !is_pair {
+        None
+    } else {
+        match c_string_list(pair, pair_len) {
+            Ok(pair) => Some(pair),
+            Err(e) => return tokenizers_buffer::error(&e),
+        }
+    };
+
+    let encoding_details = match std::panic::catch_unwind(|| { unified_tokenizer.encode_words(&words, pair.as_deref(), options.add_special_tokens) }) {
+        Ok(Ok(details)) => details,
+        Ok(Err(e)) => return tokenizers_buffer::error(e.as_ref()),
+        Err(_) => return tokenizers_buffer::empty(),
+    };
+
+    encoding_buffer(encoding_details, options)
+}
+
+/// Moves strings to a C array of C strings, NUL characters are replaced.
+fn c_string_array(strings: Vec<String>) -> *mut *mut libc::c_char {
+    let mut vec_strings = Vec::with_capacity(strings.len());
+    for string in strings {
+        let sanitized = string.replace('\0', "\u{FFFD}");
+        let c_string = std::ffi::CString::new(sanitized)
+            .unwrap_or_else(|_| std::ffi::CString::new("").expect("empty string is a valid C string"));
+        vec_strings.push(c_string.into_raw());
+    }
+    vec_strings.shrink_to_fit();
+    let ptr = vec_strings.as_mut_ptr();
+    std::mem::forget(vec_strings);
+    ptr
+}
+
+/// Moves offsets to a C array of start and end pairs.
+fn offsets_array(offsets: Vec<(usize, usize)>) -> *mut usize {
+    let mut vec_offsets = Vec::with_capacity(offsets.len() * 2);
+    for (start, end) in offsets {
+        vec_offsets.push(start);
+        vec_offsets.push(end);
+    }
+    vec_offsets.shrink_to_fit();
+    let ptr = vec_offsets.as_mut_ptr();
+    std::mem::forget(vec_offsets);
+    ptr
+}
+
+/// Moves the requested parts of the encoding to a buffer, freed with tokenizers_free_buffer.
+fn encoding_buffer(encoding_details: EncodingDetails, options: &tokenizers_encode_options) -> tokenizers_buffer {
     let mut vec_ids = encoding_details.ids;
     vec_ids.shrink_to_fit();
     let ids = vec_ids.as_mut_ptr();
@@ -471,16 +1538,7 @@ pub extern "C" fn tokenizers_encode(ptr: *mut libc::c_void, message: *const libc
     let mut tokens: *mut *mut libc::c_char = ptr::null_mut();
     if options.return_tokens {
         if let Some(token_strings) = encoding_details.tokens {
-            let mut vec_tokens = Vec::with_capacity(token_strings.len());
-            for token in token_strings {
-                let sanitized = token.replace('\0', "\u{FFFD}");
-                let c_string = std::ffi::CString::new(sanitized)
-                    .unwrap_or_else(|_| std::ffi::CString::new("").expect("empty string is a valid C string"));
-                vec_tokens.push(c_string.into_raw());
-            }
-            vec_tokens.shrink_to_fit();
-            tokens = vec_tokens.as_mut_ptr();
-            std::mem::forget(vec_tokens);
+            tokens = c_string_array(token_strings);
         }
     }
 
@@ -505,18 +1563,266 @@ pub extern "C" fn tokenizers_encode(ptr: *mut libc::c_void, message: *const libc
     let mut offsets: *mut usize = ptr::null_mut();
     if options.return_offsets {
         if let Some(vec_offsets_tuples) = encoding_details.offsets {
-            let mut vec_offsets = Vec::with_capacity(vec_offsets_tuples.len() * 2);
-            for i in vec_offsets_tuples {
-                vec_offsets.push(i.0);
-                vec_offsets.push(i.1);
-            }
-            vec_offsets.shrink_to_fit();
-            offsets = vec_offsets.as_mut_ptr();
-            std::mem::forget(vec_offsets);
+            offsets = offsets_array(vec_offsets_tuples);
+        }
+    }
+
+    let mut word_ids: *mut i32 = ptr::null_mut();
+    let mut sequence_ids: *mut i32 = ptr::null_mut();
+    if options.return_word_ids {
+        fn to_i32<T: TryInto<i32>>(ids: Vec<Option<T>>) -> *mut i32 {
+            let mut vec_ids: Vec<i32> = ids.into_iter()
+                .map(|id| id.and_then(|id| id.try_into().ok()).unwrap_or(-1))
+                .collect();
+            vec_ids.shrink_to_fit();
+            let ptr = vec_ids.as_mut_ptr();
+            std::mem::forget(vec_ids);
+            ptr
+        }
+        if let Some(vec_word_ids) = encoding_details.word_ids {
+            word_ids = to_i32(vec_word_ids);
+        }
+        if let Some(vec_sequence_ids) = encoding_details.sequence_ids {
+            sequence_ids = to_i32(vec_sequence_ids);
+        }
+    }
+
+    let mut overflowing: *mut tokenizers_buffer = ptr::null_mut();
+    let overflowing_len = encoding_details.overflowing.len();
+    if overflowing_len > 0 {
+        let mut vec_overflowing: Vec<tokenizers_buffer> = encoding_details.overflowing.into_iter()
+            .map(|details| encoding_buffer(details, options))
+            .collect();
+        vec_overflowing.shrink_to_fit();
+        overflowing = vec_overflowing.as_mut_ptr();
+        std::mem::forget(vec_overflowing);
+    }
+
+    tokenizers_buffer {
+        ids, type_ids, special_tokens_mask, attention_mask, tokens, offsets, word_ids, sequence_ids, len,
+        error: ptr::null_mut(),
+        disallowed_special: ptr::null_mut(),
+        overflowing,
+        overflowing_len,
+    }
+}
+
+/// Reads the text of an FFI call, invalid UTF-8 is replaced like in tokenizers_encode.
+fn lossy_text<'a>(text: *const libc::c_char) -> std::borrow::Cow<'a, str> {
+    String::from_utf8_lossy(unsafe { CStr::from_ptr(text) }.to_bytes())
+}
+
+/// Returns the tokenizer behind an FFI pointer.
+fn unified_tokenizer<'a>(ptr: *mut libc::c_void) -> Option<&'a UnifiedTokenizer> {
+    if ptr.is_null() {
+        return None;
+    }
+    unsafe { ptr.cast::<UnifiedTokenizer>().as_ref() }
+}
+
+#[repr(C)]
+pub struct tokenizers_tensor_options {
+    add_special_tokens: bool,
+    // Override the padding of the tokenizer if set
+    padding_direction_set: bool,
+    padding_direction: u8,
+    // 0 disables rounding
+    pad_to_multiple_of_set: bool,
+    pad_to_multiple_of: usize,
+}
+
+#[repr(C)]
+pub struct tokenizers_tensors {
+    input_ids: *mut i64,
+    attention_mask: *mut i64,
+    token_type_ids: *mut i64,
+    rows: usize,
+    cols: usize,
+    // Set if encoding failed, freed with tokenizers_free_string.
+    error: *mut libc::c_char,
+}
+
+impl tokenizers_tensors {
+    fn error(err: &dyn std::fmt::Display) -> Self {
+        tokenizers_tensors {
+            input_ids: ptr::null_mut(),
+            attention_mask: ptr::null_mut(),
+            token_type_ids: ptr::null_mut(),
+            rows: 0,
+            cols: 0,
+            error: std::ffi::CString::new(err.to_string().replace('\0', ""))
+                .map_or(ptr::null_mut(), std::ffi::CString::into_raw),
+        }
+    }
+}
+
+/// Encodes a batch of texts into tensors, freed with tokenizers_free_tensors.
+#[no_mangle]
+pub extern "C" fn tokenizers_encode_batch_tensors(
+    ptr: *mut libc::c_void,
+    texts: *const *const libc::c_char,
+    len: usize,
+    options: &tokenizers_tensor_options,
+) -> tokenizers_tensors {
+    let unified_tokenizer = match unified_tokenizer(ptr) {
+        Some(tokenizer) => tokenizer,
+        None => return tokenizers_tensors::error(&"Invalid tokenizer"),
+    };
+    let texts: Vec<std::borrow::Cow<str>> = if texts.is_null() {
+        Vec::new()
+    } else {
+        unsafe { std::slice::from_raw_parts(texts, len) }.iter().map(|&text| lossy_text(text)).collect()
+    };
+    let texts: Vec<&str> = texts.iter().map(|text| text.as_ref()).collect();
+    let direction = if options.padding_direction_set {
+        match options.padding_direction {
+            0 => Some(PaddingDirection::Left),
+            1 => Some(PaddingDirection::Right),
+            dir => return tokenizers_tensors::error(&format!("Invalid padding direction: {}", dir)),
+        }
+    } else {
+        None
+    };
+    let pad_to_multiple_of = options.pad_to_multiple_of_set.then_some(options.pad_to_multiple_of);
+    let tensors = match std::panic::catch_unwind(|| unified_tokenizer.encode_batch_tensors(&texts, options.add_special_tokens, direction, pad_to_multiple_of)) {
+        Ok(Ok(tensors)) => tensors,
+        Ok(Err(e)) => return tokenizers_tensors::error(&e),
+        Err(_) => return tokenizers_tensors::error(&"Encoding panicked"),
+    };
+    let into_raw = |mut v: Vec<i64>| {
+        v.shrink_to_fit();
+        let ptr = v.as_mut_ptr();
+        std::mem::forget(v);
+        ptr
+    };
+    tokenizers_tensors {
+        input_ids: into_raw(tensors.input_ids),
+        attention_mask: into_raw(tensors.attention_mask),
+        token_type_ids: into_raw(tensors.token_type_ids),
+        rows: tensors.rows,
+        cols: tensors.cols,
+        error: ptr::null_mut(),
+    }
+}
+
+#[no_mangle]
+pub extern "C" fn tokenizers_free_tensors(tensors: tokenizers_tensors) {
+    let size = tensors.rows * tensors.cols;
+    for ptr in [tensors.input_ids, tensors.attention_mask, tensors.token_type_ids] {
+        if !ptr.is_null() {
+            unsafe { drop(Vec::from_raw_parts(ptr, size, size)); }
         }
     }
+}
+
+/// Reads an encoding passed over FFI, null attributes are None.
+fn details_from_buffer(buf: &tokenizers_buffer) -> Result<EncodingDetails, std::str::Utf8Error> {
+    fn to_vec<T: Clone>(ptr: *const T, len: usize) -> Option<Vec<T>> {
+        (!ptr.is_null()).then(|| unsafe { std::slice::from_raw_parts(ptr, len) }.to_vec())
+    }
+    let len = buf.len;
+    let tokens = if buf.tokens.is_null() {
+        None
+    } else {
+        let tokens = unsafe { std::slice::from_raw_parts(buf.tokens, len) }.iter()
+            .map(|&token| unsafe { CStr::from_ptr(token) }.to_str().map(str::to_string))
+            .collect::<Result<Vec<_>, _>>()?;
+        Some(tokens)
+    };
+    Ok(EncodingDetails {
+        ids: to_vec(buf.ids, len).unwrap_or_default(),
+        type_ids: to_vec(buf.type_ids, len),
+        tokens,
+        special_tokens_mask: to_vec(buf.special_tokens_mask, len),
+        attention_mask: to_vec(buf.attention_mask, len),
+        offsets: to_vec(buf.offsets, len * 2).map(|v| v.chunks(2).map(|o| (o[0], o[1])).collect()),
+        word_ids: to_vec(buf.word_ids, len).map(|v| v.into_iter().map(|id| u32::try_from(id).ok()).collect()),
+        sequence_ids: to_vec(buf.sequence_ids, len).map(|v| v.into_iter().map(|id| usize::try_from(id).ok()).collect()),
+        overflowing: Vec::new(),
+    })
+}
+
+/// Runs the post-processor on encodings passed over FFI, second is null for a single
+/// sequence. The buffers are owned by the caller.
+#[no_mangle]
+pub extern "C" fn tokenizers_post_process(
+    ptr: *mut libc::c_void,
+    first: *const tokenizers_buffer,
+    second: *const tokenizers_buffer,
+    options: &tokenizers_encode_options,
+) -> tokenizers_buffer {
+    let (unified_tokenizer, first) = match (unified_tokenizer(ptr), unsafe { first.as_ref() }) {
+        (Some(tokenizer), Some(first)) => (tokenizer, first),
+        _ => return tokenizers_buffer::empty(),
+    };
+    let first = match details_from_buffer(first) {
+        Ok(first) => first,
+        Err(e) => return tokenizers_buffer::error(&e),
+    };
+    let second = match unsafe { second.as_ref() }.map(details_from_buffer).transpose() {
+        Ok(second) => second,
+        Err(e) => return tokenizers_buffer::error(&e),
+    };
+    let encoding_details = match std::panic::catch_unwind(|| unified_tokenizer.post_process(first, second, options.add_special_tokens)) {
+        Ok(Ok(details)) => details,
+        Ok(Err(e)) => return tokenizers_buffer::error(e.as_ref()),
+        Err(_) => return tokenizers_buffer::empty(),
+    };
+    encoding_buffer(encoding_details, options)
+}
+
+#[no_mangle]
+pub extern "C" fn tokenizers_num_special_tokens_to_add(ptr: *mut libc::c_void, is_pair: bool) -> usize {
+    match unified_tokenizer(ptr) {
+        Some(tokenizer) => tokenizer.num_special_tokens_to_add(is_pair),
+        None => 0,
+    }
+}
+
+/// Normalizes the text, the buffer holds the byte offsets in the text of every
+/// byte of the normalized string, which is freed with tokenizers_free_string.
+#[no_mangle]
+pub extern "C" fn tokenizers_normalize(ptr: *mut libc::c_void, text: *const libc::c_char, normalized: *mut *mut libc::c_char) -> tokenizers_buffer {
+    let unified_tokenizer = match unified_tokenizer(ptr) {
+        Some(tokenizer) if !text.is_null() && !normalized.is_null() => tokenizer,
+        _ => return tokenizers_buffer::empty(),
+    };
+    let text = lossy_text(text);
+    let (normalized_text, alignments) = match std::panic::catch_unwind(|| unified_tokenizer.normalize(&text)) {
+        Ok(Ok(result)) => result,
+        Ok(Err(e)) => return tokenizers_buffer::error(e.as_ref()),
+        Err(_) => return tokenizers_buffer::empty(),
+    };
+    unsafe {
+        *normalized = std::ffi::CString::new(normalized_text.replace('\0', "\u{FFFD}"))
+            .map_or(ptr::null_mut(), std::ffi::CString::into_raw);
+    }
+    let mut buffer = tokenizers_buffer::empty();
+    buffer.len = alignments.len();
+    buffer.offsets = offsets_array(alignments);
+    buffer
+}
 
-    tokenizers_buffer { ids, type_ids, special_tokens_mask, attention_mask, tokens, offsets, len }
+/// Splits the text with the pre-tokenizer, the buffer holds the pieces as tokens
+/// and their byte offsets in the text.
+#[no_mangle]
+pub extern "C" fn tokenizers_pre_tokenize(ptr: *mut libc::c_void, text: *const libc::c_char) -> tokenizers_buffer {
+    let unified_tokenizer = match unified_tokenizer(ptr) {
+        Some(tokenizer) if !text.is_null() => tokenizer,
+        _ => return tokenizers_buffer::empty(),
+    };
+    let text = lossy_text(text);
+    let pieces = match std::panic::catch_unwind(|| unified_tokenizer.pre_tokenize(&text)) {
+        Ok(Ok(pieces)) => pieces,
+        Ok(Err(e)) => return tokenizers_buffer::error(e.as_ref()),
+        Err(_) => return tokenizers_buffer::empty(),
+    };
+    let (tokens, offsets): (Vec<String>, Vec<(usize, usize)>) = pieces.into_iter().unzip();
+    let mut buffer = tokenizers_buffer::empty();
+    buffer.len = tokens.len();
+    buffer.tokens = c_string_array(tokens);
+    buffer.offsets = offsets_array(offsets);
+    buffer
 }
 
 #[no_mangle]
@@ -594,6 +1900,16 @@ pub extern "C" fn tokenizers_free_buffer(buf: tokenizers_buffer) {
             Vec::from_raw_parts(buf.offsets, buf.len*2, buf.len*2);
         }
     }
+    if !buf.word_ids.is_null() {
+        unsafe {
+            Vec::from_raw_parts(buf.word_ids, buf.len, buf.len);
+        }
+    }
+    if !buf.sequence_ids.is_null() {
+        unsafe {
+            Vec::from_raw_parts(buf.sequence_ids, buf.len, buf.len);
+        }
+    }
     if !buf.tokens.is_null() {
         unsafe {
             let strings = Vec::from_raw_parts(buf.tokens, buf.len, buf.len);
@@ -602,6 +1918,14 @@ pub extern "C" fn tokenizers_free_buffer(buf: tokenizers_buffer) {
             }   
         }
     }
+    if !buf.overflowing.is_null() {
+        unsafe {
+            let overflowing = Vec::from_raw_parts(buf.overflowing, buf.overflowing_len, buf.overflowing_len);
+            for buffer in overflowing {
+                tokenizers_free_buffer(buffer);
+            }
+        }
+    }
 }
 
 #[no_mangle]
@@ -639,7 +1963,7 @@ mod tests {
         // Use the constant pattern for tokenization
         let model_file = test_data_path("kimi-k2-instruct/tiktoken.model");
         let config_file = test_data_path("kimi-k2-instruct/tokenizer_config.json");
-        let (bpe, _vocab_size, _special_tokens, _special_token_ids) = crate::create_tiktoken_encoder(
+        let TiktokenTokenizer { bpe, .. } = crate::create_tiktoken_encoder(
             &model_file,
             &config_file,
             TIKTOKEN_PATTERN_KIMI,
@@ -659,24 +1983,24 @@ mod tests {
     fn create_test_tiktoken_tokenizer() -> Result<UnifiedTokenizer, Box<dyn std::error::Error>> {
         let model_file = test_data_path("kimi-k2-instruct/tiktoken.model");
         let config_file = test_data_path("kimi-k2-instruct/tokenizer_config.json");
-        let (bpe, vocab_size, special_tokens, special_token_ids) = create_tiktoken_encoder(
+        let tiktoken = create_tiktoken_encoder(
             &model_file,
             &config_file,
             TIKTOKEN_PATTERN_KIMI,
         )?;
-        Ok(UnifiedTokenizer::Tiktoken(bpe, vocab_size, special_tokens, special_token_ids))
+        Ok(UnifiedTokenizer::Tiktoken(tiktoken))
     }
 
     /// Create a test Llama 3 tiktoken unified tokenizer
     fn create_test_llama_tokenizer() -> Result<UnifiedTokenizer, Box<dyn std::error::Error>> {
         let model_file = test_data_path("meta-llama-3-8b-instruct/tiktoken.model");
         let config_file = test_data_path("meta-llama-3-8b-instruct/tokenizer_config.json");
-        let (bpe, vocab_size, special_tokens, special_token_ids) = create_tiktoken_encoder(
+        let tiktoken = create_tiktoken_encoder(
             &model_file,
             &config_file,
             TIKTOKEN_PATTERN_CL100K_BASE,
         )?;
-        Ok(UnifiedTokenizer::Tiktoken(bpe, vocab_size, special_tokens, special_token_ids))
+        Ok(UnifiedTokenizer::Tiktoken(tiktoken))
     }
 
     #[test]
@@ -684,7 +2008,7 @@ mod tests {
         // Test with HuggingFace tokenizer
         let tokenizer_file = test_data_path("bert-base-uncased.json");
         let tokenizer = Tokenizer::from_file(&tokenizer_file).map_err(|e| format!("Failed to load tokenizer: {}", e))?;
-        let unified = UnifiedTokenizer::HuggingFace(tokenizer);
+        let unified = UnifiedTokenizer::HuggingFace(HuggingFaceTokenizer::new(tokenizer));
         
         let text = "Hello, world!";
         let ids = unified.encode(text, false)?;
@@ -699,6 +2023,80 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_normalize_and_pre_tokenize() -> Result<(), Box<dyn std::error::Error>> {
+        let tokenizer_file = test_data_path("bert-base-uncased.json");
+        let tokenizer = Tokenizer::from_file(&tokenizer_file).map_err(|e| format!("Failed to load tokenizer: {}", e))?;
+        let unified = UnifiedTokenizer::HuggingFace(HuggingFaceTokenizer::new(tokenizer));
+
+        // accents are stripped, e is aligned to both bytes of é
+        let (normalized, alignments) = unified.normalize("Héllo")?;
+        assert_eq!(normalized, "hello");
+        assert_eq!(alignments, vec![(0, 1), (1, 3), (3, 4), (4, 5), (5, 6)]);
+        let pieces = unified.pre_tokenize("Héllo, world")?;
+        assert_eq!(pieces, vec![("hello".to_string(), (0, 6)), (",".to_string(), (6, 7)), ("world".to_string(), (8, 13))]);
+
+        let unified = create_test_llama_tokenizer()?;
+        let (normalized, alignments) = unified.normalize("hé")?;
+        assert_eq!(normalized, "hé");
+        assert_eq!(alignments, vec![(0, 1), (1, 3), (1, 3)]);
+        let pieces = unified.pre_tokenize("hi world")?;
+        assert_eq!(pieces, vec![("hi".to_string(), (0, 2)), (" world".to_string(), (2, 8))]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_post_process() -> Result<(), Box<dyn std::error::Error>> {
+        let tokenizer_file = test_data_path("bert-base-uncased.json");
+        let tokenizer = Tokenizer::from_file(&tokenizer_file).map_err(|e| format!("Failed to load tokenizer: {}", e))?;
+        let unified = UnifiedTokenizer::HuggingFace(HuggingFaceTokenizer::new(tokenizer));
+        assert_eq!(unified.num_special_tokens_to_add(false), 2);
+        assert_eq!(unified.num_special_tokens_to_add(true), 3);
+        let first = EncodingDetails { ids: vec![2829, 4419], ..Default::default() };
+        let second = EncodingDetails { ids: vec![14523], ..Default::default() };
+        let details = unified.post_process(first, Some(second), true)?;
+        assert_eq!(details.ids, vec![101, 2829, 4419, 102, 14523, 102]);
+        assert_eq!(details.type_ids, Some(vec![0, 0, 0, 0, 1, 1]));
+
+        let unified = create_test_llama_tokenizer()?;
+        assert_eq!(unified.num_special_tokens_to_add(true), 0);
+        let first = EncodingDetails { ids: vec![6151], ..Default::default() };
+        let second = EncodingDetails { ids: vec![1917], ..Default::default() };
+        let details = unified.post_process(first, Some(second), true)?;
+        assert_eq!(details.ids, vec![6151, 1917]);
+        assert_eq!(details.sequence_ids, Some(vec![Some(0), Some(1)]));
+        Ok(())
+    }
+
+    #[test]
+    fn test_encode_batch_tensors() -> Result<(), Box<dyn std::error::Error>> {
+        let unified = create_test_llama_tokenizer()?;
+        let tensors = unified.encode_batch_tensors(&["hi", "hi world"], false, Some(PaddingDirection::Left), Some(4))?;
+        assert_eq!((tensors.rows, tensors.cols), (2, 4));
+        assert_eq!(tensors.input_ids[3], 6151);
+        assert_eq!(&tensors.input_ids[6..], &[6151, 1917]);
+        assert_eq!(tensors.attention_mask, vec![0, 0, 0, 1, 0, 0, 1, 1]);
+        assert_eq!(tensors.token_type_ids, vec![0; 8]);
+
+        // the encodings are padded only once, the options override the padding of the tokenizer
+        let mut unified = unified;
+        if let UnifiedTokenizer::Tiktoken(tiktoken) = &mut unified {
+            tiktoken.padding = Some(PaddingParams {
+                strategy: PaddingStrategy::Fixed(8),
+                direction: PaddingDirection::Left,
+                pad_id: 7,
+                ..Default::default()
+            });
+        }
+        let tensors = unified.encode_batch_tensors(&["hi", "hi world"], false, Some(PaddingDirection::Right), Some(4))?;
+        assert_eq!((tensors.rows, tensors.cols), (2, 4));
+        assert_eq!(&tensors.input_ids[..4], &[6151, 7, 7, 7]);
+        let tensors = unified.encode_batch_tensors(&["hi"], false, None, None)?;
+        assert_eq!((tensors.rows, tensors.cols), (1, 8));
+        assert_eq!(tensors.input_ids[7], 6151);
+        Ok(())
+    }
+
     #[test]
     fn test_unified_tiktoken() -> Result<(), Box<dyn std::error::Error>> {
         // Test basic tiktoken functionality, including multilingual support and vocab size
@@ -860,7 +2258,7 @@ mod tests {
         // Test special token parsing and handling for Llama 3
         let model_file = test_data_path("meta-llama-3-8b-instruct/tiktoken.model");
         let config_file = test_data_path("meta-llama-3-8b-instruct/tokenizer_config.json");
-        let (_bpe, _vocab_size, special_tokens, special_token_ids) = create_tiktoken_encoder(
+        let TiktokenTokenizer { special_tokens, special_token_ids, .. } = create_tiktoken_encoder(
             &model_file,
             &config_file,
             TIKTOKEN_PATTERN_CL100K_BASE,
@@ -955,7 +2353,7 @@ mod tests {
         // Test special token parsing and handling
         let model_file = test_data_path("kimi-k2-instruct/tiktoken.model");
         let config_file = test_data_path("kimi-k2-instruct/tokenizer_config.json");
-        let (_bpe, _vocab_size, special_tokens, special_token_ids) = create_tiktoken_encoder(
+        let TiktokenTokenizer { special_tokens, special_token_ids, .. } = create_tiktoken_encoder(
             &model_file,
             &config_file,
             TIKTOKEN_PATTERN_KIMI,
@@ -978,6 +2376,215 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_tiktoken_from_bytes() -> Result<(), Box<dyn std::error::Error>> {
+        let model = std::fs::read(test_data_path("kimi-k2-instruct/tiktoken.model"))?;
+        let config = std::fs::read(test_data_path("kimi-k2-instruct/tokenizer_config.json"))?;
+        let TiktokenTokenizer { bpe, vocab_size, .. } =
+            create_tiktoken_encoder_from_bytes(&model, &config, TIKTOKEN_PATTERN_KIMI, DEFAULT_RESERVED_TOKEN_FORMAT)?;
+
+        assert_eq!(vocab_size, 163840);
+        let tokens = bpe.encode("Hello, world! 你好，世界！", &HashSet::new()).0;
+        assert_eq!(tokens, vec![19180, 11, 2695, 0, 220, 33845, 378, 2243, 856]);
+
+        assert!(create_tiktoken_encoder_from_bytes(&model, b"{", TIKTOKEN_PATTERN_KIMI, DEFAULT_RESERVED_TOKEN_FORMAT).is_err());
+        Ok(())
+    }
+
+    #[test]
+    fn test_tiktoken_tolerant_config() -> Result<(), Box<dyn std::error::Error>> {
+        let model = std::fs::read(test_data_path("meta-llama-3-8b-instruct/tiktoken.model"))?;
+        // AddedToken object for bos_token, null eos_token, no model_max_length or tokenizer_class
+        let config = br#"{
+            "added_tokens_decoder": {"128000": {"content": "<|begin_of_text|>", "special": true}},
+            "bos_token": {"content": "<|begin_of_text|>", "lstrip": false, "normalized": false, "rstrip": false, "single_word": false},
+            "eos_token": null,
+            "additional_special_tokens": ["<|begin_of_text|>", {"content": "<|begin_of_text|>"}],
+            "chat_template": [{"name": "default", "template": ""}]
+        }"#;
+        let TiktokenTokenizer { bpe, vocab_size, special_tokens, .. } =
+            create_tiktoken_encoder_from_bytes(&model, config, TIKTOKEN_PATTERN_CL100K_BASE, DEFAULT_RESERVED_TOKEN_FORMAT)?;
+        assert_eq!(vocab_size, 128001);
+        assert!(special_tokens.contains("<|begin_of_text|>"));
+        let allowed: HashSet<&str> = special_tokens.iter().map(String::as_str).collect();
+        assert_eq!(bpe.encode("<|begin_of_text|>", &allowed).0, vec![128000]);
+
+        // no config at all
+        let TiktokenTokenizer { vocab_size, special_tokens, .. } =
+            create_tiktoken_encoder_from_bytes(&model, b"{}", TIKTOKEN_PATTERN_CL100K_BASE, DEFAULT_RESERVED_TOKEN_FORMAT)?;
+        assert_eq!(vocab_size, 128000);
+        assert!(special_tokens.is_empty());
+        Ok(())
+    }
+
+    #[test]
+    fn test_tiktoken_reserved_token_format() -> Result<(), Box<dyn std::error::Error>> {
+        use base64::{Engine as _, engine::general_purpose};
+
+        // rank 256 is missing
+        let mut model = String::new();
+        for b in 0..=255u8 {
+            model.push_str(&format!("{} {}\n", general_purpose::STANDARD.encode([b]), b));
+        }
+        model.push_str(&format!("{} 257\n", general_purpose::STANDARD.encode("ab")));
+
+        let TiktokenTokenizer { bpe, .. } = create_tiktoken_encoder_from_bytes(model.as_bytes(), b"{}", TIKTOKEN_PATTERN_CL100K_BASE, "<|unused_%d|>")?;
+        assert_eq!(bpe.decode(vec![256])?, "<|unused_0|>");
+        let TiktokenTokenizer { bpe, .. } = create_tiktoken_encoder_from_bytes(model.as_bytes(), b"{}", TIKTOKEN_PATTERN_CL100K_BASE, DEFAULT_RESERVED_TOKEN_FORMAT)?;
+        assert_eq!(bpe.decode(vec![256])?, "<|reserved_special_token_0|>");
+
+        // a special token taking the missing rank isn't shadowed by a reserved token
+        let config = br#"{"added_tokens_decoder": {"256": {"content": "<|endoftext|>", "special": true}}}"#;
+        let TiktokenTokenizer { bpe, decoder, .. } = create_tiktoken_encoder_from_bytes(model.as_bytes(), config, TIKTOKEN_PATTERN_CL100K_BASE, DEFAULT_RESERVED_TOKEN_FORMAT)?;
+        assert_eq!(bpe.decode(vec![256])?, "<|endoftext|>");
+        assert_eq!(decoder.get(&256), Some(&b"<|endoftext|>".to_vec()));
+        assert!(bpe.encode_ordinary("<|reserved_special_token_0|>").iter().all(|&id| id != 256));
+        Ok(())
+    }
+
+    #[test]
+    fn test_tiktoken_encoding_details() -> Result<(), Box<dyn std::error::Error>> {
+        let unified = create_test_llama_tokenizer()?;
+        let details = unified.encode_with_details("<|begin_of_text|>hi world", true, None)?;
+        assert_eq!(details.ids, vec![128000, 6151, 1917]);
+        assert_eq!(details.tokens, Some(vec!["<|begin_of_text|>".to_string(), "hi".to_string(), "\u{120}world".to_string()]));
+        assert_eq!(details.offsets, Some(vec![(0, 17), (17, 19), (19, 25)]));
+        assert_eq!(details.special_tokens_mask, Some(vec![1, 0, 0]));
+        assert_eq!(details.attention_mask, Some(vec![1, 1, 1]));
+        assert_eq!(details.type_ids, Some(vec![0, 0, 0]));
+        assert_eq!(details.word_ids, Some(vec![Some(0), Some(1), Some(2)]));
+        assert_eq!(details.sequence_ids, Some(vec![Some(0); 3]));
+
+        // special tokens encoded as text are split by the pattern
+        let details = unified.encode_with_details("hi<|eot_id|>", false, None)?;
+        let words = details.word_ids.expect("word IDs are set");
+        assert_eq!(words.first(), Some(&Some(0)));
+        assert!(words.windows(2).all(|w| w[0] <= w[1]));
+        assert_eq!(words.last(), Some(&Some(4)), "hi, <|, eot, _id, |>");
+        Ok(())
+    }
+
+    #[test]
+    fn test_tiktoken_encode_words() -> Result<(), Box<dyn std::error::Error>> {
+        let unified = create_test_llama_tokenizer()?;
+        let details = unified.encode_words(&["hi", " world"], Some(&["hi"][..]), false)?;
+        assert_eq!(details.ids, vec![6151, 1917, 6151]);
+        assert_eq!(details.offsets, Some(vec![(0, 2), (0, 6), (0, 2)]));
+        assert_eq!(details.word_ids, Some(vec![Some(0), Some(1), Some(0)]));
+        assert_eq!(details.sequence_ids, Some(vec![Some(0), Some(0), Some(1)]));
+        assert_eq!(details.type_ids, Some(vec![0, 0, 1]));
+        Ok(())
+    }
+
+    #[test]
+    fn test_tiktoken_truncation_and_padding() -> Result<(), Box<dyn std::error::Error>> {
+        let text = "Hello, world! 你好，世界！";
+        let mut tiktoken = create_tiktoken_encoder(
+            &test_data_path("kimi-k2-instruct/tiktoken.model"),
+            &test_data_path("kimi-k2-instruct/tokenizer_config.json"),
+            TIKTOKEN_PATTERN_KIMI,
+        )?;
+        assert_eq!(tiktoken.pad_token.as_deref(), Some("[PAD]"));
+        let pad_id = tiktoken.token_to_id("[PAD]").expect("[PAD] is a special token");
+        assert_eq!(pad_id, 163839);
+
+        tiktoken.truncation = Some(TruncationParams { max_length: 4, stride: 1, ..Default::default() });
+        tiktoken.padding = Some(PaddingParams {
+            strategy: PaddingStrategy::Fixed(4),
+            pad_id,
+            pad_token: "[PAD]".to_string(),
+            ..Default::default()
+        });
+        let unified = UnifiedTokenizer::Tiktoken(tiktoken);
+        let details = unified.encode_with_details(text, false, None)?;
+        assert_eq!(details.ids, vec![19180, 11, 2695, 0]);
+        let overflowing: Vec<Vec<u32>> = details.overflowing.iter().map(|o| o.ids.clone()).collect();
+        assert_eq!(overflowing, vec![vec![0, 220, 33845, 378], vec![378, 2243, 856, 163839]]);
+        assert_eq!(details.overflowing[1].attention_mask, Some(vec![1, 1, 1, 0]));
+        assert_eq!(unified.encode(text, false)?, vec![19180, 11, 2695, 0]);
+
+        let UnifiedTokenizer::Tiktoken(mut tiktoken) = unified else { unreachable!() };
+        tiktoken.truncation = Some(TruncationParams {
+            max_length: 4,
+            direction: tokenizers::tokenizer::TruncationDirection::Left,
+            ..Default::default()
+        });
+        tiktoken.padding = None;
+        let unified = UnifiedTokenizer::Tiktoken(tiktoken);
+        let details = unified.encode_with_details(text, false, None)?;
+        assert_eq!(details.ids, vec![33845, 378, 2243, 856]);
+        let overflowing: Vec<Vec<u32>> = details.overflowing.iter().map(|o| o.ids.clone()).collect();
+        assert_eq!(overflowing, vec![vec![11, 2695, 0, 220], vec![19180]]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_tiktoken_encode_special_tokens() -> Result<(), Box<dyn std::error::Error>> {
+        let mut unified = create_test_llama_tokenizer()?;
+        let text = "<|begin_of_text|>hi<|eot_id|>";
+        assert_eq!(unified.encode(text, true)?, vec![128000, 6151, 128009]);
+
+        unified.set_encode_special_tokens(true);
+        let ids = unified.encode(text, true)?;
+        assert!(!ids.contains(&128000) && !ids.contains(&128009));
+        assert_eq!(unified.decode(&ids, false)?, text);
+        let details = unified.encode_with_details(text, true, None)?;
+        assert_eq!(details.ids, ids);
+        assert!(details.special_tokens_mask.unwrap_or_default().iter().all(|m| *m == 0));
+        Ok(())
+    }
+
+    #[test]
+    fn test_special_token_policy() -> Result<(), Box<dyn std::error::Error>> {
+        let unified = create_test_llama_tokenizer()?;
+        let text = "<|begin_of_text|>hi<|eot_id|>";
+
+        // only <|begin_of_text|> is parsed, <|eot_id|> is encoded as text
+        let policy = SpecialTokenPolicy {
+            allowed: SpecialTokenSet::Tokens(HashSet::from(["<|begin_of_text|>"])),
+            disallowed: SpecialTokenSet::Tokens(HashSet::new()),
+        };
+        let ids = unified.encode_with_details(text, false, Some(&policy))?.ids;
+        assert_eq!(ids[0], 128000);
+        assert!(!ids.contains(&128009));
+        assert_eq!(unified.decode(&ids, false)?, text);
+
+        // everything but <|begin_of_text|> is disallowed
+        let policy = SpecialTokenPolicy {
+            allowed: SpecialTokenSet::Tokens(HashSet::from(["<|begin_of_text|>"])),
+            disallowed: SpecialTokenSet::All,
+        };
+        let err = unified.encode_with_details(text, false, Some(&policy)).err().expect("expected an error");
+        let err = err.downcast_ref::<DisallowedSpecialTokenError>().expect("expected DisallowedSpecialTokenError");
+        assert_eq!(err.token, "<|eot_id|>");
+
+        let policy = SpecialTokenPolicy {
+            allowed: SpecialTokenSet::All,
+            disallowed: SpecialTokenSet::All,
+        };
+        let ids = unified.encode_with_details(text, false, Some(&policy))?.ids;
+        assert_eq!(ids[0], 128000);
+        assert_eq!(ids[ids.len() - 1], 128009);
+        Ok(())
+    }
+
+    #[test]
+    fn test_tekken() -> Result<(), Box<dyn std::error::Error>> {
+        let unified = UnifiedTokenizer::Tiktoken(create_tekken_encoder(&test_data_path("tekken/tekken.json"))?);
+
+        // 5 reserved special tokens plus the base vocab truncated to default_vocab_size
+        assert_eq!(unified.vocab_size(), 270);
+        assert_eq!(unified.encode("hello world", false)?, vec![264, 269]);
+        assert_eq!(unified.encode("lo", false)?, vec![113, 116]);
+
+        // unused reserved slots are filled with <SPECIAL_{id}> control tokens
+        let ids = unified.encode("<s>[INST]hello world</s><SPECIAL_4>", true)?;
+        assert_eq!(ids, vec![1, 3, 264, 269, 2, 4]);
+        assert_eq!(unified.decode(&ids, true)?, "[INST]hello world");
+
+        Ok(())
+    }
+
     #[test]
     fn test_tiktoken_skip_special_tokens_decoding() -> Result<(), Box<dyn std::error::Error>> {
         // Test that skip_special_tokens correctly filters out special token IDs during decoding
@@ -1012,11 +2619,7 @@ mod tests {
 /// * `pattern` - Regex pattern string for tokenization
 /// 
 /// # Returns
-/// A tuple containing:
-/// * `CoreBPE` - The tiktoken encoder instance
-/// * `u32` - The vocabulary size
-/// * `HashSet<String>` - Set of special token strings
-/// * `HashSet<u32>` - Set of special token IDs
+/// A `TiktokenTokenizer` with the encoder, the vocabulary size, the special tokens and their IDs
 /// 
 /// # Errors
 /// Returns an error if:
@@ -1028,7 +2631,26 @@ pub fn create_tiktoken_encoder(
     model_file_path: &str,
     config_file_path: &str,
     pattern: &str,
-) -> Result<(tiktoken_rs::CoreBPE, u32, std::collections::HashSet<String>, std::collections::HashSet<u32>), Box<dyn std::error::Error>> {
+) -> Result<TiktokenTokenizer, Box<dyn std::error::Error>> {
+    let model = std::fs::read(model_file_path)
+        .map_err(|e| format!("Failed to read model file: {}", e))?;
+    let config = std::fs::read(config_file_path)
+        .map_err(|e| format!("Failed to open config file: {}", e))?;
+    create_tiktoken_encoder_from_bytes(&model, &config, pattern, DEFAULT_RESERVED_TOKEN_FORMAT)
+}
+
+/// Default name of tokens filling missing ranks, "%d" is replaced by the index of the reserved token.
+pub const DEFAULT_RESERVED_TOKEN_FORMAT: &str = "<|reserved_special_token_%d|>";
+
+/// Creates a CoreBPE encoder from the contents of a .model file and a tokenizer_config.json,
+/// see `create_tiktoken_encoder`. Missing ranks are filled with tokens named after
+/// `reserved_token_format`, where "%d" is replaced by the index of the reserved token.
+pub fn create_tiktoken_encoder_from_bytes(
+    model: &[u8],
+    config: &[u8],
+    pattern: &str,
+    reserved_token_format: &str,
+) -> Result<TiktokenTokenizer, Box<dyn std::error::Error>> {
     use std::collections::{HashMap, HashSet};
     use tiktoken_rs::{CoreBPE, Rank};
     use base64::{Engine as _, engine::general_purpose};
@@ -1037,8 +2659,8 @@ pub fn create_tiktoken_encoder(
         HashMap::default();
 
     // Parse the model file
-    let file = std::fs::read_to_string(model_file_path)
-        .map_err(|e| format!("Failed to read model file: {}", e))?;
+    let file = std::str::from_utf8(model)
+        .map_err(|e| format!("Invalid UTF-8 in model file: {}", e))?;
     
     for (line_num, line) in file.lines().enumerate() {
         if line.trim().is_empty() {
@@ -1062,13 +2684,13 @@ pub fn create_tiktoken_encoder(
         HashMap::default();
     let mut special_tokens_set = HashSet::new();
     let mut special_token_ids = HashSet::new();
+    let pad_token;
     {
-        let config_file = std::fs::File::open(config_file_path)
-            .map_err(|e| format!("Failed to open config file: {}", e))?;
-        let tokenizer_config: TokenizerConfig = serde_json::from_reader(config_file)
+        let tokenizer_config: TokenizerConfig = serde_json::from_slice(config)
             .map_err(|e| format!("Failed to parse config JSON: {}", e))?;
+        pad_token = tokenizer_config.pad_token.map(TokenContent::into_content);
         
-        for (token_id, added_token) in tokenizer_config.added_tokens_decoder {
+        for (token_id, added_token) in tokenizer_config.added_tokens_decoder.unwrap_or_default() {
             let id: u32 = token_id.parse()
                 .map_err(|e| format!("Failed to parse token ID '{}': {}", token_id, e))?;
             special_tokens.insert(added_token.content.clone(), id);
@@ -1087,42 +2709,209 @@ pub fn create_tiktoken_encoder(
     let vocab_size = std::cmp::max(max_rank, max_special_token) + 1;
 
     // Fill in missing ranks with reserved special tokens
-    // This ensures the encoder has entries for all ranks from 0 to max_rank
+    // This ensures the encoder has entries for all ranks from 0 to max_rank,
+    // ranks of special tokens are left to the special tokens, e.g. <|endoftext|>
+    // of p50k_base takes rank 50256 in the middle of the vocabulary
     let existing_ranks: HashSet<Rank> = encoder.values().copied().collect();
     let mut reserved_token_count: u32 = 0;
     for rank in 0..max_rank {
-        if !existing_ranks.contains(&rank) {
-            let reserved_token = format!("<|reserved_special_token_{}|>", reserved_token_count);
+        if !existing_ranks.contains(&rank) && !special_token_ids.contains(&rank) {
+            let reserved_token = reserved_token_format.replace("%d", &reserved_token_count.to_string());
             reserved_token_count += 1;
             encoder.insert(reserved_token.into_bytes(), rank);
         }
     }
 
+    let mut decoder: HashMap<u32, Vec<u8>> = encoder.iter().map(|(token, rank)| (*rank, token.clone())).collect();
+    decoder.extend(special_tokens.iter().map(|(token, id)| (*id, token.clone().into_bytes())));
+    let mut token_ids = encoder.clone();
+    token_ids.extend(special_tokens.iter().map(|(token, id)| (token.clone().into_bytes(), *id)));
     let bpe = CoreBPE::new(encoder, special_tokens, pattern)?;
-    Ok((bpe, vocab_size, special_tokens_set, special_token_ids))
+    let regex = fancy_regex::Regex::new(pattern)?;
+    Ok(TiktokenTokenizer {
+        bpe,
+        vocab_size,
+        special_tokens: special_tokens_set,
+        special_token_ids,
+        decoder,
+        token_ids,
+        pad_token,
+        truncation: None,
+        padding: None,
+        encode_special_tokens: false,
+        regex,
+    })
+}
+
+
+/// Special tokens of tekken files predating version v7, which don't list them explicitly.
+const TEKKEN_DEPRECATED_SPECIAL_TOKENS: [&str; 20] = [
+    "<unk>", "<s>", "</s>", "[INST]", "[/INST]", "[AVAILABLE_TOOLS]", "[/AVAILABLE_TOOLS]",
+    "[TOOL_RESULTS]", "[/TOOL_RESULTS]", "[TOOL_CALLS]", "[IMG]", "<pad>", "[IMG_BREAK]",
+    "[IMG_END]", "[PREFIX]", "[MIDDLE]", "[SUFFIX]", "[SYSTEM_PROMPT]", "[/SYSTEM_PROMPT]",
+    "[TOOL_CONTENT]",
+];
+
+/// Creates a CoreBPE encoder from Mistral's tekken.json file.
+///
+/// Tekken reserves the first `default_num_special_tokens` IDs for special tokens,
+/// so ranks of the base vocabulary are shifted by that amount and unused special
+/// slots are filled with `<SPECIAL_{id}>` control tokens, like mistral-common does.
+/// The base vocabulary is truncated so that the total matches `default_vocab_size`.
+///
+/// # Returns
+/// A `TiktokenTokenizer` whose `special_token_ids` are the control token IDs, skipped when decoding
+pub fn create_tekken_encoder(
+    tekken_file_path: &str,
+) -> Result<TiktokenTokenizer, Box<dyn std::error::Error>> {
+    use std::collections::{HashMap, HashSet};
+    use tiktoken_rs::{CoreBPE, Rank};
+    use base64::{Engine as _, engine::general_purpose};
+
+    let file = std::fs::File::open(tekken_file_path)
+        .map_err(|e| format!("Failed to open tekken file: {}", e))?;
+    let tekken: TekkenFile = serde_json::from_reader(std::io::BufReader::new(file))
+        .map_err(|e| format!("Failed to parse tekken JSON: {}", e))?;
+
+    let num_special_tokens = tekken.config.default_num_special_tokens;
+    let vocab_size = tekken.config.default_vocab_size;
+    let special_tokens: Vec<TekkenSpecialToken> = match tekken.special_tokens {
+        Some(tokens) => tokens,
+        None => TEKKEN_DEPRECATED_SPECIAL_TOKENS.iter().enumerate()
+            .map(|(rank, token)| TekkenSpecialToken { rank: rank as u32, token_str: token.to_string(), is_control: true })
+            .collect(),
+    };
+    if special_tokens.len() > num_special_tokens {
+        return Err(format!("{} special tokens exceed default_num_special_tokens {}", special_tokens.len(), num_special_tokens).into());
+    }
+    if vocab_size < num_special_tokens || vocab_size - num_special_tokens > tekken.vocab.len() {
+        return Err(format!("Invalid default_vocab_size {} for {} tokens and {} special tokens", vocab_size, tekken.vocab.len(), num_special_tokens).into());
+    }
+
+    let mut encoder: HashMap<Vec<u8>, Rank, std::hash::BuildHasherDefault<rustc_hash::FxHasher>> =
+        HashMap::default();
+    for (i, token) in tekken.vocab.iter().take(vocab_size - num_special_tokens).enumerate() {
+        if token.rank as usize != i {
+            return Err(format!("Unexpected rank {} at position {} of tekken vocab", token.rank, i).into());
+        }
+        let bytes = general_purpose::STANDARD.decode(&token.token_bytes)
+            .map_err(|e| format!("Failed to decode base64 of rank {}: {}", token.rank, e))?;
+        encoder.insert(bytes, token.rank + num_special_tokens as Rank);
+    }
+
+    let mut special_encoder: HashMap<String, u32, std::hash::BuildHasherDefault<rustc_hash::FxHasher>> =
+        HashMap::default();
+    let mut special_tokens_set = HashSet::new();
+    let mut control_token_ids = HashSet::new();
+    let fillers = (special_tokens.len()..num_special_tokens)
+        .map(|rank| TekkenSpecialToken { rank: rank as u32, token_str: format!("<SPECIAL_{}>", rank), is_control: true });
+    for token in special_tokens.into_iter().chain(fillers) {
+        if token.rank as usize >= num_special_tokens {
+            return Err(format!("Special token {} has rank {} outside of the reserved range", token.token_str, token.rank).into());
+        }
+        if special_encoder.insert(token.token_str.clone(), token.rank).is_some() {
+            return Err(format!("Duplicate special token {}", token.token_str).into());
+        }
+        if token.is_control {
+            control_token_ids.insert(token.rank);
+        }
+        special_tokens_set.insert(token.token_str);
+    }
+
+    let mut decoder: HashMap<u32, Vec<u8>> = encoder.iter().map(|(token, rank)| (*rank, token.clone())).collect();
+    decoder.extend(special_encoder.iter().map(|(token, id)| (*id, token.clone().into_bytes())));
+    let mut token_ids = encoder.clone();
+    token_ids.extend(special_encoder.iter().map(|(token, id)| (token.clone().into_bytes(), *id)));
+    let bpe = CoreBPE::new(encoder, special_encoder, tekken.config.pattern.as_str())?;
+    let regex = fancy_regex::Regex::new(&tekken.config.pattern)?;
+    let pad_token = special_tokens_set.contains("<pad>").then(|| "<pad>".to_string());
+    Ok(TiktokenTokenizer {
+        bpe,
+        vocab_size: vocab_size as u32,
+        special_tokens: special_tokens_set,
+        special_token_ids: control_token_ids,
+        decoder,
+        token_ids,
+        pad_token,
+        truncation: None,
+        padding: None,
+        encode_special_tokens: false,
+        regex,
+    })
 }
 
 
-// Structures for deserializing tokenizer_config.json
+// Structures for deserializing tokenizer_config.json.
+// Only added_tokens_decoder is used, all fields are optional to tolerate the
+// many shapes found in the wild.
 #[derive(Debug, Deserialize, Serialize)]
 pub struct TokenizerConfig {
-    added_tokens_decoder: HashMap<String, AddedToken>,
-    additional_special_tokens: Option<Vec<String>>,
-    bos_token: String,
-    eos_token: String,
-    unk_token: Option<String>,
-    pad_token: Option<String>,
-    model_max_length: f64,  // Changed from u32 to handle very large values
-    tokenizer_class: String,
-    chat_template: Option<String>,
+    added_tokens_decoder: Option<HashMap<String, AddedToken>>,
+    additional_special_tokens: Option<Vec<TokenContent>>,
+    bos_token: Option<TokenContent>,
+    eos_token: Option<TokenContent>,
+    unk_token: Option<TokenContent>,
+    pad_token: Option<TokenContent>,
+    model_max_length: Option<f64>,  // f64 to handle very large values
+    tokenizer_class: Option<String>,
+    chat_template: Option<serde_json::Value>,  // a string or a list of named templates
+}
+
+/// A special token given either as a string or as an AddedToken object.
+#[derive(Debug, Deserialize, Serialize)]
+#[serde(untagged)]
+pub enum TokenContent {
+    Content(String),
+    AddedToken { content: String },
+}
+
+impl TokenContent {
+    fn into_content(self) -> String {
+        match self {
+            TokenContent::Content(content) | TokenContent::AddedToken { content } => content,
+        }
+    }
 }
 
 #[derive(Debug, Deserialize, Serialize)]
 pub struct AddedToken {
     content: String,
+    #[serde(default)]
     lstrip: bool,
+    #[serde(default)]
     normalized: bool,
+    #[serde(default)]
     rstrip: bool,
+    #[serde(default)]
     single_word: bool,
+    #[serde(default)]
     special: bool,
 }
+
+// Structures for deserializing Mistral's tekken.json
+#[derive(Debug, Deserialize)]
+pub struct TekkenFile {
+    config: TekkenConfig,
+    vocab: Vec<TekkenToken>,
+    special_tokens: Option<Vec<TekkenSpecialToken>>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct TekkenConfig {
+    pattern: String,
+    default_vocab_size: usize,
+    default_num_special_tokens: usize,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct TekkenToken {
+    rank: u32,
+    token_bytes: String,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct TekkenSpecialToken {
+    rank: u32,
+    token_str: String,
+    is_control: bool,
+}
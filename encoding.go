@@ -0,0 +1,149 @@
+package tokenizers
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Slice returns a copy of the tokens [start, end) of the encoding, overflowing
+// encodings are dropped. It panics if the range is out of bounds, like slicing.
+func (e Encoding) Slice(start, end int) Encoding {
+	return Encoding{
+		IDs:               sliceOf(e.IDs, start, end),
+		TypeIDs:           sliceOf(e.TypeIDs, start, end),
+		SpecialTokensMask: sliceOf(e.SpecialTokensMask, start, end),
+		AttentionMask:     sliceOf(e.AttentionMask, start, end),
+		Tokens:            sliceOf(e.Tokens, start, end),
+		Offsets:           sliceOf(e.Offsets, start, end),
+		WordIDs:           sliceOf(e.WordIDs, start, end),
+		SequenceIDs:       sliceOf(e.SequenceIDs, start, end),
+	}
+}
+
+// Merge returns the tokens of the encoding followed by the tokens of others.
+// Attributes missing from any encoding with tokens are dropped, so are overflowing
+// encodings. Offsets stay relative to the input of each encoding.
+func (e Encoding) Merge(others ...Encoding) Encoding {
+	all := append([]Encoding{e}, others...)
+	return Encoding{
+		IDs:               mergeOf(all, func(e Encoding) []uint32 { return e.IDs }),
+		TypeIDs:           mergeOf(all, func(e Encoding) []uint32 { return e.TypeIDs }),
+		SpecialTokensMask: mergeOf(all, func(e Encoding) []uint32 { return e.SpecialTokensMask }),
+		AttentionMask:     mergeOf(all, func(e Encoding) []uint32 { return e.AttentionMask }),
+		Tokens:            mergeOf(all, func(e Encoding) []string { return e.Tokens }),
+		Offsets:           mergeOf(all, func(e Encoding) []Offset { return e.Offsets }),
+		WordIDs:           mergeOf(all, func(e Encoding) []int { return e.WordIDs }),
+		SequenceIDs:       mergeOf(all, func(e Encoding) []int { return e.SequenceIDs }),
+	}
+}
+
+// Pad returns the encoding padded to length tokens on the left or on the right,
+// like WithPadding does, overflowing encodings are padded too. Padding tokens
+// are masked out of the attention and have no offsets, word or sequence.
+func (e Encoding) Pad(length int, padID uint32, padToken string, left bool) Encoding {
+	n := length - len(e.IDs)
+	ids := e.IDs
+	if ids == nil {
+		ids = []uint32{}
+	}
+	padded := Encoding{
+		IDs:               padOf(ids, n, padID, left),
+		TypeIDs:           padOf(e.TypeIDs, n, 0, left),
+		SpecialTokensMask: padOf(e.SpecialTokensMask, n, 1, left),
+		AttentionMask:     padOf(e.AttentionMask, n, 0, left),
+		Tokens:            padOf(e.Tokens, n, padToken, left),
+		Offsets:           padOf(e.Offsets, n, Offset{}, left),
+		WordIDs:           padOf(e.WordIDs, n, -1, left),
+		SequenceIDs:       padOf(e.SequenceIDs, n, -1, left),
+	}
+	for _, o := range e.Overflowing {
+		padded.Overflowing = append(padded.Overflowing, o.Pad(length, padID, padToken, left))
+	}
+	return padded
+}
+
+// Truncate splits the encoding into its first maxLen tokens, or its last maxLen
+// tokens for TruncationDirectionLeft, and the removed tokens, like WithTruncation
+// does. The removed tokens are split into parts of at most maxLen tokens overlapping
+// by stride tokens, which are also set as Overflowing of the truncated encoding.
+// It panics if stride isn't smaller than maxLen.
+func (e Encoding) Truncate(maxLen, stride int, dir TruncationDirection) (Encoding, []Encoding) {
+	n := len(e.IDs)
+	if n <= maxLen {
+		return e, nil
+	}
+	if maxLen == 0 {
+		truncated := e.Slice(0, 0)
+		truncated.Overflowing = []Encoding{e.Slice(0, n)}
+		return truncated, truncated.Overflowing
+	}
+	if stride >= maxLen {
+		panic(fmt.Sprintf("tokenizers: truncation stride %d must be smaller than max length %d", stride, maxLen))
+	}
+
+	step := maxLen - stride
+	var parts []Encoding
+	switch dir {
+	case TruncationDirectionLeft:
+		for stop := n; ; stop -= step {
+			start := max(stop-maxLen, 0)
+			parts = append(parts, e.Slice(start, stop))
+			if start == 0 {
+				break
+			}
+		}
+	default:
+		for start := 0; ; start += step {
+			stop := min(start+maxLen, n)
+			parts = append(parts, e.Slice(start, stop))
+			if stop == n {
+				break
+			}
+		}
+	}
+	truncated := parts[0]
+	truncated.Overflowing = parts[1:]
+	return truncated, truncated.Overflowing
+}
+
+func sliceOf[T any](s []T, start, end int) []T {
+	if s == nil {
+		return nil
+	}
+	return slices.Clone(s[start:end])
+}
+
+// mergeOf concatenates an attribute of the encodings, it returns nil if the
+// attribute is missing from an encoding with tokens.
+func mergeOf[T any](encodings []Encoding, attr func(Encoding) []T) []T {
+	var merged []T
+	for _, e := range encodings {
+		s := attr(e)
+		if s == nil && len(e.IDs) > 0 {
+			return nil
+		}
+		merged = append(merged, s...)
+	}
+	return merged
+}
+
+// padOf returns a copy of s with n values added on the left or on the right,
+// it returns nil if s is nil.
+func padOf[T any](s []T, n int, value T, left bool) []T {
+	if s == nil {
+		return nil
+	}
+	padded := make([]T, 0, len(s)+max(n, 0))
+	if left {
+		for i := 0; i < n; i++ {
+			padded = append(padded, value)
+		}
+	}
+	padded = append(padded, s...)
+	if !left {
+		for i := 0; i < n; i++ {
+			padded = append(padded, value)
+		}
+	}
+	return padded
+}
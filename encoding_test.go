@@ -0,0 +1,73 @@
+package tokenizers_test
+
+import (
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const encodingTestText = "brown fox jumps over the lazy dog"
+
+func encodeAll(t *testing.T, opts ...tokenizers.TokenizerOption) tokenizers.Encoding {
+	t.Helper()
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json", opts...)
+	require.NoError(t, err)
+	defer tk.Close()
+	return tk.EncodeWithOptions(encodingTestText, false, tokenizers.WithReturnAllAttributes())
+}
+
+func TestEncodingSliceAndMerge(t *testing.T) {
+	encoding := encodeAll(t)
+	require.Len(t, encoding.IDs, 7)
+
+	head, tail := encoding.Slice(0, 3), encoding.Slice(3, 7)
+	assert.Equal(t, []string{"brown", "fox", "jumps"}, head.Tokens)
+	assert.Equal(t, []tokenizers.Offset{{16, 20}, {21, 24}, {25, 29}, {30, 33}}, tail.Offsets)
+	assert.Equal(t, []int{3, 4, 5, 6}, tail.WordIDs)
+	assert.Equal(t, encoding, head.Merge(tail))
+	assert.Equal(t, encoding, tokenizers.Encoding{}.Merge(encoding))
+	assert.Panics(t, func() { encoding.Slice(3, 8) })
+
+	// slices are copies
+	head.IDs[0] = 0
+	assert.Equal(t, uint32(2829), encoding.IDs[0])
+
+	// attributes missing from an encoding are dropped
+	merged := encoding.Merge(tokenizers.Encoding{IDs: []uint32{1012}})
+	assert.Equal(t, append(encoding.IDs, 1012), merged.IDs)
+	assert.Nil(t, merged.Tokens)
+	assert.Nil(t, merged.Offsets)
+}
+
+func TestEncodingPad(t *testing.T) {
+	encoding := encodeAll(t)
+	for _, dir := range []tokenizers.PaddingDirection{tokenizers.PaddingDirectionLeft, tokenizers.PaddingDirectionRight} {
+		want := encodeAll(t, tokenizers.WithPadding(10, dir), tokenizers.WithPadToken("[PAD]"))
+		assert.Equal(t, want, encoding.Pad(10, 0, "[PAD]", dir == tokenizers.PaddingDirectionLeft))
+	}
+	assert.Equal(t, encoding, encoding.Pad(5, 0, "[PAD]", false))
+	assert.Equal(t, tokenizers.Encoding{IDs: []uint32{0, 0}}, tokenizers.Encoding{}.Pad(2, 0, "[PAD]", false))
+}
+
+func TestEncodingTruncate(t *testing.T) {
+	encoding := encodeAll(t)
+	for _, dir := range []tokenizers.TruncationDirection{tokenizers.TruncationDirectionLeft, tokenizers.TruncationDirectionRight} {
+		want := encodeAll(t, tokenizers.WithTruncation(3, dir), tokenizers.WithTruncationStride(1))
+		truncated, overflowing := encoding.Truncate(3, 1, dir)
+		assert.Equal(t, want, truncated)
+		assert.Equal(t, want.Overflowing, overflowing)
+	}
+
+	truncated, overflowing := encoding.Truncate(7, 0, tokenizers.TruncationDirectionRight)
+	assert.Equal(t, encoding, truncated)
+	assert.Nil(t, overflowing)
+
+	truncated, overflowing = encoding.Truncate(0, 0, tokenizers.TruncationDirectionRight)
+	assert.Empty(t, truncated.IDs)
+	assert.Equal(t, []tokenizers.Encoding{encoding}, overflowing)
+
+	assert.Panics(t, func() { encoding.Truncate(3, 3, tokenizers.TruncationDirectionRight) })
+}
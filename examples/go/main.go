@@ -54,7 +54,7 @@ func advanced() error {
 	// encodeOptions = append(encodeOptions, tokenizers.WithReturnAllAttributes())
 
 	// regardless of how the tokenizer was initialized, the output is the same
-	for _, tkzr := range []*tokenizers.Tokenizer{tk, tkFromHf} {
+	for _, tkzr := range []*tokenizers.Tokenizer{tk, tkFromHf.Tokenizer} {
 		encodingResponse := tkzr.EncodeWithOptions("brown fox jumps over the lazy dog", true, encodeOptions...)
 		fmt.Println(encodingResponse.IDs)
 		// [101 2829 4419 14523 2058 1996 13971 3899 102]
@@ -0,0 +1,563 @@
+package tokenizers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const ggufMagic = "GGUF"
+
+// GGUF metadata value types.
+const (
+	ggufUint8 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// ggufMaxLength bounds string and array lengths to fail fast on corrupt files.
+const ggufMaxLength = 1 << 28
+
+// ggufMaxPrealloc bounds the memory allocated for a string or an array before its
+// contents are read, so a corrupt length fails at the end of the file instead.
+const ggufMaxPrealloc = 1 << 16
+
+// ggufPreTokenizerPatterns are the regexes of byte-level BPE vocabularies, keyed by tokenizer.ggml.pre.
+var ggufPreTokenizerPatterns = map[string]string{
+	"llama3":    PatternLlama3,
+	"llama-bpe": PatternLlama3,
+	"smaug-bpe": PatternLlama3,
+	"qwen2":     PatternQwen2,
+}
+
+// FromGGUF creates a tokenizer from the vocabulary embedded in a GGUF model file,
+// as used by llama.cpp. Only the metadata section of the file is read.
+// SentencePiece style ("llama") and byte-level BPE ("gpt2") vocabularies are supported.
+func FromGGUF(path string, opts ...TokenizerOption) (*Tokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GGUF file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	metadata, err := readGGUFMetadata(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GGUF metadata from %s: %w", path, err)
+	}
+	vocab, err := newGGUFVocab(metadata)
+	if err != nil {
+		return nil, err
+	}
+	data, err := vocab.tokenizerJSON()
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data, opts...)
+}
+
+// ggufScalarSizes are the sizes in bytes of the fixed-size value types.
+var ggufScalarSizes = map[uint32]int{
+	ggufUint8:   1,
+	ggufInt8:    1,
+	ggufBool:    1,
+	ggufUint16:  2,
+	ggufInt16:   2,
+	ggufUint32:  4,
+	ggufInt32:   4,
+	ggufFloat32: 4,
+	ggufUint64:  8,
+	ggufInt64:   8,
+	ggufFloat64: 8,
+}
+
+type ggufReader struct {
+	r io.Reader
+	// scratch space of fixed-size values
+	buf [8]byte
+}
+
+func (r *ggufReader) read(v any) error {
+	return binary.Read(r.r, binary.LittleEndian, v)
+}
+
+// fixed reads a value of n bytes, valid until the next read.
+func (r *ggufReader) fixed(n int) ([]byte, error) {
+	b := r.buf[:n]
+	_, err := io.ReadFull(r.r, b)
+	return b, err
+}
+
+func (r *ggufReader) uint32() (uint32, error) {
+	b, err := r.fixed(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *ggufReader) discard(n uint64) error {
+	_, err := io.CopyN(io.Discard, r.r, int64(n))
+	return err
+}
+
+func (r *ggufReader) length() (uint64, error) {
+	b, err := r.fixed(8)
+	if err != nil {
+		return 0, err
+	}
+	n := binary.LittleEndian.Uint64(b)
+	if n > ggufMaxLength {
+		return 0, fmt.Errorf("length %d is too large", n)
+	}
+	return n, nil
+}
+
+func (r *ggufReader) string() (string, error) {
+	n, err := r.length()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.Grow(int(min(n, ggufMaxPrealloc)))
+	if _, err := io.CopyN(&b, r.r, int64(n)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// value reads a metadata value of the given type. Integers are returned as
+// int64 (uint64 for uint64), floats as float64. String, float32 and int32
+// arrays are returned as []string, []float32 and []int32, other arrays as []any.
+func (r *ggufReader) value(typ uint32) (any, error) {
+	switch typ {
+	case ggufString:
+		return r.string()
+	case ggufArray:
+		return r.array()
+	}
+	size, ok := ggufScalarSizes[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown value type %d", typ)
+	}
+	b, err := r.fixed(size)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case ggufUint8:
+		return int64(b[0]), nil
+	case ggufInt8:
+		return int64(int8(b[0])), nil
+	case ggufUint16:
+		return int64(binary.LittleEndian.Uint16(b)), nil
+	case ggufInt16:
+		return int64(int16(binary.LittleEndian.Uint16(b))), nil
+	case ggufUint32:
+		return int64(binary.LittleEndian.Uint32(b)), nil
+	case ggufInt32:
+		return int64(int32(binary.LittleEndian.Uint32(b))), nil
+	case ggufUint64:
+		return binary.LittleEndian.Uint64(b), nil
+	case ggufInt64:
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	case ggufFloat32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case ggufFloat64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	default:
+		return b[0] != 0, nil
+	}
+}
+
+// array reads an array value, see value.
+func (r *ggufReader) array() (any, error) {
+	elemType, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.length()
+	if err != nil {
+		return nil, err
+	}
+	prealloc := min(n, ggufMaxPrealloc)
+	switch elemType {
+	case ggufString:
+		values := make([]string, 0, prealloc)
+		for i := uint64(0); i < n; i++ {
+			value, err := r.string()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case ggufFloat32:
+		values := make([]float32, 0, prealloc)
+		for i := uint64(0); i < n; i++ {
+			value, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, math.Float32frombits(value))
+		}
+		return values, nil
+	case ggufInt32:
+		values := make([]int32, 0, prealloc)
+		for i := uint64(0); i < n; i++ {
+			value, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, int32(value))
+		}
+		return values, nil
+	}
+	values := make([]any, 0, prealloc)
+	for i := uint64(0); i < n; i++ {
+		value, err := r.value(elemType)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// skip discards a metadata value of the given type without decoding it.
+func (r *ggufReader) skip(typ uint32) error {
+	switch typ {
+	case ggufString:
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		return r.discard(n)
+	case ggufArray:
+		elemType, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		if size, ok := ggufScalarSizes[elemType]; ok {
+			return r.discard(n * uint64(size))
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := r.skip(elemType); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	size, ok := ggufScalarSizes[typ]
+	if !ok {
+		return fmt.Errorf("unknown value type %d", typ)
+	}
+	return r.discard(uint64(size))
+}
+
+// readGGUFMetadata reads the header and metadata key-value pairs of a GGUF file,
+// keeping only the tokenizer.* keys. Tensor data is never read.
+func readGGUFMetadata(rd io.Reader) (map[string]any, error) {
+	r := &ggufReader{r: rd}
+	magic := make([]byte, len(ggufMagic))
+	if _, err := io.ReadFull(rd, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != ggufMagic {
+		return nil, errors.New("not a GGUF file")
+	}
+	var version uint32
+	if err := r.read(&version); err != nil {
+		return nil, err
+	}
+	if version < 2 {
+		return nil, fmt.Errorf("unsupported GGUF version %d", version)
+	}
+	var header struct {
+		TensorCount   uint64
+		MetadataCount uint64
+	}
+	if err := r.read(&header); err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]any{}
+	for i := uint64(0); i < header.MetadataCount; i++ {
+		key, err := r.string()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata key %d: %w", i, err)
+		}
+		var typ uint32
+		if err := r.read(&typ); err != nil {
+			return nil, fmt.Errorf("failed to read type of %s: %w", key, err)
+		}
+		if !strings.HasPrefix(key, "tokenizer.") {
+			if err := r.skip(typ); err != nil {
+				return nil, fmt.Errorf("failed to skip value of %s: %w", key, err)
+			}
+			continue
+		}
+		value, err := r.value(typ)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read value of %s: %w", key, err)
+		}
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
+// ggufVocab is the tokenizer stored in the tokenizer.ggml.* metadata.
+type ggufVocab struct {
+	model      string
+	pre        string
+	tokens     []string
+	scores     []float32
+	tokenTypes []int32
+	merges     []string
+
+	bosID int
+	eosID int
+	unkID int
+
+	addBOS         bool
+	addEOS         bool
+	addSpacePrefix bool
+}
+
+func newGGUFVocab(metadata map[string]any) (*ggufVocab, error) {
+	var err error
+	str := func(key string) string {
+		s, ok := metadata[key].(string)
+		if !ok && metadata[key] != nil && err == nil {
+			err = fmt.Errorf("GGUF metadata %s is not a string", key)
+		}
+		return s
+	}
+	integer := func(key string, fallback int) int {
+		switch v := metadata[key].(type) {
+		case nil:
+			return fallback
+		case int64:
+			return int(v)
+		case uint64:
+			return int(v)
+		}
+		if err == nil {
+			err = fmt.Errorf("GGUF metadata %s is not an integer", key)
+		}
+		return fallback
+	}
+	boolean := func(key string, fallback bool) bool {
+		switch v := metadata[key].(type) {
+		case nil:
+			return fallback
+		case bool:
+			return v
+		}
+		if err == nil {
+			err = fmt.Errorf("GGUF metadata %s is not a bool", key)
+		}
+		return fallback
+	}
+
+	v := &ggufVocab{
+		model:      str("tokenizer.ggml.model"),
+		pre:        str("tokenizer.ggml.pre"),
+		tokens:     ggufTypedArray[string](metadata, "tokenizer.ggml.tokens", &err),
+		scores:     ggufTypedArray[float32](metadata, "tokenizer.ggml.scores", &err),
+		tokenTypes: ggufTypedArray[int32](metadata, "tokenizer.ggml.token_type", &err),
+		merges:     ggufTypedArray[string](metadata, "tokenizer.ggml.merges", &err),
+	}
+
+	// defaults follow llama.cpp
+	isSPM := v.model == "llama"
+	defaultID := func(spm int) int {
+		if isSPM {
+			return spm
+		}
+		return -1
+	}
+	v.bosID = integer("tokenizer.ggml.bos_token_id", defaultID(1))
+	v.eosID = integer("tokenizer.ggml.eos_token_id", defaultID(2))
+	v.unkID = integer("tokenizer.ggml.unknown_token_id", defaultID(0))
+	v.addBOS = boolean("tokenizer.ggml.add_bos_token", isSPM)
+	v.addEOS = boolean("tokenizer.ggml.add_eos_token", false)
+	v.addSpacePrefix = boolean("tokenizer.ggml.add_space_prefix", isSPM)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.model == "" {
+		return nil, errors.New("GGUF file has no tokenizer.ggml.model")
+	}
+	if len(v.tokens) == 0 {
+		return nil, errors.New("GGUF file has no tokenizer.ggml.tokens")
+	}
+	if v.scores != nil && len(v.scores) != len(v.tokens) {
+		return nil, fmt.Errorf("GGUF file has %d scores for %d tokens", len(v.scores), len(v.tokens))
+	}
+	if v.tokenTypes == nil {
+		v.tokenTypes = make([]int32, len(v.tokens))
+		for i := range v.tokenTypes {
+			v.tokenTypes[i] = spmNormal
+		}
+	} else if len(v.tokenTypes) != len(v.tokens) {
+		return nil, fmt.Errorf("GGUF file has %d token types for %d tokens", len(v.tokenTypes), len(v.tokens))
+	}
+	return v, nil
+}
+
+// ggufTypedArray returns the array metadata[key] with elements of type T, nil if
+// it's missing. The first error is kept in err.
+func ggufTypedArray[T any](metadata map[string]any, key string, err *error) []T {
+	v, ok := metadata[key].([]T)
+	if !ok && metadata[key] != nil && *err == nil {
+		*err = fmt.Errorf("GGUF metadata %s is not an array of %T", key, *new(T))
+	}
+	return v
+}
+
+// tokenAt returns the token with the given ID, or "" if the ID is out of range.
+func (v *ggufVocab) tokenAt(id int) string {
+	if id < 0 || id >= len(v.tokens) {
+		return ""
+	}
+	return v.tokens[id]
+}
+
+func (v *ggufVocab) tokenizerJSON() ([]byte, error) {
+	switch v.model {
+	case "llama":
+		return v.sentencePieceJSON()
+	case "gpt2":
+		return v.byteLevelJSON()
+	default:
+		return nil, fmt.Errorf("unsupported GGUF tokenizer model %q, only llama and gpt2 are supported", v.model)
+	}
+}
+
+// sentencePieceJSON converts a llama vocabulary like a SentencePiece BPE model.
+func (v *ggufVocab) sentencePieceJSON() ([]byte, error) {
+	// merges are derived from the scores, without them every merge would tie
+	if v.scores == nil {
+		return nil, errors.New("GGUF llama vocabulary has no tokenizer.ggml.scores")
+	}
+	m := &spmModel{
+		pieces:         make([]spmPiece, len(v.tokens)),
+		modelType:      spmBPE,
+		unkID:          v.unkID,
+		bosID:          v.bosID,
+		eosID:          v.eosID,
+		addDummyPrefix: v.addSpacePrefix,
+	}
+	for i, token := range v.tokens {
+		m.pieces[i] = spmPiece{piece: token, score: v.scores[i], typ: int(v.tokenTypes[i])}
+		if v.tokenTypes[i] == spmByte {
+			m.byteFallback = true
+		}
+	}
+	return sentencePieceTokenizerJSON(m, SentencePieceOptions{AddBOS: v.addBOS, AddEOS: v.addEOS})
+}
+
+// byteLevelJSON converts a gpt2 vocabulary into a byte-level BPE tokenizer,
+// choosing the pre-tokenizer regex from tokenizer.ggml.pre.
+func (v *ggufVocab) byteLevelJSON() ([]byte, error) {
+	vocab := make(map[string]uint32, len(v.tokens))
+	for i, token := range v.tokens {
+		vocab[token] = uint32(i)
+	}
+	merges := make([][2]string, len(v.merges))
+	for i, merge := range v.merges {
+		parts := strings.Split(merge, " ")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid GGUF merge %d: %q", i, merge)
+		}
+		merges[i] = [2]string{parts[0], parts[1]}
+	}
+
+	opts := BPEOptions{}
+	switch v.pre {
+	case "", "default", "gpt-2", "gpt2":
+	default:
+		pattern, ok := ggufPreTokenizerPatterns[v.pre]
+		if !ok {
+			return nil, fmt.Errorf("unsupported GGUF pre-tokenizer %q", v.pre)
+		}
+		preTokenizer, err := json.Marshal(map[string]any{
+			"type": "Sequence",
+			"pretokenizers": []any{
+				map[string]any{"type": "Split", "pattern": map[string]string{"Regex": pattern}, "behavior": "Isolated", "invert": false},
+				map[string]any{"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": false},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts.PreTokenizer = preTokenizer
+	}
+
+	var extra []addedToken
+	for i, typ := range v.tokenTypes {
+		switch typ {
+		case spmControl:
+			opts.SpecialTokens = append(opts.SpecialTokens, v.tokens[i])
+		case spmUserDefined:
+			extra = append(extra, addedToken{ID: uint32(i), Content: v.tokens[i]})
+		}
+	}
+
+	if v.addBOS || v.addEOS {
+		var bos, eos string
+		if v.addBOS {
+			if bos = v.tokenAt(v.bosID); bos == "" {
+				return nil, errors.New("GGUF file has no BOS token")
+			}
+		}
+		if v.addEOS {
+			if eos = v.tokenAt(v.eosID); eos == "" {
+				return nil, errors.New("GGUF file has no EOS token")
+			}
+		}
+		template := bosEOSProcessing(bos, v.bosID, eos, v.eosID)
+		postProcessor, err := json.Marshal(map[string]any{
+			"type": "Sequence",
+			"processors": []any{
+				map[string]any{"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": false, "use_regex": true},
+				template,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		opts.PostProcessor = postProcessor
+	}
+
+	data, err := bpeTokenizerJSON(vocab, merges, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra) == 0 {
+		return data, nil
+	}
+	data, _, err = mergeAddedTokens(data, extra, nil)
+	return data, err
+}
@@ -0,0 +1,214 @@
+package tokenizers_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ggufKV is a GGUF metadata entry, values are encoded according to their Go type.
+type ggufKV struct {
+	key   string
+	value any
+}
+
+// ggufArrayHeader is an array of n elements of elemType without its elements.
+type ggufArrayHeader struct {
+	elemType uint32
+	n        uint64
+}
+
+// writeGGUF writes a GGUF v3 file with the given metadata and no tensors.
+func writeGGUF(t *testing.T, kvs []ggufKV) string {
+	t.Helper()
+	var buf bytes.Buffer
+	write := func(v any) {
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, v))
+	}
+	writeString := func(s string) {
+		write(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	// value types from the GGUF spec
+	const (
+		typeUint32  = 4
+		typeInt32   = 5
+		typeFloat32 = 6
+		typeBool    = 7
+		typeString  = 8
+		typeArray   = 9
+		typeUint64  = 10
+	)
+	writeArray := func(elemType uint32, n uint64) {
+		write(uint32(typeArray))
+		write(elemType)
+		write(n)
+	}
+
+	buf.WriteString("GGUF")
+	write(uint32(3))
+	write(uint64(0))
+	write(uint64(len(kvs)))
+	for _, kv := range kvs {
+		writeString(kv.key)
+		switch v := kv.value.(type) {
+		case uint32:
+			write(uint32(typeUint32))
+			write(v)
+		case uint64:
+			write(uint32(typeUint64))
+			write(v)
+		case bool:
+			write(uint32(typeBool))
+			write(v)
+		case string:
+			write(uint32(typeString))
+			writeString(v)
+		case []string:
+			writeArray(typeString, uint64(len(v)))
+			for _, s := range v {
+				writeString(s)
+			}
+		case []float32:
+			writeArray(typeFloat32, uint64(len(v)))
+			write(v)
+		case []int32:
+			writeArray(typeInt32, uint64(len(v)))
+			write(v)
+		case ggufArrayHeader:
+			writeArray(v.elemType, v.n)
+		default:
+			t.Fatalf("unsupported GGUF value %T", v)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	return path
+}
+
+func TestFromGGUFLlama(t *testing.T) {
+	// same vocabulary as test/data/sentencepiece/llama-bpe.model
+	tokens := []string{"<unk>", "<s>", "</s>"}
+	types := []int32{2, 3, 3}
+	for b := 0; b < 256; b++ {
+		tokens = append(tokens, fmt.Sprintf("<0x%02X>", b))
+		types = append(types, 6)
+	}
+	tokens = append(tokens, "ll", "he", "▁w", "or", "llo", "▁he", "▁hello", "ld", "▁wor", "▁world", "▁", "h", "e", "l", "o", "w", "r", "d")
+	scores := make([]float32, len(tokens))
+	for i := 259; i < len(tokens); i++ {
+		scores[i] = float32(259 - i)
+		types = append(types, 1)
+	}
+
+	path := writeGGUF(t, []ggufKV{
+		{"general.architecture", "llama"},
+		{"general.tags", []string{"synthetic"}},
+		// other values are skipped without being decoded
+		{"general.weights", []float32{0.5, 0.25}},
+		{"general.tags_per_layer", ggufArrayHeader{elemType: 9, n: 0}},
+		{"general.file_type", uint64(7)},
+		{"tokenizer.ggml.model", "llama"},
+		{"tokenizer.ggml.tokens", tokens},
+		{"tokenizer.ggml.scores", scores},
+		{"tokenizer.ggml.token_type", types},
+		{"tokenizer.ggml.bos_token_id", uint32(1)},
+		{"tokenizer.ggml.eos_token_id", uint32(2)},
+		{"tokenizer.ggml.unknown_token_id", uint32(0)},
+		{"tokenizer.ggml.add_bos_token", true},
+	})
+	tk, err := tokenizers.FromGGUF(path)
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, uint32(277), tk.VocabSize())
+
+	ids, tokens := tk.Encode("hello world", true)
+	assert.Equal(t, []uint32{1, 265, 268}, ids)
+	assert.Equal(t, []string{"<s>", "▁hello", "▁world"}, tokens)
+	assert.Equal(t, "hello world", tk.Decode(ids, true))
+
+	ids, _ = tk.Encode("hé", false)
+	assert.Equal(t, []uint32{269, 270, 198, 172}, ids)
+	assert.Equal(t, "hé", tk.Decode(ids, true))
+}
+
+func TestFromGGUFGPT2(t *testing.T) {
+	tokens := []string{
+		"h", "e", "l", "o", "Ġ", "w", "r", "d",
+		"he", "ll", "llo", "hello", "Ġw", "or", "Ġwor", "ld", "Ġworld",
+		"<|begin_of_text|>", "<|end_of_text|>", "<tool>",
+	}
+	types := make([]int32, len(tokens))
+	for i := range types {
+		types[i] = 1
+	}
+	types[17], types[18], types[19] = 3, 3, 4
+
+	kvs := []ggufKV{
+		{"tokenizer.ggml.model", "gpt2"},
+		{"tokenizer.ggml.pre", "llama-bpe"},
+		{"tokenizer.ggml.tokens", tokens},
+		{"tokenizer.ggml.token_type", types},
+		{"tokenizer.ggml.merges", []string{"h e", "l l", "ll o", "he llo", "Ġ w", "o r", "Ġw or", "l d", "Ġwor ld"}},
+		{"tokenizer.ggml.bos_token_id", uint32(17)},
+		{"tokenizer.ggml.eos_token_id", uint64(18)},
+		{"tokenizer.ggml.add_bos_token", true},
+	}
+	tk, err := tokenizers.FromGGUF(writeGGUF(t, kvs))
+	require.NoError(t, err)
+	defer tk.Close()
+
+	encoding := tk.EncodeWithOptions("hello world<tool><|end_of_text|>", true, tokenizers.WithReturnAllAttributes())
+	assert.Equal(t, []uint32{17, 11, 16, 19, 18}, encoding.IDs)
+	assert.Equal(t, []string{"<|begin_of_text|>", "hello", "Ġworld", "<tool>", "<|end_of_text|>"}, encoding.Tokens)
+	assert.Equal(t, []uint32{1, 0, 0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, "hello world<tool>", tk.Decode(encoding.IDs, true))
+
+	kvs[1].value = "unknown-pre"
+	_, err = tokenizers.FromGGUF(writeGGUF(t, kvs))
+	require.Error(t, err)
+}
+
+func TestFromGGUFErrors(t *testing.T) {
+	_, err := tokenizers.FromGGUF("./test/data/bert-base-uncased.json")
+	require.Error(t, err)
+
+	_, err = tokenizers.FromGGUF(writeGGUF(t, []ggufKV{{"general.architecture", "llama"}}))
+	require.Error(t, err)
+
+	_, err = tokenizers.FromGGUF(writeGGUF(t, []ggufKV{
+		{"tokenizer.ggml.model", "bert"},
+		{"tokenizer.ggml.tokens", []string{"[UNK]"}},
+	}))
+	require.Error(t, err)
+
+	// merges of llama vocabularies are derived from the scores
+	_, err = tokenizers.FromGGUF(writeGGUF(t, []ggufKV{
+		{"tokenizer.ggml.model", "llama"},
+		{"tokenizer.ggml.tokens", []string{"<unk>", "<s>", "</s>", "▁", "a", "▁a"}},
+	}))
+	require.ErrorContains(t, err, "tokenizer.ggml.scores")
+
+	// token types are int32
+	_, err = tokenizers.FromGGUF(writeGGUF(t, []ggufKV{
+		{"tokenizer.ggml.model", "gpt2"},
+		{"tokenizer.ggml.tokens", []string{"a"}},
+		{"tokenizer.ggml.token_type", []float32{1}},
+	}))
+	require.ErrorContains(t, err, "tokenizer.ggml.token_type is not an array of int32")
+
+	// a corrupt array length fails at the end of the file without allocating it
+	_, err = tokenizers.FromGGUF(writeGGUF(t, []ggufKV{
+		{"tokenizer.ggml.tokens", ggufArrayHeader{elemType: 8, n: 1 << 27}},
+	}))
+	require.Error(t, err)
+}
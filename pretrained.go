@@ -0,0 +1,501 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const baseURL = "https://huggingface.co"
+const defaultHTTPTimeout = 30 * time.Second
+
+var hfHTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
+
+// List of tokenizer files fetched from the model repository.
+// None of them is mandatory on its own, but the repository has to provide
+// either tokenizer.json or the legacy vocab.txt / vocab.json + merges.txt.
+var tokenizerFiles = []string{
+	"tokenizer.json",
+	"tokenizer_config.json",
+	"vocab.txt",
+	"vocab.json",
+	"merges.txt",
+	"special_tokens_map.json",
+	"added_tokens.json",
+}
+
+// WithCacheDir keeps the files downloaded by FromPretrained in path instead of
+// a temporary directory, files already present are not downloaded again.
+func WithCacheDir(path string) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.cacheDir = &path
+	}
+}
+
+// WithAuthToken authenticates the requests of FromPretrained, e.g. for gated models.
+func WithAuthToken(token string) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.authToken = &token
+	}
+}
+
+func normalizeModelID(modelID string) (string, error) {
+	modelID = strings.TrimSpace(modelID)
+	if modelID == "" {
+		return "", fmt.Errorf("modelID cannot be empty")
+	}
+	if strings.ContainsRune(modelID, '\x00') {
+		return "", fmt.Errorf("modelID contains an invalid null byte")
+	}
+	return modelID, nil
+}
+
+func safeJoinCacheDir(baseDir, modelID string) (string, error) {
+	cleanModelID := filepath.Clean(modelID)
+	if filepath.IsAbs(cleanModelID) {
+		return "", fmt.Errorf("modelID must be relative")
+	}
+	if cleanModelID == ".." || strings.HasPrefix(cleanModelID, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("modelID must not escape cache directory")
+	}
+
+	baseClean := filepath.Clean(baseDir)
+	target := filepath.Join(baseClean, cleanModelID)
+	rel, err := filepath.Rel(baseClean, target)
+	if err != nil {
+		return "", fmt.Errorf("failed to validate cache path: %w", err)
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("modelID must not escape cache directory")
+	}
+
+	return target, nil
+}
+
+// FromPretrained downloads necessary files and initializes the tokenizer.
+// See FromPretrainedDir for how the downloaded files are combined.
+// Parameters:
+//   - modelID: The Hugging Face model identifier (e.g., "bert-base-uncased").
+//   - WithCacheDir(path): Optional. If provided, files will be downloaded to this folder.
+//   - WithAuthToken(token): Optional. If provided, it will be used to authenticate requests.
+//
+// Other options are applied to the tokenizer like FromBytes does.
+func FromPretrained(modelID string, opts ...TokenizerOption) (*Pretrained, error) {
+	cfg := newTokenizerOpts(opts)
+	// download options are consumed here, fail early on the others
+	dirOpts := *cfg
+	dirOpts.cacheDir, dirOpts.authToken = nil, nil
+	if err := dirOpts.validate(huggingFaceCapabilities); err != nil {
+		return nil, err
+	}
+	normalizedModelID, err := normalizeModelID(modelID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct the model URL
+	modelURL := fmt.Sprintf("%s/%s/resolve/main", baseURL, normalizedModelID)
+
+	// Determine the download directory
+	var downloadDir string
+	isTempDir := false
+	if cfg.cacheDir != nil {
+		downloadDir, err = safeJoinCacheDir(*cfg.cacheDir, normalizedModelID)
+		if err != nil {
+			return nil, err
+		}
+		// Create the destination directory if it doesn't exist
+		err = os.MkdirAll(downloadDir, os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create destination directory %s: %w", downloadDir, err)
+		}
+	} else {
+		// Create a temporary directory
+		tmpDir, err := os.MkdirTemp("", "huggingface-tokenizer-*")
+		if err != nil {
+			return nil, fmt.Errorf("error creating temporary directory: %w", err)
+		}
+		downloadDir = tmpDir
+		isTempDir = true
+	}
+	if isTempDir {
+		defer func() {
+			_ = os.RemoveAll(downloadDir)
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	downloadErrs := make(map[string]error)
+
+	// Download each tokenizer file concurrently
+	for _, filename := range tokenizerFiles {
+		wg.Add(1)
+		go func(fn string) {
+			defer wg.Done()
+			fileURL := fmt.Sprintf("%s/%s", modelURL, fn)
+			destPath := filepath.Join(downloadDir, fn)
+			if err := downloadFile(fileURL, destPath, cfg.authToken); err != nil {
+				mu.Lock()
+				downloadErrs[fn] = err
+				mu.Unlock()
+			}
+		}(filename)
+	}
+	wg.Wait()
+
+	// Missing optional files are expected, but a repository without any
+	// model file is reported with the reason tokenizer.json was unavailable.
+	if _, ok := downloadErrs["tokenizer.json"]; ok {
+		_, vocabTxtErr := downloadErrs["vocab.txt"]
+		_, vocabJSONErr := downloadErrs["vocab.json"]
+		_, mergesErr := downloadErrs["merges.txt"]
+		if vocabTxtErr && (vocabJSONErr || mergesErr) {
+			return nil, fmt.Errorf("failed to download mandatory file tokenizer.json: %w", downloadErrs["tokenizer.json"])
+		}
+	}
+
+	return fromPretrainedDir(downloadDir, &dirOpts)
+}
+
+// downloadFile downloads a file from the given URL and saves it to the specified destination.
+// If authToken is provided (non-nil), it will be used for authorization.
+// Returns an error if the download fails.
+func downloadFile(url, destination string, authToken *string) error {
+	// Check if the file already exists
+	if _, err := os.Stat(destination); err == nil {
+		return nil
+	}
+
+	// Create a new HTTP request
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+
+	// If authToken is provided, set the Authorization header
+	if authToken != nil {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *authToken))
+	}
+
+	resp, err := hfHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	// Check for successful response
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download from %s: status code %d", url, resp.StatusCode)
+	}
+
+	// Create the destination file
+	out, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", destination, err)
+	}
+	defer out.Close()
+
+	// Write the response body to the file
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write to file %s: %w", destination, err)
+	}
+
+	return nil
+}
+
+// SpecialTokens holds the content of special tokens by role, as declared in
+// tokenizer_config.json and special_tokens_map.json. Roles the model does not
+// use are left empty.
+type SpecialTokens struct {
+	BOS        string
+	EOS        string
+	UNK        string
+	SEP        string
+	PAD        string
+	CLS        string
+	Mask       string
+	Additional []string
+}
+
+// all returns the non-empty special tokens of every role.
+func (st SpecialTokens) all() []string {
+	var tokens []string
+	for _, token := range []string{st.BOS, st.EOS, st.UNK, st.SEP, st.PAD, st.CLS, st.Mask} {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return append(tokens, st.Additional...)
+}
+
+// Pretrained is a tokenizer loaded from a Hugging Face model repository
+// together with the metadata shipped alongside it.
+type Pretrained struct {
+	*Tokenizer
+
+	SpecialTokens SpecialTokens
+	// AddedTokens maps the content of every added token to its ID.
+	AddedTokens map[string]uint32
+	// ModelMaxLength is the maximum input length of the model, 0 if unset.
+	ModelMaxLength int
+	// PaddingSide is either "right" or "left".
+	PaddingSide string
+	// ChatTemplate is the default Jinja chat template, if any.
+	ChatTemplate string
+	// ChatTemplates holds all named templates when the config declares several.
+	ChatTemplates  map[string]string
+	TokenizerClass string
+}
+
+// tokenContent decodes a token that is either a plain string
+// or a serialized AddedToken object.
+type tokenContent string
+
+func (tc *tokenContent) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*tc = tokenContent(s)
+		return nil
+	}
+	var token struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return fmt.Errorf("token must be a string or an AddedToken object: %w", err)
+	}
+	*tc = tokenContent(token.Content)
+	return nil
+}
+
+// chatTemplates decodes a chat template that is either a single string
+// or a list of named templates.
+type chatTemplates map[string]string
+
+func (ct *chatTemplates) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*ct = chatTemplates{"default": s}
+		return nil
+	}
+	var named []struct {
+		Name     string `json:"name"`
+		Template string `json:"template"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return fmt.Errorf("chat_template must be a string or a list of named templates: %w", err)
+	}
+	*ct = make(chatTemplates, len(named))
+	for _, t := range named {
+		(*ct)[t.Name] = t.Template
+	}
+	return nil
+}
+
+// pretrainedConfig covers the fields of tokenizer_config.json and
+// special_tokens_map.json that are used to assemble a tokenizer.
+type pretrainedConfig struct {
+	BOSToken                tokenContent          `json:"bos_token"`
+	EOSToken                tokenContent          `json:"eos_token"`
+	UNKToken                tokenContent          `json:"unk_token"`
+	SEPToken                tokenContent          `json:"sep_token"`
+	PADToken                tokenContent          `json:"pad_token"`
+	CLSToken                tokenContent          `json:"cls_token"`
+	MaskToken               tokenContent          `json:"mask_token"`
+	AdditionalSpecialTokens []tokenContent        `json:"additional_special_tokens"`
+	AddedTokensDecoder      map[string]addedToken `json:"added_tokens_decoder"`
+	ModelMaxLength          *float64              `json:"model_max_length"`
+	PaddingSide             string                `json:"padding_side"`
+	ChatTemplate            chatTemplates         `json:"chat_template"`
+	TokenizerClass          string                `json:"tokenizer_class"`
+	DoLowerCase             *bool                 `json:"do_lower_case"`
+	AddPrefixSpace          *bool                 `json:"add_prefix_space"`
+}
+
+func (cfg *pretrainedConfig) specialTokens() SpecialTokens {
+	st := SpecialTokens{
+		BOS:  string(cfg.BOSToken),
+		EOS:  string(cfg.EOSToken),
+		UNK:  string(cfg.UNKToken),
+		SEP:  string(cfg.SEPToken),
+		PAD:  string(cfg.PADToken),
+		CLS:  string(cfg.CLSToken),
+		Mask: string(cfg.MaskToken),
+	}
+	for _, token := range cfg.AdditionalSpecialTokens {
+		st.Additional = append(st.Additional, string(token))
+	}
+	return st
+}
+
+// overlay replaces special tokens with the ones declared in special_tokens_map.json,
+// which takes precedence over tokenizer_config.json.
+func (cfg *pretrainedConfig) overlay(other *pretrainedConfig) {
+	for _, pair := range []struct{ dst, src *tokenContent }{
+		{&cfg.BOSToken, &other.BOSToken},
+		{&cfg.EOSToken, &other.EOSToken},
+		{&cfg.UNKToken, &other.UNKToken},
+		{&cfg.SEPToken, &other.SEPToken},
+		{&cfg.PADToken, &other.PADToken},
+		{&cfg.CLSToken, &other.CLSToken},
+		{&cfg.MaskToken, &other.MaskToken},
+	} {
+		if *pair.src != "" {
+			*pair.dst = *pair.src
+		}
+	}
+	if len(other.AdditionalSpecialTokens) > 0 {
+		cfg.AdditionalSpecialTokens = other.AdditionalSpecialTokens
+	}
+}
+
+// readJSONFile decodes the file into v. Missing files are not an error,
+// the returned bool reports whether the file was found.
+func readJSONFile(path string, v any) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// FromPretrainedDir initializes the tokenizer from a local directory laid out
+// like a Hugging Face model repository.
+//
+// The tokenizer is built from tokenizer.json if present, otherwise it is
+// assembled from the legacy vocab.txt (WordPiece) or vocab.json + merges.txt
+// (byte-level BPE) files. Tokens from added_tokens.json and the
+// added_tokens_decoder of tokenizer_config.json are added to it, and tokens
+// listed in special_tokens_map.json are marked as special. Special tokens that
+// are not part of the vocabulary are ignored. Options are applied like FromBytes does.
+func FromPretrainedDir(dir string, opts ...TokenizerOption) (*Pretrained, error) {
+	return fromPretrainedDir(dir, newTokenizerOpts(opts))
+}
+
+func fromPretrainedDir(dir string, opts *tokenizerOpts) (*Pretrained, error) {
+	cfg := &pretrainedConfig{}
+	if _, err := readJSONFile(filepath.Join(dir, "tokenizer_config.json"), cfg); err != nil {
+		return nil, err
+	}
+	specialTokensMap := &pretrainedConfig{}
+	if _, err := readJSONFile(filepath.Join(dir, "special_tokens_map.json"), specialTokensMap); err != nil {
+		return nil, err
+	}
+	cfg.overlay(specialTokensMap)
+	specialTokens := cfg.specialTokens()
+
+	var addedTokensFile map[string]uint32
+	if _, err := readJSONFile(filepath.Join(dir, "added_tokens.json"), &addedTokensFile); err != nil {
+		return nil, err
+	}
+
+	data, err := assemblePretrained(dir, cfg, specialTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	isSpecial := make(map[string]bool)
+	for _, token := range specialTokens.all() {
+		isSpecial[token] = true
+	}
+	var extra []addedToken
+	for id, token := range cfg.AddedTokensDecoder {
+		parsed, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token ID %q in added_tokens_decoder: %w", id, err)
+		}
+		token.ID = uint32(parsed)
+		extra = append(extra, token)
+	}
+	for content, id := range addedTokensFile {
+		special := isSpecial[content]
+		extra = append(extra, addedToken{ID: id, Content: content, Special: special, Normalized: !special})
+	}
+
+	data, addedTokens, err := mergeAddedTokens(data, extra, isSpecial)
+	if err != nil {
+		return nil, err
+	}
+
+	tk, err := fromBytes(data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pretrained := &Pretrained{
+		Tokenizer:      tk,
+		SpecialTokens:  specialTokens,
+		AddedTokens:    addedTokens,
+		PaddingSide:    cfg.PaddingSide,
+		TokenizerClass: cfg.TokenizerClass,
+	}
+	if pretrained.PaddingSide == "" {
+		pretrained.PaddingSide = "right"
+	}
+	// transformers uses a very large number (1e30) when the length is unbounded
+	if cfg.ModelMaxLength != nil && *cfg.ModelMaxLength > 0 && *cfg.ModelMaxLength <= math.MaxInt32 {
+		pretrained.ModelMaxLength = int(*cfg.ModelMaxLength)
+	}
+	if len(cfg.ChatTemplate) > 0 {
+		pretrained.ChatTemplate = cfg.ChatTemplate["default"]
+		if len(cfg.ChatTemplate) > 1 {
+			pretrained.ChatTemplates = cfg.ChatTemplate
+		}
+	}
+	return pretrained, nil
+}
+
+// assemblePretrained returns the tokenizer.json contents for the repository in dir.
+func assemblePretrained(dir string, cfg *pretrainedConfig, specialTokens SpecialTokens) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "tokenizer.json"))
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read tokenizer.json: %w", err)
+	}
+
+	if vocab, err := readVocabTxt(filepath.Join(dir, "vocab.txt")); err == nil {
+		return wordPieceTokenizerJSON(vocab, WordPieceOptions{
+			Cased:     cfg.DoLowerCase != nil && !*cfg.DoLowerCase,
+			UnkToken:  specialTokens.UNK,
+			ClsToken:  specialTokens.CLS,
+			SepToken:  specialTokens.SEP,
+			PadToken:  specialTokens.PAD,
+			MaskToken: specialTokens.Mask,
+		})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	vocab, err := readVocabJSON(filepath.Join(dir, "vocab.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no tokenizer.json, vocab.txt or vocab.json found in %s", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	merges, err := readMergesTxt(filepath.Join(dir, "merges.txt"))
+	if err != nil {
+		return nil, err
+	}
+	return bpeTokenizerJSON(vocab, merges, BPEOptions{
+		AddPrefixSpace: cfg.AddPrefixSpace != nil && *cfg.AddPrefixSpace,
+	})
+}
@@ -0,0 +1,126 @@
+package tokenizers_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyBertDir returns a directory with the configs of bert-base-uncased-legacy
+// and the vocab.txt of the vocabulary of bert-base-uncased.json.
+func legacyBertDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := os.ReadFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	var tokenizer struct {
+		Model struct {
+			Vocab map[string]int `json:"vocab"`
+		} `json:"model"`
+	}
+	require.NoError(t, json.Unmarshal(data, &tokenizer))
+	vocab := make([]string, len(tokenizer.Model.Vocab))
+	for token, id := range tokenizer.Model.Vocab {
+		vocab[id] = token
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vocab.txt"), []byte(strings.Join(vocab, "\n")+"\n"), 0o644))
+	for _, name := range []string{"special_tokens_map.json", "tokenizer_config.json"} {
+		data, err := os.ReadFile(filepath.Join("./test/data/bert-base-uncased-legacy", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+	}
+	return dir
+}
+
+func TestFromPretrainedDirLegacyVocab(t *testing.T) {
+	tk, err := tokenizers.FromPretrainedDir(legacyBertDir(t))
+	require.NoError(t, err)
+	defer tk.Close()
+
+	encoding := tk.EncodeWithOptions("Brown fox jumps over the lazy dog", true, tokenizers.WithReturnAllAttributes())
+	assert.Equal(t, []uint32{101, 2829, 4419, 14523, 2058, 1996, 13971, 3899, 102}, encoding.IDs)
+	assert.Equal(t, []string{"[CLS]", "brown", "fox", "jumps", "over", "the", "lazy", "dog", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []uint32{1, 0, 0, 0, 0, 0, 0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, uint32(30522), tk.VocabSize())
+
+	assert.Equal(t, tokenizers.SpecialTokens{
+		UNK:  "[UNK]",
+		SEP:  "[SEP]",
+		PAD:  "[PAD]",
+		CLS:  "[CLS]",
+		Mask: "[MASK]",
+	}, tk.SpecialTokens)
+	assert.Equal(t, uint32(103), tk.AddedTokens["[MASK]"])
+	assert.Equal(t, 512, tk.ModelMaxLength)
+	assert.Equal(t, "right", tk.PaddingSide)
+	assert.Equal(t, "BertTokenizer", tk.TokenizerClass)
+}
+
+func TestFromPretrainedDirMergesAddedTokens(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	files := map[string]string{
+		"tokenizer.json":          string(data),
+		"added_tokens.json":       `{"<ent>": 30522, "<rel>": 30523}`,
+		"special_tokens_map.json": `{"cls_token": {"content": "[CLS]", "lstrip": false}, "additional_special_tokens": ["<ent>"]}`,
+		"tokenizer_config.json": `{
+			"model_max_length": 1000000000000000019884624838656,
+			"padding_side": "left",
+			"eos_token": null,
+			"chat_template": [
+				{"name": "default", "template": "{{ messages }}"},
+				{"name": "tool_use", "template": "{{ tools }}"}
+			]
+		}`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	tk, err := tokenizers.FromPretrainedDir(dir)
+	require.NoError(t, err)
+	defer tk.Close()
+
+	encoding := tk.EncodeWithOptions("fox <ent> <rel>", false, tokenizers.WithReturnAllAttributes())
+	assert.Equal(t, []uint32{4419, 30522, 30523}, encoding.IDs)
+	assert.Equal(t, []uint32{0, 1, 0}, encoding.SpecialTokensMask)
+	assert.Equal(t, uint32(30524), tk.VocabSize())
+
+	assert.Equal(t, "[CLS]", tk.SpecialTokens.CLS)
+	assert.Equal(t, "", tk.SpecialTokens.EOS)
+	assert.Equal(t, []string{"<ent>"}, tk.SpecialTokens.Additional)
+	assert.Equal(t, uint32(30523), tk.AddedTokens["<rel>"])
+	assert.Equal(t, 0, tk.ModelMaxLength)
+	assert.Equal(t, "left", tk.PaddingSide)
+	assert.Equal(t, "{{ messages }}", tk.ChatTemplate)
+	assert.Equal(t, map[string]string{"default": "{{ messages }}", "tool_use": "{{ tools }}"}, tk.ChatTemplates)
+}
+
+func TestFromPretrainedDirAddedTokenIDCollision(t *testing.T) {
+	data, err := os.ReadFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	for _, added := range []string{
+		`{"<ent>": 30522, "<rel>": 30522}`,
+		// the ID of [UNK]
+		`{"<ent>": 100}`,
+	} {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "tokenizer.json"), data, 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "added_tokens.json"), []byte(added), 0o644))
+		_, err := tokenizers.FromPretrainedDir(dir)
+		assert.ErrorContains(t, err, "has the ID", added)
+	}
+}
+
+func TestFromPretrainedDirMissingVocab(t *testing.T) {
+	_, err := tokenizers.FromPretrainedDir(t.TempDir())
+	require.Error(t, err)
+}
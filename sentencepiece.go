@@ -0,0 +1,512 @@
+package tokenizers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// SentencePiece piece types, see sentencepiece_model.proto.
+const (
+	spmNormal      = 1
+	spmUnknown     = 2
+	spmControl     = 3
+	spmUserDefined = 4
+	spmUnused      = 5
+	spmByte        = 6
+)
+
+// SentencePiece model types, see sentencepiece_model.proto.
+const (
+	spmUnigram = 1
+	spmBPE     = 2
+)
+
+const spmReplacement = "▁"
+
+type spmPiece struct {
+	piece string
+	score float32
+	typ   int
+}
+
+type spmModel struct {
+	pieces []spmPiece
+
+	modelType    int
+	byteFallback bool
+	unkID        int
+	bosID        int
+	eosID        int
+
+	precompiledCharsmap    []byte
+	addDummyPrefix         bool
+	removeExtraWhitespaces bool
+}
+
+// SentencePieceOptions customizes the tokenizer built by FromSentencePiece.
+// The zero value encodes like SentencePiece itself, without BOS/EOS tokens.
+type SentencePieceOptions struct {
+	// AddBOS prepends the BOS token when encoding with special tokens, like Llama and Gemma.
+	AddBOS bool
+	// AddEOS appends the EOS token when encoding with special tokens, like T5.
+	AddEOS bool
+	// AddDummyPrefix overrides the add_dummy_prefix setting of the model.
+	AddDummyPrefix *bool
+}
+
+// FromSentencePiece creates a tokenizer from a SentencePiece tokenizer.model file,
+// converting it the same way the Hugging Face converters do.
+func FromSentencePiece(path string, opts SentencePieceOptions, loadOpts ...TokenizerOption) (*Tokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SentencePiece model %s: %w", path, err)
+	}
+	return FromSentencePieceBytes(data, opts, loadOpts...)
+}
+
+// FromSentencePieceBytes creates a tokenizer from the contents of a SentencePiece model.
+// Unigram and BPE models are supported, including byte fallback and user defined symbols.
+func FromSentencePieceBytes(data []byte, opts SentencePieceOptions, loadOpts ...TokenizerOption) (*Tokenizer, error) {
+	model, err := parseSentencePiece(data)
+	if err != nil {
+		return nil, err
+	}
+	tokenizerJSON, err := sentencePieceTokenizerJSON(model, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(tokenizerJSON, loadOpts...)
+}
+
+// protoReader decodes the protobuf wire format, just enough for ModelProto.
+type protoReader struct {
+	data []byte
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errors.New("invalid varint")
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// next returns the field number and wire type of the next field.
+func (r *protoReader) next() (int, int, error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(key >> 3), int(key & 7), nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)) {
+		return nil, errors.New("truncated length-delimited field")
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *protoReader) fixed32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errors.New("truncated fixed32 field")
+	}
+	v := binary.LittleEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *protoReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case 0:
+		_, err = r.varint()
+	case 1:
+		if len(r.data) < 8 {
+			return errors.New("truncated fixed64 field")
+		}
+		r.data = r.data[8:]
+	case 2:
+		_, err = r.bytes()
+	case 5:
+		_, err = r.fixed32()
+	default:
+		err = fmt.Errorf("unsupported wire type %d", wireType)
+	}
+	return err
+}
+
+// int32 decodes a varint encoded int32, negative values take 10 bytes.
+func (r *protoReader) int32() (int, error) {
+	v, err := r.varint()
+	return int(int32(v)), err
+}
+
+func parseSentencePiece(data []byte) (*spmModel, error) {
+	model := &spmModel{
+		modelType:              spmUnigram,
+		unkID:                  0,
+		bosID:                  1,
+		eosID:                  2,
+		addDummyPrefix:         true,
+		removeExtraWhitespaces: true,
+	}
+	r := &protoReader{data: data}
+	for len(r.data) > 0 {
+		field, wireType, err := r.next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SentencePiece model: %w", err)
+		}
+		if wireType != 2 || field < 1 || field > 3 {
+			if err := r.skip(wireType); err != nil {
+				return nil, fmt.Errorf("failed to parse SentencePiece model: %w", err)
+			}
+			continue
+		}
+		msg, err := r.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SentencePiece model: %w", err)
+		}
+		switch field {
+		case 1:
+			piece, err := parseSentencePiecePiece(msg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse piece %d: %w", len(model.pieces), err)
+			}
+			model.pieces = append(model.pieces, piece)
+		case 2:
+			if err := model.parseTrainerSpec(msg); err != nil {
+				return nil, fmt.Errorf("failed to parse trainer spec: %w", err)
+			}
+		case 3:
+			if err := model.parseNormalizerSpec(msg); err != nil {
+				return nil, fmt.Errorf("failed to parse normalizer spec: %w", err)
+			}
+		}
+	}
+	if len(model.pieces) == 0 {
+		return nil, errors.New("SentencePiece model has no pieces")
+	}
+	return model, nil
+}
+
+func parseSentencePiecePiece(data []byte) (spmPiece, error) {
+	piece := spmPiece{typ: spmNormal}
+	r := &protoReader{data: data}
+	for len(r.data) > 0 {
+		field, wireType, err := r.next()
+		if err != nil {
+			return piece, err
+		}
+		switch {
+		case field == 1 && wireType == 2:
+			b, err := r.bytes()
+			if err != nil {
+				return piece, err
+			}
+			piece.piece = string(b)
+		case field == 2 && wireType == 5:
+			bits, err := r.fixed32()
+			if err != nil {
+				return piece, err
+			}
+			piece.score = math.Float32frombits(bits)
+		case field == 3 && wireType == 0:
+			typ, err := r.varint()
+			if err != nil {
+				return piece, err
+			}
+			piece.typ = int(typ)
+		default:
+			if err := r.skip(wireType); err != nil {
+				return piece, err
+			}
+		}
+	}
+	return piece, nil
+}
+
+func (m *spmModel) parseTrainerSpec(data []byte) error {
+	r := &protoReader{data: data}
+	for len(r.data) > 0 {
+		field, wireType, err := r.next()
+		if err != nil {
+			return err
+		}
+		if wireType != 0 {
+			if err := r.skip(wireType); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := r.int32()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 3:
+			m.modelType = v
+		case 35:
+			m.byteFallback = v != 0
+		case 40:
+			m.unkID = v
+		case 41:
+			m.bosID = v
+		case 42:
+			m.eosID = v
+		}
+	}
+	return nil
+}
+
+func (m *spmModel) parseNormalizerSpec(data []byte) error {
+	r := &protoReader{data: data}
+	for len(r.data) > 0 {
+		field, wireType, err := r.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 2 && wireType == 2:
+			b, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			m.precompiledCharsmap = b
+		case field == 3 && wireType == 0:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			m.addDummyPrefix = v != 0
+		case field == 4 && wireType == 0:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			m.removeExtraWhitespaces = v != 0
+		default:
+			if err := r.skip(wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pieceAt returns the piece with the given ID, or "" if the ID is disabled or out of range.
+func (m *spmModel) pieceAt(id int) string {
+	if id < 0 || id >= len(m.pieces) {
+		return ""
+	}
+	return m.pieces[id].piece
+}
+
+// bpeMerges derives BPE merges from the pieces the same way the Hugging Face
+// SentencePieceExtractor does: every split of a piece into two known pieces is
+// a merge, ordered by the score of the merged piece.
+func (m *spmModel) bpeMerges(vocab map[string]uint32) [][2]string {
+	type merge struct {
+		left, right string
+		score       float32
+	}
+	var merges []merge
+	for _, p := range m.pieces {
+		var local []merge
+		// Split at rune boundaries, as the converter splits at characters.
+		for i := range p.piece {
+			if i == 0 {
+				continue
+			}
+			left, right := p.piece[:i], p.piece[i:]
+			_, okLeft := vocab[left]
+			_, okRight := vocab[right]
+			if okLeft && okRight {
+				local = append(local, merge{left, right, p.score})
+			}
+		}
+		sort.SliceStable(local, func(i, j int) bool {
+			if vocab[local[i].left] != vocab[local[j].left] {
+				return vocab[local[i].left] < vocab[local[j].left]
+			}
+			return vocab[local[i].right] < vocab[local[j].right]
+		})
+		merges = append(merges, local...)
+	}
+	sort.SliceStable(merges, func(i, j int) bool { return merges[i].score > merges[j].score })
+
+	pairs := make([][2]string, len(merges))
+	for i, mg := range merges {
+		pairs[i] = [2]string{mg.left, mg.right}
+	}
+	return pairs
+}
+
+// sentencePieceTokenizerJSON assembles a tokenizer.json equivalent to the SentencePiece model,
+// following the Llama converter for BPE models and the generic converter for Unigram models.
+func sentencePieceTokenizerJSON(m *spmModel, opts SentencePieceOptions) ([]byte, error) {
+	addDummyPrefix := m.addDummyPrefix
+	if opts.AddDummyPrefix != nil {
+		addDummyPrefix = *opts.AddDummyPrefix
+	}
+	prependScheme := "never"
+	if addDummyPrefix {
+		prependScheme = "always"
+	}
+
+	var addedTokens []addedToken
+	for id, p := range m.pieces {
+		switch p.typ {
+		case spmUnknown, spmControl:
+			addedTokens = append(addedTokens, addedToken{ID: uint32(id), Content: p.piece, Special: true})
+		case spmUserDefined:
+			addedTokens = append(addedTokens, addedToken{ID: uint32(id), Content: p.piece})
+		}
+	}
+	if addedTokens == nil {
+		addedTokens = []addedToken{}
+	}
+
+	doc := map[string]any{
+		"version":      "1.0",
+		"truncation":   nil,
+		"padding":      nil,
+		"added_tokens": addedTokens,
+	}
+	replace := func(pattern map[string]string, content string) map[string]any {
+		return map[string]any{"type": "Replace", "pattern": pattern, "content": content}
+	}
+
+	switch m.modelType {
+	case spmBPE:
+		vocab := make(map[string]uint32, len(m.pieces))
+		for id, p := range m.pieces {
+			vocab[p.piece] = uint32(id)
+		}
+		var normalizers []any
+		if addDummyPrefix {
+			normalizers = append(normalizers, map[string]any{"type": "Prepend", "prepend": spmReplacement})
+		}
+		normalizers = append(normalizers, replace(map[string]string{"String": " "}, spmReplacement))
+		doc["normalizer"] = map[string]any{"type": "Sequence", "normalizers": normalizers}
+		doc["pre_tokenizer"] = nil
+		var unkToken any
+		if unk := m.pieceAt(m.unkID); unk != "" {
+			unkToken = unk
+		}
+		doc["model"] = map[string]any{
+			"type":                      "BPE",
+			"dropout":                   nil,
+			"unk_token":                 unkToken,
+			"continuing_subword_prefix": nil,
+			"end_of_word_suffix":        nil,
+			"fuse_unk":                  true,
+			"byte_fallback":             m.byteFallback,
+			"ignore_merges":             false,
+			"vocab":                     vocab,
+			"merges":                    m.bpeMerges(vocab),
+		}
+		decoders := []any{replace(map[string]string{"String": spmReplacement}, " ")}
+		if m.byteFallback {
+			decoders = append(decoders, map[string]any{"type": "ByteFallback"})
+		}
+		decoders = append(decoders, map[string]any{"type": "Fuse"})
+		if addDummyPrefix {
+			decoders = append(decoders, map[string]any{"type": "Strip", "content": " ", "start": 1, "stop": 0})
+		}
+		doc["decoder"] = map[string]any{"type": "Sequence", "decoders": decoders}
+	case spmUnigram:
+		vocab := make([][2]any, len(m.pieces))
+		for id, p := range m.pieces {
+			vocab[id] = [2]any{p.piece, p.score}
+		}
+		var normalizers []any
+		if len(m.precompiledCharsmap) > 0 {
+			normalizers = append(normalizers, map[string]any{"type": "Precompiled", "precompiled_charsmap": m.precompiledCharsmap})
+		}
+		if m.removeExtraWhitespaces {
+			normalizers = append(normalizers,
+				map[string]any{"type": "Strip", "strip_left": false, "strip_right": true},
+				replace(map[string]string{"Regex": " {2,}"}, spmReplacement),
+			)
+		}
+		if normalizers == nil {
+			doc["normalizer"] = nil
+		} else {
+			doc["normalizer"] = map[string]any{"type": "Sequence", "normalizers": normalizers}
+		}
+		metaspace := map[string]any{"type": "Metaspace", "replacement": spmReplacement, "prepend_scheme": prependScheme, "split": true}
+		doc["pre_tokenizer"] = metaspace
+		var unkID any
+		if m.pieceAt(m.unkID) != "" {
+			unkID = m.unkID
+		}
+		doc["model"] = map[string]any{
+			"type":          "Unigram",
+			"unk_id":        unkID,
+			"vocab":         vocab,
+			"byte_fallback": m.byteFallback,
+		}
+		doc["decoder"] = metaspace
+	default:
+		return nil, fmt.Errorf("unsupported SentencePiece model type %d, only Unigram and BPE are supported", m.modelType)
+	}
+
+	var bos, eos string
+	if opts.AddBOS {
+		if bos = m.pieceAt(m.bosID); bos == "" {
+			return nil, errors.New("SentencePiece model has no BOS token")
+		}
+	}
+	if opts.AddEOS {
+		if eos = m.pieceAt(m.eosID); eos == "" {
+			return nil, errors.New("SentencePiece model has no EOS token")
+		}
+	}
+	doc["post_processor"] = bosEOSProcessing(bos, m.bosID, eos, m.eosID)
+	return json.Marshal(doc)
+}
+
+// bosEOSProcessing returns a TemplateProcessing adding bos before and eos after
+// every sequence, skipping whichever is empty. It returns nil if both are empty.
+func bosEOSProcessing(bos string, bosID int, eos string, eosID int) any {
+	if bos == "" && eos == "" {
+		return nil
+	}
+	var single, pair []any
+	specialTokens := map[string]any{}
+	for typeID, seq := range []string{"A", "B"} {
+		var template []any
+		if bos != "" {
+			template = append(template, map[string]any{"SpecialToken": map[string]any{"id": bos, "type_id": typeID}})
+			specialTokens[bos] = map[string]any{"id": bos, "ids": []int{bosID}, "tokens": []string{bos}}
+		}
+		template = append(template, map[string]any{"Sequence": map[string]any{"id": seq, "type_id": typeID}})
+		if eos != "" {
+			template = append(template, map[string]any{"SpecialToken": map[string]any{"id": eos, "type_id": typeID}})
+			specialTokens[eos] = map[string]any{"id": eos, "ids": []int{eosID}, "tokens": []string{eos}}
+		}
+		if typeID == 0 {
+			single = template
+		}
+		pair = append(pair, template...)
+	}
+	return map[string]any{
+		"type":           "TemplateProcessing",
+		"single":         single,
+		"pair":           pair,
+		"special_tokens": specialTokens,
+	}
+}
@@ -0,0 +1,149 @@
+package tokenizers_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Models in test/data/sentencepiece are hand-written by generate.py in the same directory.
+
+func TestFromSentencePieceBPE(t *testing.T) {
+	tk, err := tokenizers.FromSentencePiece("./test/data/sentencepiece/llama-bpe.model", tokenizers.SentencePieceOptions{
+		AddBOS: true,
+	})
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, uint32(277), tk.VocabSize())
+
+	tests := []struct {
+		name       string
+		str        string
+		addSpecial bool
+		wantIDs    []uint32
+		wantTokens []string
+		wantDecode string
+	}{
+		{
+			name:       "merges with dummy prefix",
+			str:        "hello world",
+			addSpecial: true,
+			wantIDs:    []uint32{1, 265, 268},
+			wantTokens: []string{"<s>", "▁hello", "▁world"},
+			wantDecode: "hello world",
+		},
+		{
+			name:       "byte fallback",
+			str:        "hi!",
+			wantIDs:    []uint32{269, 270, 108, 36},
+			wantTokens: []string{"▁", "h", "<0x69>", "<0x21>"},
+			wantDecode: "hi!",
+		},
+		{
+			name:       "multi-byte fallback",
+			str:        "hé",
+			wantIDs:    []uint32{269, 270, 198, 172},
+			wantTokens: []string{"▁", "h", "<0xC3>", "<0xA9>"},
+			wantDecode: "hé",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, tokens := tk.Encode(tt.str, tt.addSpecial)
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantTokens, tokens)
+			assert.Equal(t, tt.wantDecode, tk.Decode(ids, true))
+		})
+	}
+}
+
+func TestFromSentencePieceBPEWithoutDummyPrefix(t *testing.T) {
+	tk, err := tokenizers.FromSentencePiece("./test/data/sentencepiece/bpe-no-prefix.model", tokenizers.SentencePieceOptions{})
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, uint32(277), tk.VocabSize())
+
+	// the first word has no ▁ to merge with
+	ids, tokens := tk.Encode("hello world", false)
+	assert.Equal(t, []uint32{260, 263, 268}, ids)
+	assert.Equal(t, []string{"he", "llo", "▁world"}, tokens)
+	assert.Equal(t, "hello world", tk.Decode(ids, true))
+	ids, tokens = tk.Encode("world", false)
+	assert.Equal(t, []uint32{274, 262, 266}, ids)
+	assert.Equal(t, []string{"w", "or", "ld"}, tokens)
+	assert.Equal(t, "world", tk.Decode(ids, true))
+}
+
+func TestFromSentencePieceBPEWithoutUnk(t *testing.T) {
+	tk, err := tokenizers.FromSentencePiece("./test/data/sentencepiece/bpe-no-unk.model", tokenizers.SentencePieceOptions{})
+	require.NoError(t, err)
+	defer tk.Close()
+
+	// characters missing from the vocabulary are dropped without an unknown token
+	ids, tokens := tk.Encode("hez", false)
+	assert.Equal(t, []uint32{3}, ids)
+	assert.Equal(t, []string{"▁he"}, tokens)
+}
+
+func TestFromSentencePieceUnigram(t *testing.T) {
+	data, err := os.ReadFile("./test/data/sentencepiece/t5-unigram.model")
+	require.NoError(t, err)
+	tk, err := tokenizers.FromSentencePieceBytes(data, tokenizers.SentencePieceOptions{
+		AddEOS: true,
+	})
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, uint32(17), tk.VocabSize())
+
+	encoding := tk.EncodeWithOptions("hello world", true, tokenizers.WithReturnAllAttributes())
+	assert.Equal(t, []uint32{5, 6, 1}, encoding.IDs)
+	assert.Equal(t, []string{"▁hello", "▁world", "</s>"}, encoding.Tokens)
+	assert.Equal(t, []uint32{0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, "hello world", tk.Decode(encoding.IDs, true))
+
+	// user defined symbols are matched before normalization and are not special
+	encoding = tk.EncodeWithOptions("hello<sep>world", false, tokenizers.WithReturnAllAttributes())
+	assert.Equal(t, []uint32{5, 3, 6}, encoding.IDs)
+	assert.Equal(t, []string{"▁hello", "<sep>", "▁world"}, encoding.Tokens)
+	assert.Equal(t, []uint32{0, 0, 0}, encoding.SpecialTokensMask)
+
+	// z is not in the vocabulary
+	ids, _ := tk.Encode("hello z", false)
+	assert.Equal(t, []uint32{5, 4, 2}, ids)
+}
+
+func TestFromSentencePiecePrecompiledCharsmap(t *testing.T) {
+	tk, err := tokenizers.FromSentencePiece("./test/data/sentencepiece/nmt-unigram.model", tokenizers.SentencePieceOptions{})
+	require.NoError(t, err)
+	defer tk.Close()
+
+	// the charsmap folds full-width letters and the ideographic space
+	ids, tokens := tk.Encode("ｈｅｌｌｏ\u3000ｗｏｒｌｄ", false)
+	assert.Equal(t, []uint32{5, 6}, ids)
+	assert.Equal(t, []string{"▁hello", "▁world"}, tokens)
+	assert.Equal(t, "hello world", tk.Decode(ids, true))
+	// extra whitespace left by the charsmap is removed
+	ids, _ = tk.Encode("ｈｅｌｌｏ\u3000\u3000world\u3000", false)
+	assert.Equal(t, []uint32{5, 6}, ids)
+	// z is not in the vocabulary
+	ids, _ = tk.Encode("hello ｚ", false)
+	assert.Equal(t, []uint32{5, 4, 2}, ids)
+}
+
+func TestFromSentencePieceErrors(t *testing.T) {
+	// the model has no BOS token
+	_, err := tokenizers.FromSentencePiece("./test/data/sentencepiece/t5-unigram.model", tokenizers.SentencePieceOptions{
+		AddBOS: true,
+	})
+	require.Error(t, err)
+
+	_, err = tokenizers.FromSentencePiece("./test/data/sentencepiece/missing.model", tokenizers.SentencePieceOptions{})
+	require.Error(t, err)
+
+	_, err = tokenizers.FromSentencePieceBytes([]byte{0x0a, 0xff}, tokenizers.SentencePieceOptions{})
+	require.Error(t, err)
+}
@@ -0,0 +1,224 @@
+"""Generates the hand-written SentencePiece models used by sentencepiece_test.go,
+e.g. a model without an unknown piece or with a precompiled charsmap.
+
+The models are written with a minimal protobuf encoder so that no
+sentencepiece or protobuf installation is needed:
+
+    python3 test/data/sentencepiece/generate.py
+"""
+
+import os
+import struct
+
+NORMAL, UNKNOWN, CONTROL, USER_DEFINED, BYTE = 1, 2, 3, 4, 6
+UNIGRAM, BPE = 1, 2
+
+
+def varint(n):
+    if n < 0:
+        n += 1 << 64
+    out = bytearray()
+    while True:
+        b = n & 0x7F
+        n >>= 7
+        if n:
+            out.append(b | 0x80)
+        else:
+            out.append(b)
+            return bytes(out)
+
+
+def key(field, wire_type):
+    return varint(field << 3 | wire_type)
+
+
+def length_delimited(field, data):
+    return key(field, 2) + varint(len(data)) + data
+
+
+def int_field(field, value):
+    return key(field, 0) + varint(value)
+
+
+def piece(text, score, type_):
+    msg = length_delimited(1, text.encode("utf-8"))
+    msg += key(2, 5) + struct.pack("<f", score)
+    if type_ != NORMAL:
+        msg += int_field(3, type_)
+    return length_delimited(1, msg)
+
+
+def field(f, v):
+    return length_delimited(f, v) if isinstance(v, bytes) else int_field(f, v)
+
+
+def model(pieces, trainer, normalizer):
+    data = b"".join(piece(*p) for p in pieces)
+    data += length_delimited(2, b"".join(field(f, v) for f, v in trainer))
+    data += length_delimited(3, b"".join(field(f, v) for f, v in normalizer))
+    return data
+
+
+def precompiled_charsmap(mapping):
+    """Builds a precompiled charsmap: the size of a darts-clone double array
+    of the keys in bytes, the array and the null-terminated replacements the
+    values of the keys point to."""
+    normalized = bytearray()
+    values = {}
+    for key, replacement in sorted(mapping.items()):
+        values[key.encode("utf-8")] = len(normalized)
+        normalized += replacement.encode("utf-8") + b"\0"
+
+    # the trie of the keys, a node maps labels to children, label 0 ends a key
+    root = {}
+    for key, value in values.items():
+        node = root
+        for b in key:
+            node = node.setdefault(b, {})
+        node[0] = value
+
+    units = {}
+    bases = set()
+    queue = [(0, root)]
+    while queue:
+        pos, node = queue.pop(0)
+        labels = sorted(node)
+        base = 1
+        while base in bases or any(base ^ label in units or base ^ label == 0 for label in labels):
+            base += 1
+        bases.add(base)
+        offset = pos ^ base
+        assert offset < 1 << 21
+        units[pos] = units.get(pos, 0) | offset << 10
+        for label in labels:
+            child = base ^ label
+            if label == 0:
+                units[pos] |= 1 << 8
+                units[child] = 1 << 31 | node[0]
+            else:
+                units[child] = label
+                queue.append((child, node[label]))
+    # any byte looked up from a node stays in the array
+    size = (max(max(units), max(bases)) | 0xFF) + 1
+    array = [units.get(i, 0) for i in range(size)]
+    for key, value in values.items():
+        assert common_prefix_search(array, key) == [value]
+    return struct.pack("<I", 4 * size) + struct.pack("<%dI" % size, *array) + bytes(normalized)
+
+
+def common_prefix_search(array, key):
+    """Looks up the key like the spm_precompiled crate of Hugging Face tokenizers."""
+    offset = lambda unit: (unit >> 10) << ((unit & 1 << 9) >> 6)
+    pos = offset(array[0])
+    results = []
+    for b in key:
+        pos ^= b
+        unit = array[pos]
+        if unit & (1 << 31 | 0xFF) != b:
+            return results
+        pos ^= offset(unit)
+        if unit >> 8 & 1:
+            results.append(array[pos] & ((1 << 31) - 1))
+    return results
+
+
+def llama_bpe():
+    """Llama style BPE model with byte fallback, scores are negative merge ranks."""
+    pieces = [("<unk>", 0.0, UNKNOWN), ("<s>", 0.0, CONTROL), ("</s>", 0.0, CONTROL)]
+    pieces += [("<0x%02X>" % b, 0.0, BYTE) for b in range(256)]
+    merged = ["ll", "he", "▁w", "or", "llo", "▁he", "▁hello", "ld", "▁wor", "▁world"]
+    chars = ["▁", "h", "e", "l", "o", "w", "r", "d"]
+    pieces += [(p, -float(i), NORMAL) for i, p in enumerate(merged + chars)]
+    # model_type, byte_fallback, unk_id, bos_id, eos_id, pad_id
+    trainer = [(3, BPE), (35, 1), (40, 0), (41, 1), (42, 2), (43, -1)]
+    # add_dummy_prefix, remove_extra_whitespaces
+    normalizer = [(3, 1), (4, 0)]
+    return model(pieces, trainer, normalizer)
+
+
+def bpe_no_unk():
+    """BPE model without an unknown piece, unknown characters are dropped."""
+    pieces = [("<s>", 0.0, CONTROL), ("</s>", 0.0, CONTROL)]
+    merged = ["he", "▁he"]
+    chars = ["▁", "h", "e"]
+    pieces += [(p, -float(i), NORMAL) for i, p in enumerate(merged + chars)]
+    # model_type, unk_id, bos_id, eos_id, pad_id
+    trainer = [(3, BPE), (40, -1), (41, 0), (42, 1), (43, -1)]
+    # add_dummy_prefix, remove_extra_whitespaces
+    normalizer = [(3, 1), (4, 0)]
+    return model(pieces, trainer, normalizer)
+
+
+def bpe_no_prefix():
+    """The Llama style BPE model without a dummy prefix."""
+    pieces = [("<unk>", 0.0, UNKNOWN), ("<s>", 0.0, CONTROL), ("</s>", 0.0, CONTROL)]
+    pieces += [("<0x%02X>" % b, 0.0, BYTE) for b in range(256)]
+    merged = ["ll", "he", "▁w", "or", "llo", "▁he", "▁hello", "ld", "▁wor", "▁world"]
+    chars = ["▁", "h", "e", "l", "o", "w", "r", "d"]
+    pieces += [(p, -float(i), NORMAL) for i, p in enumerate(merged + chars)]
+    # model_type, byte_fallback, unk_id, bos_id, eos_id, pad_id
+    trainer = [(3, BPE), (35, 1), (40, 0), (41, 1), (42, 2), (43, -1)]
+    # add_dummy_prefix, remove_extra_whitespaces
+    normalizer = [(3, 0), (4, 0)]
+    return model(pieces, trainer, normalizer)
+
+
+def nmt_unigram():
+    """The T5 style Unigram model with a precompiled charsmap folding full-width
+    letters and the ideographic space, like a small part of nmt_nfkc."""
+    mapping = {chr(0xFF41 + i): chr(ord("a") + i) for i in range(26)}
+    mapping["\u3000"] = " "
+    pieces = [
+        ("<pad>", 0.0, CONTROL),
+        ("</s>", 0.0, CONTROL),
+        ("<unk>", 0.0, UNKNOWN),
+        ("<sep>", 0.0, USER_DEFINED),
+        ("▁", -2.0, NORMAL),
+        ("▁hello", -3.0, NORMAL),
+        ("▁world", -3.5, NORMAL),
+        ("hello", -4.0, NORMAL),
+        ("▁he", -5.0, NORMAL),
+        ("llo", -5.0, NORMAL),
+    ]
+    pieces += [(c, -6.0, NORMAL) for c in "helowrd"]
+    # model_type, unk_id, bos_id, eos_id, pad_id
+    trainer = [(3, UNIGRAM), (40, 2), (41, -1), (42, 1), (43, 0)]
+    # name, precompiled_charsmap, add_dummy_prefix, remove_extra_whitespaces
+    normalizer = [(1, b"nmt_nfkc"), (2, precompiled_charsmap(mapping)), (3, 1), (4, 1)]
+    return model(pieces, trainer, normalizer)
+
+
+def t5_unigram():
+    """T5 style Unigram model with a user defined symbol."""
+    pieces = [
+        ("<pad>", 0.0, CONTROL),
+        ("</s>", 0.0, CONTROL),
+        ("<unk>", 0.0, UNKNOWN),
+        ("<sep>", 0.0, USER_DEFINED),
+        ("▁", -2.0, NORMAL),
+        ("▁hello", -3.0, NORMAL),
+        ("▁world", -3.5, NORMAL),
+        ("hello", -4.0, NORMAL),
+        ("▁he", -5.0, NORMAL),
+        ("llo", -5.0, NORMAL),
+    ]
+    pieces += [(c, -6.0, NORMAL) for c in "helowrd"]
+    # model_type, unk_id, bos_id, eos_id, pad_id
+    trainer = [(3, UNIGRAM), (40, 2), (41, -1), (42, 1), (43, 0)]
+    # add_dummy_prefix, remove_extra_whitespaces
+    normalizer = [(3, 1), (4, 1)]
+    return model(pieces, trainer, normalizer)
+
+
+if __name__ == "__main__":
+    here = os.path.dirname(os.path.abspath(__file__))
+    models = [
+        ("llama-bpe.model", llama_bpe()),
+        ("bpe-no-unk.model", bpe_no_unk()),
+        ("bpe-no-prefix.model", bpe_no_prefix()),
+        ("nmt-unigram.model", nmt_unigram()),
+        ("t5-unigram.model", t5_unigram()),
+    ]
+    for name, data in models:
+        with open(os.path.join(here, name), "wb") as f:
+            f.write(data)
@@ -0,0 +1,305 @@
+package tokenizers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Pre-tokenization patterns of well-known tiktoken vocabularies, to be passed to FromTiktoken.
+const (
+	// PatternR50K is the pattern of GPT-2 and r50k_base.
+	PatternR50K = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+	// PatternP50K is the pattern of p50k_base, same as r50k_base.
+	PatternP50K = PatternR50K
+	// PatternCL100K is the pattern of cl100k_base, used by GPT-4 and GPT-3.5.
+	PatternCL100K = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	// PatternO200K is the pattern of o200k_base, used by GPT-4o.
+	PatternO200K = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	// PatternLlama3 is the pattern of Llama 3, same as cl100k_base.
+	PatternLlama3 = PatternCL100K
+	// PatternQwen2 is the pattern of Qwen2, splitting numbers into single digits.
+	PatternQwen2 = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	// PatternKimiK2 is the pattern of Kimi K2, keeping runs of Han characters together.
+	PatternKimiK2 = `[\p{Han}]+|` +
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}&&[^\p{Han}]]*[\p{Ll}\p{Lm}\p{Lo}\p{M}&&[^\p{Han}]]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}&&[^\p{Han}]]+[\p{Ll}\p{Lm}\p{Lo}\p{M}&&[^\p{Han}]]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|` +
+		`\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+)
+
+// tiktokenFingerprint identifies a vocabulary by its number of ranks and its last token.
+type tiktokenFingerprint struct {
+	name      string
+	ranks     int
+	lastToken string // base64 encoded, empty if unknown
+	pattern   string
+}
+
+var tiktokenFingerprints = []tiktokenFingerprint{
+	{name: "r50k_base", ranks: 50256, pattern: PatternR50K},
+	{name: "p50k_base", ranks: 50280, pattern: PatternP50K},
+	{name: "cl100k_base", ranks: 100256, lastToken: "IENvbnZleW9y", pattern: PatternCL100K},
+	{name: "llama3", ranks: 128000, lastToken: "6ZSm", pattern: PatternLlama3},
+	{name: "kimi-k2", ranks: 163584, lastToken: "6Ieq5Li75oCn", pattern: PatternKimiK2},
+	{name: "o200k_base", ranks: 199998, pattern: PatternO200K},
+}
+
+// tiktokenClassPatterns maps tokenizer_class of tokenizer_config.json to the pattern
+// of its implementation, for vocabularies without a known fingerprint.
+var tiktokenClassPatterns = map[string]string{
+	"TikTokenTokenizer": PatternKimiK2,
+}
+
+// DetectTiktokenPattern returns the pre-tokenization pattern for a tiktoken model,
+// recognizing well-known vocabularies by their ranks and falling back to the
+// tokenizer_class of the config. It returns an error if the pattern is unknown.
+func DetectTiktokenPattern(model, config []byte) (string, error) {
+	ranks := 0
+	var lastToken []byte
+	for _, line := range bytes.Split(model, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		ranks++
+		lastToken, _, _ = bytes.Cut(line, []byte(" "))
+	}
+	for _, fp := range tiktokenFingerprints {
+		if fp.ranks == ranks && (fp.lastToken == "" || fp.lastToken == string(lastToken)) {
+			return fp.pattern, nil
+		}
+	}
+
+	var cfg struct {
+		TokenizerClass string `json:"tokenizer_class"`
+	}
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return "", fmt.Errorf("failed to parse tiktoken config: %w", err)
+	}
+	if pattern, ok := tiktokenClassPatterns[cfg.TokenizerClass]; ok {
+		return pattern, nil
+	}
+	return "", fmt.Errorf("unknown tiktoken vocabulary with %d ranks and tokenizer_class %q, use FromTiktoken with an explicit pattern", ranks, cfg.TokenizerClass)
+}
+
+// FromTiktokenAuto creates a tokenizer from tiktoken model and config files like
+// FromTiktoken, detecting the pattern with DetectTiktokenPattern.
+func FromTiktokenAuto(modelPath, configPath string, opts ...TokenizerOption) (*Tokenizer, error) {
+	model, err := os.ReadFile(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken model: %w", err)
+	}
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken config: %w", err)
+	}
+	pattern, err := DetectTiktokenPattern(model, config)
+	if err != nil {
+		return nil, err
+	}
+	return FromTiktokenBytes(model, config, pattern, opts...)
+}
+
+// Names of the OpenAI tiktoken encodings supported by FromTiktokenEncoding.
+const (
+	EncodingR50K     = "r50k_base"
+	EncodingP50K     = "p50k_base"
+	EncodingP50KEdit = "p50k_edit"
+	EncodingCL100K   = "cl100k_base"
+	EncodingO200K    = "o200k_base"
+)
+
+type tiktokenEncoding struct {
+	pattern       string
+	specialTokens map[string]uint32
+}
+
+var tiktokenEncodings = map[string]tiktokenEncoding{
+	EncodingR50K: {
+		pattern:       PatternR50K,
+		specialTokens: map[string]uint32{"<|endoftext|>": 50256},
+	},
+	EncodingP50K: {
+		pattern:       PatternP50K,
+		specialTokens: map[string]uint32{"<|endoftext|>": 50256},
+	},
+	EncodingP50KEdit: {
+		pattern: PatternP50K,
+		specialTokens: map[string]uint32{
+			"<|endoftext|>":  50256,
+			"<|fim_prefix|>": 50281,
+			"<|fim_middle|>": 50282,
+			"<|fim_suffix|>": 50283,
+		},
+	},
+	EncodingCL100K: {
+		pattern: PatternCL100K,
+		specialTokens: map[string]uint32{
+			"<|endoftext|>":   100257,
+			"<|fim_prefix|>":  100258,
+			"<|fim_middle|>":  100259,
+			"<|fim_suffix|>":  100260,
+			"<|endofprompt|>": 100276,
+		},
+	},
+	EncodingO200K: {
+		pattern: PatternO200K,
+		specialTokens: map[string]uint32{
+			"<|endoftext|>":   199999,
+			"<|endofprompt|>": 200018,
+		},
+	},
+}
+
+// FromTiktokenEncoding creates a tokenizer for a named OpenAI encoding, e.g.
+// cl100k_base, from its .tiktoken rank file. The pattern and special tokens
+// of the encoding are built in, so no tokenizer_config.json is needed.
+func FromTiktokenEncoding(name string, rankFile io.Reader, opts ...TokenizerOption) (*Tokenizer, error) {
+	encoding, ok := tiktokenEncodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tiktoken encoding %q", name)
+	}
+	model, err := io.ReadAll(rankFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken rank file: %w", err)
+	}
+	opts = append([]TokenizerOption{WithTiktokenSpecialTokens(encoding.specialTokens)}, opts...)
+	return FromTiktokenBytes(model, nil, encoding.pattern, opts...)
+}
+
+// overlayTiktokenSpecialTokens registers the special tokens in the added_tokens_decoder
+// of a tokenizer_config.json, replacing existing tokens with the same content or ID.
+func overlayTiktokenSpecialTokens(config []byte, specialTokens map[string]uint32) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(config, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse tiktoken config: %w", err)
+	}
+	if doc == nil {
+		doc = map[string]json.RawMessage{}
+	}
+	decoder := map[string]json.RawMessage{}
+	if raw, ok := doc["added_tokens_decoder"]; ok {
+		if err := json.Unmarshal(raw, &decoder); err != nil {
+			return nil, fmt.Errorf("failed to parse added_tokens_decoder: %w", err)
+		}
+		if decoder == nil {
+			decoder = map[string]json.RawMessage{}
+		}
+	}
+	for id, raw := range decoder {
+		var token struct {
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &token); err != nil {
+			return nil, fmt.Errorf("failed to parse added token %s: %w", id, err)
+		}
+		if _, ok := specialTokens[token.Content]; ok {
+			delete(decoder, id)
+		}
+	}
+	for content, id := range specialTokens {
+		raw, err := json.Marshal(addedToken{ID: id, Content: content, Special: true})
+		if err != nil {
+			return nil, err
+		}
+		decoder[strconv.FormatUint(uint64(id), 10)] = raw
+	}
+	raw, err := json.Marshal(decoder)
+	if err != nil {
+		return nil, err
+	}
+	doc["added_tokens_decoder"] = raw
+	return json.Marshal(doc)
+}
+
+// modelEncodings maps OpenAI model names to their encoding.
+var modelEncodings = map[string]string{
+	// chat
+	"gpt-5":             EncodingO200K,
+	"gpt-4.5":           EncodingO200K,
+	"gpt-4.1":           EncodingO200K,
+	"gpt-4o":            EncodingO200K,
+	"chatgpt-4o-latest": EncodingO200K,
+	"o1":                EncodingO200K,
+	"o3":                EncodingO200K,
+	"o4-mini":           EncodingO200K,
+	"gpt-4":             EncodingCL100K,
+	"gpt-3.5-turbo":     EncodingCL100K,
+	"gpt-3.5":           EncodingCL100K,
+	"gpt-35-turbo":      EncodingCL100K,
+	// base
+	"davinci-002": EncodingCL100K,
+	"babbage-002": EncodingCL100K,
+	// embeddings
+	"text-embedding-ada-002": EncodingCL100K,
+	"text-embedding-3-small": EncodingCL100K,
+	"text-embedding-3-large": EncodingCL100K,
+	// legacy
+	"text-davinci-003":      EncodingP50K,
+	"text-davinci-002":      EncodingP50K,
+	"text-davinci-001":      EncodingR50K,
+	"text-curie-001":        EncodingR50K,
+	"text-babbage-001":      EncodingR50K,
+	"text-ada-001":          EncodingR50K,
+	"davinci":               EncodingR50K,
+	"curie":                 EncodingR50K,
+	"babbage":               EncodingR50K,
+	"ada":                   EncodingR50K,
+	"code-davinci-002":      EncodingP50K,
+	"code-davinci-001":      EncodingP50K,
+	"code-cushman-002":      EncodingP50K,
+	"code-cushman-001":      EncodingP50K,
+	"davinci-codex":         EncodingP50K,
+	"cushman-codex":         EncodingP50K,
+	"text-davinci-edit-001": EncodingP50KEdit,
+	"code-davinci-edit-001": EncodingP50KEdit,
+	"gpt2":                  EncodingR50K,
+	"gpt-2":                 EncodingR50K,
+}
+
+// modelPrefixEncodings maps prefixes of versioned and fine-tuned model names to their encoding.
+var modelPrefixEncodings = map[string]string{
+	"gpt-5-":           EncodingO200K,
+	"gpt-4.5-":         EncodingO200K,
+	"gpt-4.1-":         EncodingO200K,
+	"gpt-4o-":          EncodingO200K,
+	"chatgpt-4o-":      EncodingO200K,
+	"o1-":              EncodingO200K,
+	"o3-":              EncodingO200K,
+	"o4-mini-":         EncodingO200K,
+	"gpt-4-":           EncodingCL100K,
+	"gpt-3.5-turbo-":   EncodingCL100K,
+	"gpt-35-turbo-":    EncodingCL100K,
+	"ft:gpt-4o":        EncodingO200K,
+	"ft:gpt-4":         EncodingCL100K,
+	"ft:gpt-3.5-turbo": EncodingCL100K,
+	"ft:davinci-002":   EncodingCL100K,
+	"ft:babbage-002":   EncodingCL100K,
+}
+
+// ModelToEncoding returns the name of the tiktoken encoding used by an OpenAI model,
+// e.g. "o200k_base" for "gpt-4o", to be used with FromTiktokenEncoding.
+func ModelToEncoding(model string) (string, error) {
+	if encoding, ok := modelEncodings[model]; ok {
+		return encoding, nil
+	}
+	// longest prefix wins, e.g. gpt-4o- over gpt-4-
+	prefixes := make([]string, 0, len(modelPrefixEncodings))
+	for prefix := range modelPrefixEncodings {
+		if strings.HasPrefix(model, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return "", fmt.Errorf("no tiktoken encoding known for model %q", model)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return modelPrefixEncodings[prefixes[0]], nil
+}
@@ -0,0 +1,349 @@
+package tokenizers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// byteLevelAlphabet maps every byte to the printable character representing it
+// in byte-level BPE vocabularies, following GPT-2's bytes_to_unicode.
+func byteLevelAlphabet() [256]rune {
+	var alphabet [256]rune
+	n := 0
+	for b := 0; b < 256; b++ {
+		if ('!' <= b && b <= '~') || (0xA1 <= b && b <= 0xAC) || (0xAE <= b && b <= 0xFF) {
+			alphabet[b] = rune(b)
+		} else {
+			alphabet[b] = rune(256 + n)
+			n++
+		}
+	}
+	return alphabet
+}
+
+// byteLevelString returns the byte-level BPE representation of token bytes.
+func byteLevelString(alphabet *[256]rune, token []byte) string {
+	runes := make([]rune, len(token))
+	for i, b := range token {
+		runes[i] = alphabet[b]
+	}
+	return string(runes)
+}
+
+// tiktokenRank is a token of a .tiktoken model file.
+type tiktokenRank struct {
+	token []byte
+	rank  uint32
+}
+
+// readTiktokenRanks parses a .tiktoken model file, returning its tokens ordered by rank.
+func readTiktokenRanks(model []byte) ([]tiktokenRank, error) {
+	var ranks []tiktokenRank
+	for i, line := range bytes.Split(model, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		raw, rawRank, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("invalid tiktoken model at line %d: missing rank", i+1)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tiktoken model at line %d: %w", i+1, err)
+		}
+		rank, err := strconv.ParseUint(string(rawRank), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tiktoken model at line %d: %w", i+1, err)
+		}
+		ranks = append(ranks, tiktokenRank{token: token, rank: uint32(rank)})
+	}
+	sort.SliceStable(ranks, func(i, j int) bool { return ranks[i].rank < ranks[j].rank })
+	return ranks, nil
+}
+
+// tiktokenMerge splits a token into the two parts tiktoken merges last when encoding
+// its bytes, only applying merges ranked below maxRank. It returns false if the
+// token isn't reachable by merging a pair, e.g. it is a single byte.
+func tiktokenMerge(ranks map[string]uint32, token []byte, maxRank uint32) (string, string, bool) {
+	parts := make([]string, len(token))
+	for i, b := range token {
+		parts[i] = string([]byte{b})
+	}
+	for len(parts) > 1 {
+		minIdx, minRank := -1, maxRank
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := ranks[parts[i]+parts[i+1]]; ok && rank < minRank {
+				minIdx, minRank = i, rank
+			}
+		}
+		if minIdx < 0 {
+			break
+		}
+		parts[minIdx] += parts[minIdx+1]
+		parts = append(parts[:minIdx+1], parts[minIdx+2:]...)
+	}
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// tiktokenAddedTokens returns the tokens of the added_tokens_decoder of a
+// tokenizer_config.json as special added tokens, ordered by ID.
+func tiktokenAddedTokens(config []byte) ([]addedToken, error) {
+	if len(bytes.TrimSpace(config)) == 0 {
+		return nil, nil
+	}
+	var cfg struct {
+		AddedTokensDecoder map[string]struct {
+			Content string `json:"content"`
+		} `json:"added_tokens_decoder"`
+	}
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse tiktoken config: %w", err)
+	}
+	tokens := make([]addedToken, 0, len(cfg.AddedTokensDecoder))
+	for id, token := range cfg.AddedTokensDecoder {
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid added token ID %q: %w", id, err)
+		}
+		tokens = append(tokens, addedToken{ID: uint32(n), Content: token.Content, Special: true})
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+// ConvertTiktokenToJSON converts a tiktoken model, its tokenizer_config.json and
+// pre-tokenization pattern into an equivalent byte-level BPE tokenizer.json, to be
+// loaded with FromBytes. Merges are derived from the ranks, and the tokens of
+// added_tokens_decoder become special added tokens. The config may be empty.
+func ConvertTiktokenToJSON(model, config []byte, pattern string) ([]byte, error) {
+	if pattern == "" {
+		return nil, errors.New("tiktoken pattern is required")
+	}
+	ranks, err := readTiktokenRanks(model)
+	if err != nil {
+		return nil, err
+	}
+	addedTokens, err := tiktokenAddedTokens(config)
+	if err != nil {
+		return nil, err
+	}
+
+	rankOf := make(map[string]uint32, len(ranks))
+	for _, r := range ranks {
+		rankOf[string(r.token)] = r.rank
+	}
+	alphabet := byteLevelAlphabet()
+	vocab := make(map[string]uint32, len(ranks))
+	merges := [][2]string{}
+	for _, r := range ranks {
+		vocab[byteLevelString(&alphabet, r.token)] = r.rank
+		if len(r.token) < 2 {
+			continue
+		}
+		if left, right, ok := tiktokenMerge(rankOf, r.token, r.rank); ok {
+			merges = append(merges, [2]string{
+				byteLevelString(&alphabet, []byte(left)),
+				byteLevelString(&alphabet, []byte(right)),
+			})
+		}
+	}
+
+	byteLevel := func(trimOffsets bool) map[string]any {
+		return map[string]any{"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": trimOffsets, "use_regex": false}
+	}
+	doc := map[string]any{
+		"version":      "1.0",
+		"truncation":   nil,
+		"padding":      nil,
+		"added_tokens": addedTokens,
+		"normalizer":   nil,
+		"pre_tokenizer": map[string]any{
+			"type": "Sequence",
+			"pretokenizers": []any{
+				map[string]any{"type": "Split", "pattern": map[string]string{"Regex": pattern}, "behavior": "Isolated", "invert": false},
+				byteLevel(true),
+			},
+		},
+		"model": map[string]any{
+			"type":                      "BPE",
+			"dropout":                   nil,
+			"unk_token":                 nil,
+			"continuing_subword_prefix": nil,
+			"end_of_word_suffix":        nil,
+			"fuse_unk":                  false,
+			"byte_fallback":             false,
+			// tiktoken looks up whole pieces before merging them
+			"ignore_merges": true,
+			"vocab":         vocab,
+			"merges":        merges,
+		},
+		"post_processor": byteLevel(false),
+		"decoder":        byteLevel(true),
+	}
+	return json.Marshal(doc)
+}
+
+// ConvertJSONToTiktoken converts a byte-level BPE tokenizer.json into a tiktoken
+// model, a tokenizer_config.json holding its added tokens, and the pre-tokenization
+// pattern, to be loaded with FromTiktokenBytes. Post-processing, such as adding a
+// BOS token, is not carried over since tiktoken tokenizers don't support it.
+func ConvertJSONToTiktoken(data []byte) (model, config []byte, pattern string, err error) {
+	var doc struct {
+		AddedTokens  []addedToken    `json:"added_tokens"`
+		Normalizer   json.RawMessage `json:"normalizer"`
+		PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+		Padding      *struct {
+			PadToken string `json:"pad_token"`
+		} `json:"padding"`
+		Model struct {
+			Type         string            `json:"type"`
+			Vocab        map[string]uint32 `json:"vocab"`
+			ByteFallback bool              `json:"byte_fallback"`
+		} `json:"model"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to parse tokenizer.json: %w", err)
+	}
+	if doc.Model.Type != "BPE" {
+		return nil, nil, "", fmt.Errorf("unsupported model %q, only BPE can be converted to tiktoken", doc.Model.Type)
+	}
+	if doc.Model.ByteFallback {
+		return nil, nil, "", errors.New("BPE models with byte fallback can't be converted to tiktoken")
+	}
+	if len(doc.Normalizer) > 0 && string(doc.Normalizer) != "null" {
+		return nil, nil, "", errors.New("tokenizers with a normalizer can't be converted to tiktoken")
+	}
+	if pattern, err = byteLevelPattern(doc.PreTokenizer); err != nil {
+		return nil, nil, "", err
+	}
+
+	isAdded := make(map[string]bool, len(doc.AddedTokens))
+	decoder := make(map[string]addedToken, len(doc.AddedTokens))
+	for _, token := range doc.AddedTokens {
+		isAdded[token.Content] = true
+		decoder[strconv.FormatUint(uint64(token.ID), 10)] = token
+	}
+
+	unicodeBytes := make(map[rune]byte, 256)
+	for b, r := range byteLevelAlphabet() {
+		unicodeBytes[r] = byte(b)
+	}
+	ranks := make([]tiktokenRank, 0, len(doc.Model.Vocab))
+	for token, id := range doc.Model.Vocab {
+		if isAdded[token] {
+			continue
+		}
+		raw := make([]byte, 0, len(token))
+		for _, r := range token {
+			b, ok := unicodeBytes[r]
+			if !ok {
+				return nil, nil, "", fmt.Errorf("token %q is not byte-level encoded", token)
+			}
+			raw = append(raw, b)
+		}
+		ranks = append(ranks, tiktokenRank{token: raw, rank: id})
+	}
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i].rank < ranks[j].rank })
+
+	var buf bytes.Buffer
+	for _, r := range ranks {
+		buf.WriteString(base64.StdEncoding.EncodeToString(r.token))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatUint(uint64(r.rank), 10))
+		buf.WriteByte('\n')
+	}
+
+	cfg := map[string]any{"added_tokens_decoder": decoder}
+	if doc.Padding != nil && doc.Padding.PadToken != "" {
+		cfg["pad_token"] = doc.Padding.PadToken
+	}
+	if config, err = json.Marshal(cfg); err != nil {
+		return nil, nil, "", err
+	}
+	return buf.Bytes(), config, pattern, nil
+}
+
+// byteLevelPattern returns the tiktoken pattern equivalent to a byte-level
+// pre-tokenizer: either a regex Split followed by ByteLevel without regex,
+// or a ByteLevel using the GPT-2 regex.
+func byteLevelPattern(preTokenizer json.RawMessage) (string, error) {
+	type component struct {
+		Type           string `json:"type"`
+		AddPrefixSpace bool   `json:"add_prefix_space"`
+		UseRegex       bool   `json:"use_regex"`
+		Pattern        struct {
+			Regex string `json:"Regex"`
+		} `json:"pattern"`
+		Behavior      string      `json:"behavior"`
+		Invert        bool        `json:"invert"`
+		PreTokenizers []component `json:"pretokenizers"`
+	}
+	var pre component
+	if err := json.Unmarshal(preTokenizer, &pre); err != nil {
+		return "", fmt.Errorf("failed to parse pre_tokenizer: %w", err)
+	}
+	steps := []component{pre}
+	if pre.Type == "Sequence" {
+		steps = pre.PreTokenizers
+	}
+
+	switch {
+	case len(steps) == 1 && steps[0].Type == "ByteLevel" && steps[0].UseRegex && !steps[0].AddPrefixSpace:
+		return PatternR50K, nil
+	case len(steps) == 2 && steps[0].Type == "Split" && steps[0].Pattern.Regex != "" &&
+		steps[0].Behavior == "Isolated" && !steps[0].Invert &&
+		steps[1].Type == "ByteLevel" && !steps[1].UseRegex && !steps[1].AddPrefixSpace:
+		return steps[0].Pattern.Regex, nil
+	}
+	return "", errors.New("unsupported pre_tokenizer, expected a byte-level pre-tokenizer without prefix space")
+}
+
+// EncodingMismatch is a line of a corpus encoded differently by two tokenizers.
+type EncodingMismatch struct {
+	Line     int // 1-based
+	Text     string
+	Expected []uint32
+	Actual   []uint32
+}
+
+// CompareTokenizers encodes every line of corpus, including its line break, with
+// both tokenizers and reports the lines whose token IDs differ, e.g. to verify
+// ConvertTiktokenToJSON or ConvertJSONToTiktoken. Special tokens are parsed.
+func CompareTokenizers(expected, actual *Tokenizer, corpus io.Reader) ([]EncodingMismatch, error) {
+	var mismatches []EncodingMismatch
+	rd := bufio.NewReader(corpus)
+	for line := 1; ; line++ {
+		text, err := rd.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read corpus: %w", err)
+		}
+		if text != "" {
+			want, _, encErr := expected.EncodeErr(text, true)
+			if encErr != nil {
+				return nil, fmt.Errorf("line %d: %w", line, encErr)
+			}
+			got, _, encErr := actual.EncodeErr(text, true)
+			if encErr != nil {
+				return nil, fmt.Errorf("line %d: %w", line, encErr)
+			}
+			if !slices.Equal(want, got) {
+				mismatches = append(mismatches, EncodingMismatch{Line: line, Text: text, Expected: want, Actual: got})
+			}
+		}
+		if err == io.EOF {
+			return mismatches, nil
+		}
+	}
+}
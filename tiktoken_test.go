@@ -0,0 +1,261 @@
+package tokenizers_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectTiktokenPattern(t *testing.T) {
+	kimiModel, err := os.ReadFile("./test/data/kimi-k2-instruct/tiktoken.model")
+	require.NoError(t, err)
+	kimiConfig, err := os.ReadFile("./test/data/kimi-k2-instruct/tokenizer_config.json")
+	require.NoError(t, err)
+	llamaModel, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tiktoken.model")
+	require.NoError(t, err)
+	llamaConfig, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tokenizer_config.json")
+	require.NoError(t, err)
+	// the first ranks of Llama 3 are the cl100k_base vocabulary
+	cl100kModel := llamaModel[:indexOfLine(t, llamaModel, 100256)]
+
+	tests := []struct {
+		name    string
+		model   []byte
+		config  []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "kimi-k2", model: kimiModel, config: kimiConfig, want: tokenizers.PatternKimiK2},
+		{name: "llama3", model: llamaModel, config: llamaConfig, want: tokenizers.PatternLlama3},
+		{name: "cl100k_base", model: cl100kModel, config: []byte(`{}`), want: tokenizers.PatternCL100K},
+		// unknown vocabulary, recognized by tokenizer_class
+		{name: "kimi tokenizer class", model: cl100kModel[:1000], config: kimiConfig, want: tokenizers.PatternKimiK2},
+		{name: "unknown", model: cl100kModel[:1000], config: llamaConfig, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := tokenizers.DetectTiktokenPattern(tt.model, tt.config)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, pattern)
+		})
+	}
+}
+
+// indexOfLine returns the byte offset at which the given 0-based line starts.
+func indexOfLine(t *testing.T, data []byte, line int) int {
+	t.Helper()
+	for i, b := range data {
+		if line == 0 {
+			return i
+		}
+		if b == '\n' {
+			line--
+		}
+	}
+	t.Fatalf("data has less than %d lines", line)
+	return 0
+}
+
+func TestFromTiktokenAuto(t *testing.T) {
+	tk, err := tokenizers.FromTiktokenAuto(
+		"./test/data/kimi-k2-instruct/tiktoken.model",
+		"./test/data/kimi-k2-instruct/tokenizer_config.json",
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+
+	ids, _ := tk.Encode("Hello, world! 你好，世界！", false)
+	assert.Equal(t, []uint32{19180, 11, 2695, 0, 220, 33845, 378, 2243, 856}, ids)
+
+	_, err = tokenizers.FromTiktokenAuto(
+		"./test/data/kimi-k2-instruct/tiktoken.model",
+		"./test/data/kimi-k2-instruct/missing.json",
+	)
+	require.Error(t, err)
+}
+
+func TestFromTiktokenEncoding(t *testing.T) {
+	llamaModel, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tiktoken.model")
+	require.NoError(t, err)
+	llama, err := tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer llama.Close()
+
+	// the first ranks of Llama 3 are the cl100k_base vocabulary
+	rankFile := bytes.NewReader(llamaModel[:indexOfLine(t, llamaModel, 100256)])
+	tk, err := tokenizers.FromTiktokenEncoding(tokenizers.EncodingCL100K, rankFile)
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, uint32(100277), tk.VocabSize())
+
+	text := "brown fox jumps over the lazy dog"
+	want, _ := llama.Encode(text, false)
+	ids, _ := tk.Encode(text, false)
+	assert.Equal(t, want, ids)
+
+	ids, _ = tk.Encode(text+"<|endoftext|>", true)
+	assert.Equal(t, append(want, 100257), ids)
+	assert.Equal(t, text, tk.Decode(ids, true))
+
+	_, err = tokenizers.FromTiktokenEncoding("unknown_base", bytes.NewReader(llamaModel))
+	require.Error(t, err)
+}
+
+func TestFromTiktokenEncodingEndOfText(t *testing.T) {
+	llamaModel, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tiktoken.model")
+	require.NoError(t, err)
+	// rank files shaped like r50k_base and p50k_base: <|endoftext|> is rank 50256,
+	// after the base vocabulary of r50k_base and in the middle of p50k_base
+	tests := []struct {
+		encoding  string
+		lastRank  int
+		vocabSize uint32
+	}{
+		{encoding: tokenizers.EncodingR50K, lastRank: 50255, vocabSize: 50257},
+		{encoding: tokenizers.EncodingP50K, lastRank: 50280, vocabSize: 50281},
+		{encoding: tokenizers.EncodingP50KEdit, lastRank: 50280, vocabSize: 50284},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			rankFile := tiktokenRanks(t, llamaModel, func(rank int) bool { return rank != 50256 && rank <= tt.lastRank })
+			tk, err := tokenizers.FromTiktokenEncoding(tt.encoding, bytes.NewReader(rankFile))
+			require.NoError(t, err)
+			defer tk.Close()
+			assert.Equal(t, tt.vocabSize, tk.VocabSize())
+
+			text := "brown fox jumps over the lazy dog"
+			want, _ := tk.Encode(text, false)
+			ids, tokens := tk.Encode(text+"<|endoftext|>", true)
+			assert.Equal(t, append(want, 50256), ids)
+			assert.Equal(t, "<|endoftext|>", tokens[len(tokens)-1])
+			assert.Equal(t, "<|endoftext|>", tk.Decode([]uint32{50256}, false))
+			assert.Equal(t, text+"<|endoftext|>", tk.Decode(ids, false))
+			assert.Equal(t, text, tk.Decode(ids, true))
+		})
+	}
+}
+
+// tiktokenRanks returns the lines of a tiktoken rank file whose ranks are kept.
+func tiktokenRanks(t *testing.T, model []byte, keep func(rank int) bool) []byte {
+	t.Helper()
+	var out []byte
+	for _, line := range bytes.SplitAfter(model, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			continue
+		}
+		rank, err := strconv.Atoi(fields[1])
+		require.NoError(t, err)
+		if keep(rank) {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+func TestModelToEncoding(t *testing.T) {
+	tests := []struct {
+		model   string
+		want    string
+		wantErr bool
+	}{
+		{model: "gpt-4o", want: tokenizers.EncodingO200K},
+		{model: "gpt-4o-2024-08-06", want: tokenizers.EncodingO200K},
+		{model: "ft:gpt-4o-mini:org::id", want: tokenizers.EncodingO200K},
+		{model: "gpt-4", want: tokenizers.EncodingCL100K},
+		{model: "gpt-4-0613", want: tokenizers.EncodingCL100K},
+		{model: "gpt-3.5-turbo-16k", want: tokenizers.EncodingCL100K},
+		{model: "text-embedding-3-small", want: tokenizers.EncodingCL100K},
+		{model: "text-davinci-003", want: tokenizers.EncodingP50K},
+		{model: "text-davinci-edit-001", want: tokenizers.EncodingP50KEdit},
+		{model: "davinci", want: tokenizers.EncodingR50K},
+		{model: "llama-3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			encoding, err := tokenizers.ModelToEncoding(tt.model)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, encoding)
+		})
+	}
+}
+
+func TestConvertTiktokenToJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		pattern string
+		text    string
+	}{
+		{name: "llama3", dir: "./test/data/meta-llama-3-8b-instruct", pattern: tokenizers.PatternLlama3, text: "<|begin_of_text|>hi world<|eot_id|>"},
+		{name: "kimi-k2", dir: "./test/data/kimi-k2-instruct", pattern: tokenizers.PatternKimiK2, text: "Hello, world! 你好，世界！<|im_middle|>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := os.ReadFile(tt.dir + "/tiktoken.model")
+			require.NoError(t, err)
+			config, err := os.ReadFile(tt.dir + "/tokenizer_config.json")
+			require.NoError(t, err)
+			tiktoken, err := tokenizers.FromTiktokenBytes(model, config, tt.pattern)
+			require.NoError(t, err)
+			defer tiktoken.Close()
+
+			data, err := tokenizers.ConvertTiktokenToJSON(model, config, tt.pattern)
+			require.NoError(t, err)
+			hf, err := tokenizers.FromBytes(data)
+			require.NoError(t, err)
+			defer hf.Close()
+			assertSameEncodings(t, tiktoken, hf, tt.text)
+
+			// and back to tiktoken
+			model, config, pattern, err := tokenizers.ConvertJSONToTiktoken(data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.pattern, pattern)
+			roundTrip, err := tokenizers.FromTiktokenBytes(model, config, pattern)
+			require.NoError(t, err)
+			defer roundTrip.Close()
+			assertSameEncodings(t, tiktoken, roundTrip, tt.text)
+		})
+	}
+}
+
+func TestConvertJSONToTiktoken(t *testing.T) {
+	_, err := tokenizers.ConvertTiktokenToJSON([]byte("aGk= 0\n"), nil, "")
+	require.Error(t, err, "pattern is required")
+
+	data, err := os.ReadFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	_, _, _, err = tokenizers.ConvertJSONToTiktoken(data)
+	require.Error(t, err, "WordPiece can't be converted")
+}
+
+// assertSameEncodings checks that both tokenizers encode long_text.txt and text alike.
+func assertSameEncodings(t *testing.T, expected, actual *tokenizers.Tokenizer, text string) {
+	t.Helper()
+	corpus, err := os.Open("./test/data/long_text.txt")
+	require.NoError(t, err)
+	defer corpus.Close()
+	mismatches, err := tokenizers.CompareTokenizers(expected, actual, io.MultiReader(corpus, strings.NewReader(text)))
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+}
@@ -21,44 +21,217 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
-	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
-	"time"
+	"unicode/utf8"
 	"unsafe"
 )
 
-const baseURL = "https://huggingface.co"
-const defaultHTTPTimeout = 30 * time.Second
-
-var hfHTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
 var ErrTokenizerClosed = errors.New("tokenizer is nil or closed")
 
-// List of necessary tokenizer files and their mandatory status.
-// True means mandatory, false means optional.
-var tokenizerFiles = map[string]bool{
-	"tokenizer.json":          true,
-	"vocab.txt":               false,
-	"merges.txt":              false,
-	"special_tokens_map.json": false,
-	"added_tokens.json":       false,
-}
+// ErrInvalidUTF8 is returned when encoding text that is not valid UTF-8 with WithStrictUTF8.
+var ErrInvalidUTF8 = errors.New("text is not valid UTF-8")
 
 type Tokenizer struct {
-	tokenizer unsafe.Pointer
+	tokenizer    unsafe.Pointer
+	strictUTF8   bool
+	capabilities Capabilities
+}
+
+// Capabilities are the tokenizer options a tokenizer supports, which depend on the
+// format it was created from. Constructors return an error for unsupported options.
+type Capabilities struct {
+	// Tiktoken is set for tokenizers created from tiktoken and tekken files.
+	Tiktoken bool
+	// EncodeSpecialTokens reports support of WithEncodeSpecialTokens.
+	EncodeSpecialTokens bool
+	// Truncation and Padding report support of WithTruncation and WithPadding.
+	Truncation bool
+	Padding    bool
+	// AddedTokens reports support of WithAddedTokens and WithAddedSpecialTokens.
+	AddedTokens bool
+	// TiktokenSpecialTokens reports support of WithTiktokenSpecialTokens and
+	// WithTiktokenReservedTokenFormat.
+	TiktokenSpecialTokens bool
+}
+
+var (
+	huggingFaceCapabilities = Capabilities{
+		EncodeSpecialTokens: true,
+		Truncation:          true,
+		Padding:             true,
+		AddedTokens:         true,
+	}
+	tiktokenCapabilities = Capabilities{
+		Tiktoken:              true,
+		EncodeSpecialTokens:   true,
+		Truncation:            true,
+		Padding:               true,
+		TiktokenSpecialTokens: true,
+	}
+	// tekken files list their special tokens
+	tekkenCapabilities = Capabilities{
+		Tiktoken:            true,
+		EncodeSpecialTokens: true,
+		Truncation:          true,
+		Padding:             true,
+	}
+)
+
+// Capabilities returns the tokenizer options supported by the tokenizer.
+func (t *Tokenizer) Capabilities() Capabilities {
+	return t.capabilities
 }
 
 type tokenizerOpts struct {
-	encodeSpecialTokens C.bool
+	encodeSpecialTokens bool
+
+	// tiktoken only
+	tiktokenSpecialTokens map[string]uint32
+	reservedTokenFormat   string
+
+	truncation          bool
+	maxLength           uint32
+	truncationDirection TruncationDirection
+	stride              uint32
+
+	padding          bool
+	padLength        uint32
+	paddingDirection PaddingDirection
+	padToMultipleOf  uint32
+	padToken         string
+
+	// Hugging Face only
+	addedTokens        []string
+	addedSpecialTokens []string
+
+	strictUTF8 bool
+
+	// FromPretrained only
+	cacheDir  *string
+	authToken *string
+}
+
+// newTokenizerOpts applies the options passed to a constructor.
+func newTokenizerOpts(opts []TokenizerOption) *tokenizerOpts {
+	o := &tokenizerOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// validate rejects options that are not supported by the kind of tokenizer
+// being created. Download options are only accepted by FromPretrained.
+func (o *tokenizerOpts) validate(caps Capabilities) error {
+	if o.cacheDir != nil || o.authToken != nil {
+		return fmt.Errorf("cache dir and auth token are only supported by FromPretrained")
+	}
+	if o.encodeSpecialTokens && !caps.EncodeSpecialTokens {
+		return fmt.Errorf("encode special tokens is not supported by this tokenizer")
+	}
+	if o.truncation && !caps.Truncation {
+		return fmt.Errorf("truncation is not supported by this tokenizer")
+	}
+	if o.padding && !caps.Padding {
+		return fmt.Errorf("padding is not supported by this tokenizer")
+	}
+	if (o.addedTokens != nil || o.addedSpecialTokens != nil) && !caps.AddedTokens {
+		return fmt.Errorf("added tokens are not supported by tiktoken tokenizers, use WithTiktokenSpecialTokens")
+	}
+	if (o.tiktokenSpecialTokens != nil || o.reservedTokenFormat != "") && !caps.TiktokenSpecialTokens {
+		if caps.Tiktoken {
+			return fmt.Errorf("tiktoken special tokens and reserved token format are not supported by tekken tokenizers")
+		}
+		return fmt.Errorf("tiktoken options are not supported by Hugging Face tokenizers")
+	}
+	return nil
+}
+
+// cOptions converts the options for the C API, call free to release them.
+func (o *tokenizerOpts) cOptions() (opts C.struct_tokenizers_options, free func(), err error) {
+	if o.stride > 0 && !o.truncation {
+		return opts, nil, fmt.Errorf("truncation stride requires WithTruncation")
+	}
+	if (o.padToMultipleOf > 0 || o.padToken != "") && !o.padding {
+		return opts, nil, fmt.Errorf("pad to multiple of and pad token require WithPadding")
+	}
+	var cStrings []*C.char
+	cString := func(s string) *C.char {
+		cs := C.CString(s)
+		cStrings = append(cStrings, cs)
+		return cs
+	}
+	addedTokens, freeAddedTokens := cStringArray(o.addedTokens)
+	addedSpecialTokens, freeAddedSpecialTokens := cStringArray(o.addedSpecialTokens)
+	opts = C.struct_tokenizers_options{
+		encode_special_tokens: C.bool(o.encodeSpecialTokens),
+		truncation:            C.bool(o.truncation),
+		max_length:            C.size_t(o.maxLength),
+		truncation_direction:  C.uint8_t(o.truncationDirection),
+		stride:                C.size_t(o.stride),
+		padding:               C.bool(o.padding),
+		pad_length:            C.size_t(o.padLength),
+		padding_direction:     C.uint8_t(o.paddingDirection),
+		pad_to_multiple_of:    C.size_t(o.padToMultipleOf),
+
+		added_tokens:             addedTokens,
+		added_tokens_len:         C.size_t(len(o.addedTokens)),
+		added_special_tokens:     addedSpecialTokens,
+		added_special_tokens_len: C.size_t(len(o.addedSpecialTokens)),
+	}
+	if o.reservedTokenFormat != "" {
+		opts.reserved_token_format = cString(o.reservedTokenFormat)
+	}
+	if o.padToken != "" {
+		opts.pad_token = cString(o.padToken)
+	}
+	return opts, func() {
+		for _, cs := range cStrings {
+			C.free(unsafe.Pointer(cs))
+		}
+		freeAddedTokens()
+		freeAddedSpecialTokens()
+	}, nil
 }
 
+// TokenizerOption configures a tokenizer, it is accepted by every constructor.
+// Constructors return an error for options the kind of tokenizer doesn't support.
 type TokenizerOption func(to *tokenizerOpts)
 
+// TokenizerConfigOption is the former name of the options of FromPretrained.
+//
+// Deprecated: Use TokenizerOption.
+type TokenizerConfigOption = TokenizerOption
+
+// WithEncodeSpecialTokens encodes special tokens found in the text as ordinary text
+// instead of parsing them, for both Hugging Face and tiktoken tokenizers.
 func WithEncodeSpecialTokens() TokenizerOption {
 	return func(to *tokenizerOpts) {
-		to.encodeSpecialTokens = C.bool(true)
+		to.encodeSpecialTokens = true
+	}
+}
+
+// WithTiktokenSpecialTokens registers special tokens of a tiktoken tokenizer,
+// replacing tokens of tokenizer_config.json with the same content or ID.
+func WithTiktokenSpecialTokens(specialTokens map[string]uint32) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		if to.tiktokenSpecialTokens == nil {
+			to.tiktokenSpecialTokens = make(map[string]uint32, len(specialTokens))
+		}
+		for content, id := range specialTokens {
+			to.tiktokenSpecialTokens[content] = id
+		}
+	}
+}
+
+// WithTiktokenReservedTokenFormat sets the name of the tokens filling missing ranks
+// of a tiktoken vocabulary, "%d" is replaced by the index of the reserved token.
+// Defaults to "<|reserved_special_token_%d|>".
+func WithTiktokenReservedTokenFormat(format string) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.reservedTokenFormat = format
 	}
 }
 
@@ -69,23 +242,102 @@ const (
 	TruncationDirectionRight
 )
 
+// WithTruncation truncates encodings to maxLen tokens, removing tokens from the given side.
+// Removed tokens are returned in Encoding.Overflowing.
+func WithTruncation(maxLen uint32, dir TruncationDirection) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.truncation = true
+		to.maxLength = maxLen
+		to.truncationDirection = dir
+	}
+}
+
+// WithTruncationStride sets the number of tokens overflowing encodings share with
+// the previous part of the input. Requires WithTruncation with a larger maxLen.
+func WithTruncationStride(stride uint32) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.stride = stride
+	}
+}
+
+type PaddingDirection int
+
+const (
+	PaddingDirectionLeft PaddingDirection = iota
+	PaddingDirectionRight
+)
+
+// WithPadding pads encodings to length tokens on the given side, 0 pads to the longest
+// encoding of a batch. The pad token defaults to the one configured in tokenizer.json,
+// or pad_token of tokenizer_config.json for tiktoken tokenizers, see WithPadToken.
+func WithPadding(length uint32, dir PaddingDirection) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.padding = true
+		to.padLength = length
+		to.paddingDirection = dir
+	}
+}
+
+// WithPadToMultipleOf rounds the padded length up to a multiple of n. Requires WithPadding.
+func WithPadToMultipleOf(n uint32) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.padToMultipleOf = n
+	}
+}
+
+// WithPadToken sets the token used for padding, it must be in the vocabulary. Requires WithPadding.
+func WithPadToken(token string) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.padToken = token
+	}
+}
+
+// WithAddedTokens adds tokens to the vocabulary of a Hugging Face tokenizer,
+// like add_tokens of transformers. Tokens already in the vocabulary are skipped.
+func WithAddedTokens(tokens ...string) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.addedTokens = append(to.addedTokens, tokens...)
+	}
+}
+
+// WithAddedSpecialTokens adds special tokens to the vocabulary of a Hugging Face
+// tokenizer, like add_special_tokens of transformers.
+func WithAddedSpecialTokens(tokens ...string) TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.addedSpecialTokens = append(to.addedSpecialTokens, tokens...)
+	}
+}
+
+// WithStrictUTF8 fails encoding of text that is not valid UTF-8 with ErrInvalidUTF8,
+// instead of replacing invalid bytes with U+FFFD.
+func WithStrictUTF8() TokenizerOption {
+	return func(to *tokenizerOpts) {
+		to.strictUTF8 = true
+	}
+}
+
 var _ io.Closer = (*Tokenizer)(nil)
 
+// FromBytes creates a tokenizer from the contents of a tokenizer.json file.
 func FromBytes(data []byte, opts ...TokenizerOption) (*Tokenizer, error) {
+	return fromBytes(data, newTokenizerOpts(opts))
+}
+
+func fromBytes(data []byte, o *tokenizerOpts) (*Tokenizer, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("tokenizer data cannot be empty")
 	}
-
-	allOpts := &tokenizerOpts{
-		// by default, we do not encode special tokens
-		encodeSpecialTokens: C.bool(false),
+	if err := o.validate(huggingFaceCapabilities); err != nil {
+		return nil, err
 	}
-	for _, opt := range opts {
-		opt(allOpts)
+	cOpts, free, err := o.cOptions()
+	if err != nil {
+		return nil, err
 	}
+	defer free()
 
 	var errPtr *C.char
-	tokenizer := C.tokenizers_from_bytes((*C.uchar)(unsafe.Pointer(&data[0])), C.uint(len(data)), (*C.struct_tokenizers_options)(unsafe.Pointer(allOpts)), &errPtr)
+	tokenizer := C.tokenizers_from_bytes((*C.uchar)(unsafe.Pointer(&data[0])), C.uint(len(data)), &cOpts, &errPtr)
 	if tokenizer == nil {
 		if errPtr != nil {
 			errStr := C.GoString(errPtr)
@@ -95,59 +347,142 @@ func FromBytes(data []byte, opts ...TokenizerOption) (*Tokenizer, error) {
 		return nil, fmt.Errorf("failed to create tokenizer from bytes")
 	}
 
-	return &Tokenizer{tokenizer: tokenizer}, nil
+	return &Tokenizer{tokenizer: tokenizer, strictUTF8: o.strictUTF8, capabilities: huggingFaceCapabilities}, nil
 }
 
+// FromBytesWithTruncation is FromBytes with WithTruncation.
 func FromBytesWithTruncation(data []byte, maxLen uint32, dir TruncationDirection) (*Tokenizer, error) {
-	if len(data) == 0 {
-		return nil, fmt.Errorf("tokenizer data cannot be empty")
+	return FromBytes(data, WithTruncation(maxLen, dir))
+}
+
+// FromFile creates a tokenizer from a tokenizer.json file.
+func FromFile(path string, opts ...TokenizerOption) (*Tokenizer, error) {
+	o := newTokenizerOpts(opts)
+	if err := o.validate(huggingFaceCapabilities); err != nil {
+		return nil, err
+	}
+	cOpts, free, err := o.cOptions()
+	if err != nil {
+		return nil, err
 	}
+	defer free()
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
 
 	var errPtr *C.char
-	tokenizer := C.tokenizers_from_bytes_with_truncation((*C.uchar)(unsafe.Pointer(&data[0])), C.uint(len(data)), C.size_t(maxLen), C.uchar(dir), &errPtr)
+	tokenizer := C.tokenizers_from_file(cPath, &cOpts, &errPtr)
 	if tokenizer == nil {
 		if errPtr != nil {
 			errStr := C.GoString(errPtr)
 			C.tokenizers_free_string(errPtr)
 			return nil, fmt.Errorf("%s", errStr)
 		}
-		return nil, fmt.Errorf("failed to create tokenizer with truncation")
+		return nil, fmt.Errorf("failed to create tokenizer from file")
 	}
 
-	return &Tokenizer{tokenizer: tokenizer}, nil
+	return &Tokenizer{tokenizer: tokenizer, strictUTF8: o.strictUTF8, capabilities: huggingFaceCapabilities}, nil
 }
 
-func FromFile(path string) (*Tokenizer, error) {
-	cPath := C.CString(path)
-	defer C.free(unsafe.Pointer(cPath))
+// FromTiktoken creates a tokenizer from tiktoken model and config files
+func FromTiktoken(modelPath, configPath, pattern string, opts ...TokenizerOption) (*Tokenizer, error) {
+	model, err := os.ReadFile(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken model: %w", err)
+	}
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken config: %w", err)
+	}
+	return FromTiktokenBytes(model, config, pattern, opts...)
+}
+
+// FromTiktokenBytes creates a tokenizer from the contents of tiktoken model
+// and config files, e.g. embedded with embed.FS. The config may be empty if
+// special tokens are provided with WithTiktokenSpecialTokens.
+func FromTiktokenBytes(model, config []byte, pattern string, opts ...TokenizerOption) (*Tokenizer, error) {
+	if len(model) == 0 {
+		return nil, fmt.Errorf("tiktoken model data cannot be empty")
+	}
+
+	allOpts := newTokenizerOpts(opts)
+	if err := allOpts.validate(tiktokenCapabilities); err != nil {
+		return nil, err
+	}
+
+	if len(config) == 0 {
+		config = []byte("{}")
+	}
+	if allOpts.tiktokenSpecialTokens != nil {
+		var err error
+		if config, err = overlayTiktokenSpecialTokens(config, allOpts.tiktokenSpecialTokens); err != nil {
+			return nil, err
+		}
+	}
+
+	if allOpts.reservedTokenFormat != "" && strings.Count(allOpts.reservedTokenFormat, "%d") != 1 {
+		return nil, fmt.Errorf("reserved token format %q must contain %%d exactly once", allOpts.reservedTokenFormat)
+	}
+	cOpts, free, err := allOpts.cOptions()
+	if err != nil {
+		return nil, err
+	}
+	defer free()
+
+	cPattern := C.CString(pattern)
+	defer C.free(unsafe.Pointer(cPattern))
 
 	var errPtr *C.char
-	tokenizer := C.tokenizers_from_file(cPath, &errPtr)
+	tokenizer := C.tokenizers_from_tiktoken_bytes(
+		(*C.uchar)(unsafe.Pointer(&model[0])), C.uint(len(model)),
+		(*C.uchar)(unsafe.Pointer(&config[0])), C.uint(len(config)),
+		cPattern, &cOpts, &errPtr)
+
 	if tokenizer == nil {
 		if errPtr != nil {
 			errStr := C.GoString(errPtr)
 			C.tokenizers_free_string(errPtr)
 			return nil, fmt.Errorf("%s", errStr)
 		}
-		return nil, fmt.Errorf("failed to create tokenizer from file")
+		return nil, fmt.Errorf("failed to create tiktoken tokenizer from bytes")
 	}
 
-	return &Tokenizer{tokenizer: tokenizer}, nil
+	return &Tokenizer{tokenizer: tokenizer, strictUTF8: allOpts.strictUTF8, capabilities: tiktokenCapabilities}, nil
 }
 
-// FromTiktoken creates a tokenizer from tiktoken model and config files
-func FromTiktoken(modelPath, configPath, pattern string) (*Tokenizer, error) {
-	cModelPath := C.CString(modelPath)
-	defer C.free(unsafe.Pointer(cModelPath))
+// FromTiktokenReader creates a tokenizer by reading tiktoken model and config
+// data from the given readers, see FromTiktokenBytes.
+func FromTiktokenReader(model, config io.Reader, pattern string, opts ...TokenizerOption) (*Tokenizer, error) {
+	modelData, err := io.ReadAll(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken model: %w", err)
+	}
+	configData, err := io.ReadAll(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiktoken config: %w", err)
+	}
+	return FromTiktokenBytes(modelData, configData, pattern, opts...)
+}
 
-	cConfigPath := C.CString(configPath)
-	defer C.free(unsafe.Pointer(cConfigPath))
+// FromTekken creates a tokenizer from Mistral's tekken.json file.
+// Special tokens occupy the first IDs of the vocabulary, control tokens
+// among them are skipped when decoding with skipSpecialTokens.
+func FromTekken(path string, opts ...TokenizerOption) (*Tokenizer, error) {
+	o := newTokenizerOpts(opts)
+	if err := o.validate(tekkenCapabilities); err != nil {
+		return nil, err
+	}
+	cOpts, free, err := o.cOptions()
+	if err != nil {
+		return nil, err
+	}
+	defer free()
 
-	cPattern := C.CString(pattern)
-	defer C.free(unsafe.Pointer(cPattern))
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
 
 	var errPtr *C.char
-	tokenizer := C.tokenizers_from_tiktoken(cModelPath, cConfigPath, cPattern, &errPtr)
+	tokenizer := C.tokenizers_from_tekken(cPath, &cOpts, &errPtr)
 
 	if tokenizer == nil {
 		if errPtr != nil {
@@ -155,207 +490,289 @@ func FromTiktoken(modelPath, configPath, pattern string) (*Tokenizer, error) {
 			C.tokenizers_free_string(errPtr)
 			return nil, fmt.Errorf("%s", errStr)
 		}
-		return nil, fmt.Errorf("failed to create tiktoken tokenizer")
+		return nil, fmt.Errorf("failed to create tekken tokenizer")
 	}
 
-	return &Tokenizer{tokenizer: tokenizer}, nil
+	return &Tokenizer{tokenizer: tokenizer, strictUTF8: o.strictUTF8, capabilities: tekkenCapabilities}, nil
 }
 
-type tokenizerConfig struct {
-	cacheDir  *string
-	authToken *string
+func (t *Tokenizer) Close() error {
+	C.tokenizers_free_tokenizer(t.tokenizer)
+	t.tokenizer = nil
+	return nil
 }
 
-type TokenizerConfigOption func(cfg *tokenizerConfig)
+type Offset [2]uint
+
+type Encoding struct {
+	IDs               []uint32
+	TypeIDs           []uint32
+	SpecialTokensMask []uint32
+	AttentionMask     []uint32
+	Tokens            []string
+	Offsets           []Offset
+	// WordIDs holds the index of the word of every token, -1 for tokens
+	// without a word, e.g. special tokens added by the post-processor.
+	WordIDs []int
+	// SequenceIDs holds the index of the input sequence of every token,
+	// -1 for tokens without a sequence.
+	SequenceIDs []int
+	// Overflowing holds the parts of the input removed by WithTruncation
+	Overflowing []Encoding
+}
 
-func WithCacheDir(path string) TokenizerConfigOption {
-	return func(cfg *tokenizerConfig) {
-		cfg.cacheDir = &path
+// WordToTokens returns the range [start, end) of tokens of a word of the first
+// sequence, it returns false if no token belongs to the word. Requires WithReturnWordIDs.
+func (e Encoding) WordToTokens(word int) (start, end int, ok bool) {
+	start, end = -1, -1
+	for i, w := range e.WordIDs {
+		if w != word || (e.SequenceIDs != nil && e.SequenceIDs[i] > 0) {
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		end = i + 1
 	}
+	return start, end, start >= 0
 }
 
-func WithAuthToken(token string) TokenizerConfigOption {
-	return func(cfg *tokenizerConfig) {
-		cfg.authToken = &token
+// TokenToWord returns the word of the token at index i, or -1 if the token has
+// no word or i is out of range. Requires WithReturnWordIDs.
+func (e Encoding) TokenToWord(i int) int {
+	if i < 0 || i >= len(e.WordIDs) {
+		return -1
 	}
+	return e.WordIDs[i]
 }
 
-func normalizeModelID(modelID string) (string, error) {
-	modelID = strings.TrimSpace(modelID)
-	if modelID == "" {
-		return "", fmt.Errorf("modelID cannot be empty")
-	}
-	if strings.ContainsRune(modelID, '\x00') {
-		return "", fmt.Errorf("modelID contains an invalid null byte")
+// CharToToken returns the index of the first token of the first sequence whose
+// offsets contain pos, or -1 if there is none. Positions are in the unit of
+// Offsets, see WithOffsetUnit. Requires WithReturnOffsets.
+func (e Encoding) CharToToken(pos int) int {
+	for i, o := range e.Offsets {
+		if e.SequenceIDs != nil && e.SequenceIDs[i] > 0 {
+			continue
+		}
+		if int(o[0]) <= pos && pos < int(o[1]) {
+			return i
+		}
 	}
-	return modelID, nil
+	return -1
 }
 
-func safeJoinCacheDir(baseDir, modelID string) (string, error) {
-	cleanModelID := filepath.Clean(modelID)
-	if filepath.IsAbs(cleanModelID) {
-		return "", fmt.Errorf("modelID must be relative")
-	}
-	if cleanModelID == ".." || strings.HasPrefix(cleanModelID, ".."+string(filepath.Separator)) {
-		return "", fmt.Errorf("modelID must not escape cache directory")
+// TokenToChars returns the offsets of the token at index i, it returns false if
+// i is out of range. Requires WithReturnOffsets.
+func (e Encoding) TokenToChars(i int) (start, end int, ok bool) {
+	if i < 0 || i >= len(e.Offsets) {
+		return -1, -1, false
 	}
+	return int(e.Offsets[i][0]), int(e.Offsets[i][1]), true
+}
 
-	baseClean := filepath.Clean(baseDir)
-	target := filepath.Join(baseClean, cleanModelID)
-	rel, err := filepath.Rel(baseClean, target)
-	if err != nil {
-		return "", fmt.Errorf("failed to validate cache path: %w", err)
-	}
-	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
-		return "", fmt.Errorf("modelID must not escape cache directory")
+// SpanToTokens returns the range [first, last) of tokens of the first sequence
+// overlapping the span [start, end) of the input, it returns false if no token
+// overlaps the span. Requires WithReturnOffsets.
+func (e Encoding) SpanToTokens(start, end int) (first, last int, ok bool) {
+	first, last = -1, -1
+	for i, o := range e.Offsets {
+		if e.SequenceIDs != nil && e.SequenceIDs[i] > 0 {
+			continue
+		}
+		if o[0] == o[1] || int(o[1]) <= start || int(o[0]) >= end {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i + 1
 	}
-
-	return target, nil
+	return first, last, first >= 0
 }
 
-// FromPretrained downloads necessary files and initializes the tokenizer.
-// Parameters:
-//   - modelID: The Hugging Face model identifier (e.g., "bert-base-uncased").
-//   - WithCacheDir(path): Optional. If provided, files will be downloaded to this folder.
-//   - WithAuthToken(token): Optional. If provided, it will be used to authenticate requests.
-func FromPretrained(modelID string, opts ...TokenizerConfigOption) (*Tokenizer, error) {
-	cfg := &tokenizerConfig{}
-	for _, opt := range opts {
-		opt(cfg)
-	}
-	normalizedModelID, err := normalizeModelID(modelID)
-	if err != nil {
-		return nil, err
-	}
+// OffsetUnit is the unit of Encoding.Offsets.
+type OffsetUnit int
 
-	// Construct the model URL
-	modelURL := fmt.Sprintf("%s/%s/resolve/main", baseURL, normalizedModelID)
+const (
+	// OffsetUnitBytes counts bytes of the UTF-8 input, the default.
+	OffsetUnitBytes OffsetUnit = iota
+	// OffsetUnitRunes counts Unicode code points, like Python string indices.
+	OffsetUnitRunes
+	// OffsetUnitUTF16 counts UTF-16 code units, like JavaScript string indices.
+	OffsetUnitUTF16
+)
 
-	// Determine the download directory
-	var downloadDir string
-	isTempDir := false
-	if cfg.cacheDir != nil {
-		downloadDir, err = safeJoinCacheDir(*cfg.cacheDir, normalizedModelID)
-		if err != nil {
-			return nil, err
+// offsetConverter returns a function converting byte offsets into text to unit.
+// A start in the middle of a character is moved to the start of the character,
+// an end to the end of the character.
+func offsetConverter(text string, unit OffsetUnit) func(Offset) Offset {
+	// floor[i] and ceil[i] are the positions in unit of byte i
+	floor := make([]uint, len(text)+1)
+	ceil := make([]uint, len(text)+1)
+	var pos uint
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		width := uint(1)
+		if unit == OffsetUnitUTF16 && r >= 0x10000 && size > 1 {
+			width = 2
 		}
-		// Create the destination directory if it doesn't exist
-		err = os.MkdirAll(downloadDir, os.ModePerm)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create destination directory %s: %w", downloadDir, err)
+		for j := i; j < i+size; j++ {
+			floor[j] = pos
+			ceil[j] = pos + width
 		}
-	} else {
-		// Create a temporary directory
-		tmpDir, err := os.MkdirTemp("", "huggingface-tokenizer-*")
-		if err != nil {
-			return nil, fmt.Errorf("error creating temporary directory: %w", err)
-		}
-		downloadDir = tmpDir
-		isTempDir = true
-	}
-	if isTempDir {
-		defer func() {
-			_ = os.RemoveAll(downloadDir)
-		}()
-	}
-
-	var wg sync.WaitGroup
-	errCh := make(chan error)
-
-	// Download each tokenizer file concurrently
-	for filename, isMandatory := range tokenizerFiles {
-		wg.Add(1)
-		go func(fn string, mandatory bool) {
-			defer wg.Done()
-			fileURL := fmt.Sprintf("%s/%s", modelURL, fn)
-			destPath := filepath.Join(downloadDir, fn)
-			err := downloadFile(fileURL, destPath, cfg.authToken)
-			if err != nil && mandatory {
-				// If the file is mandatory, report an error
-				errCh <- fmt.Errorf("failed to download mandatory file %s: %w", fn, err)
-			}
-		}(filename, isMandatory)
+		ceil[i] = pos
+		pos += width
+		i += size
 	}
-
-	go func() {
-		wg.Wait()
-		close(errCh)
-	}()
-
-	var errs []error
-	for err := range errCh {
-		errs = append(errs, err)
+	floor[len(text)] = pos
+	ceil[len(text)] = pos
+	return func(o Offset) Offset {
+		start, end := min(o[0], uint(len(text))), min(o[1], uint(len(text)))
+		return Offset{floor[start], ceil[end]}
 	}
+}
 
-	if len(errs) > 0 {
-		return nil, errs[0]
+// lossyUTF8 replaces every maximal invalid UTF-8 sequence of text by U+FFFD, like
+// String::from_utf8_lossy does for the text passed to the native library.
+func lossyUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == utf8.RuneError && size == 1 {
+			sb.WriteRune(utf8.RuneError)
+			i += invalidSequenceLen(text[i:])
+			continue
+		}
+		sb.WriteString(text[i : i+size])
+		i += size
 	}
+	return sb.String()
+}
 
-	return FromFile(filepath.Join(downloadDir, "tokenizer.json"))
+// invalidSequenceLen returns the length of the invalid UTF-8 sequence at the start
+// of s replaced by a single U+FFFD: the longest prefix of a valid sequence, at least 1.
+func invalidSequenceLen(s string) int {
+	lo, hi := byte(0x80), byte(0xBF)
+	var continuations int
+	switch b := s[0]; {
+	case b >= 0xC2 && b <= 0xDF:
+		continuations = 1
+	case b == 0xE0:
+		continuations, lo = 2, 0xA0
+	case b == 0xED:
+		continuations, hi = 2, 0x9F
+	case b >= 0xE1 && b <= 0xEF:
+		continuations = 2
+	case b == 0xF0:
+		continuations, lo = 3, 0x90
+	case b == 0xF4:
+		continuations, hi = 3, 0x8F
+	case b >= 0xF1 && b <= 0xF3:
+		continuations = 3
+	default:
+		return 1
+	}
+	size := 1
+	for size <= continuations && size < len(s) && s[size] >= lo && s[size] <= hi {
+		lo, hi = 0x80, 0xBF
+		size++
+	}
+	return size
 }
 
-// downloadFile downloads a file from the given URL and saves it to the specified destination.
-// If authToken is provided (non-nil), it will be used for authorization.
-// Returns an error if the download fails.
-func downloadFile(url, destination string, authToken *string) error {
-	// Check if the file already exists
-	if _, err := os.Stat(destination); err == nil {
+// lossyOffsetConverter returns a function converting byte offsets into lossyUTF8(text)
+// back to byte offsets into text, or nil if text is valid UTF-8. An offset inside
+// a replacement character is moved to the start of the invalid sequence for a start
+// and to its end for an end.
+func lossyOffsetConverter(text string) func(Offset) Offset {
+	if utf8.ValidString(text) {
 		return nil
 	}
-
-	// Create a new HTTP request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request for %s: %w", url, err)
+	// floor[i] and ceil[i] are the positions in text of byte i of the lossy text
+	var floor, ceil []uint
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == utf8.RuneError && size == 1 {
+			size = invalidSequenceLen(text[i:])
+			floor = append(floor, uint(i), uint(i), uint(i))
+			ceil = append(ceil, uint(i), uint(i+size), uint(i+size))
+		} else {
+			for j := i; j < i+size; j++ {
+				floor = append(floor, uint(j))
+				ceil = append(ceil, uint(j))
+			}
+		}
+		i += size
 	}
-
-	// If authToken is provided, set the Authorization header
-	if authToken != nil {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *authToken))
+	floor = append(floor, uint(len(text)))
+	ceil = append(ceil, uint(len(text)))
+	last := uint(len(floor) - 1)
+	return func(o Offset) Offset {
+		return Offset{floor[min(o[0], last)], ceil[min(o[1], last)]}
 	}
+}
 
-	resp, err := hfHTTPClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download from %s: %w", url, err)
+// offsetMapper returns a function converting byte offsets into the text seen by the
+// native library to offsets into text in unit, or nil if offsets are unchanged.
+func offsetMapper(text string, unit OffsetUnit) func(Offset) Offset {
+	lossy := lossyOffsetConverter(text)
+	if unit == OffsetUnitBytes {
+		return lossy
 	}
-	defer resp.Body.Close()
-
-	// Check for successful response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download from %s: status code %d", url, resp.StatusCode)
+	convert := offsetConverter(text, unit)
+	if lossy == nil {
+		return convert
 	}
-
-	// Create the destination file
-	out, err := os.Create(destination)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", destination, err)
+	return func(o Offset) Offset {
+		return convert(lossy(o))
 	}
-	defer out.Close()
+}
 
-	// Write the response body to the file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write to file %s: %w", destination, err)
+// mapOffsets replaces the offsets of every token by f, recursively for the
+// overflowing encodings.
+func mapOffsets(e *Encoding, f func(e *Encoding, i int) Offset) {
+	for i := range e.Offsets {
+		e.Offsets[i] = f(e, i)
+	}
+	for i := range e.Overflowing {
+		mapOffsets(&e.Overflowing[i], f)
 	}
-
-	return nil
 }
 
-func (t *Tokenizer) Close() error {
-	C.tokenizers_free_tokenizer(t.tokenizer)
-	t.tokenizer = nil
-	return nil
+// convertOffsets converts byte offsets into the text seen by the native library,
+// where invalid UTF-8 is replaced, to offsets into text in unit.
+func convertOffsets(e *Encoding, text string, unit OffsetUnit) {
+	convert := offsetMapper(text, unit)
+	if convert == nil {
+		return
+	}
+	mapOffsets(e, func(e *Encoding, i int) Offset {
+		return convert(e.Offsets[i])
+	})
 }
 
-type Offset [2]uint
-
-type Encoding struct {
-	IDs               []uint32
-	TypeIDs           []uint32
-	SpecialTokensMask []uint32
-	AttentionMask     []uint32
-	Tokens            []string
-	Offsets           []Offset
+// convertWordOffsets converts byte offsets relative to the words of the input
+// sequences, see convertOffsets. Requires the word and sequence IDs of the encoding.
+func convertWordOffsets(e *Encoding, sequences [][]string, unit OffsetUnit) {
+	converters := make(map[[2]int]func(Offset) Offset)
+	mapOffsets(e, func(e *Encoding, i int) Offset {
+		seq, word := e.SequenceIDs[i], e.WordIDs[i]
+		if seq < 0 || seq >= len(sequences) || word < 0 || word >= len(sequences[seq]) {
+			return e.Offsets[i]
+		}
+		convert, ok := converters[[2]int{seq, word}]
+		if !ok {
+			convert = offsetMapper(sequences[seq][word], unit)
+			converters[[2]int{seq, word}] = convert
+		}
+		if convert == nil {
+			return e.Offsets[i]
+		}
+		return convert(e.Offsets[i])
+	})
 }
 
 type encodeOpts struct {
@@ -366,10 +783,127 @@ type encodeOpts struct {
 	ReturnSpecialTokensMask C.bool
 	ReturnAttentionMask     C.bool
 	ReturnOffsets           C.bool
+	ReturnWordIDs           C.bool
+
+	// unit of the returned offsets, set by WithOffsetUnit
+	offsetUnit OffsetUnit
+
+	// set by WithoutTruncationAndPadding
+	withoutTruncationAndPadding bool
+
+	// special token policy, set by WithAllowedSpecial and WithDisallowedSpecial
+	specialPolicy     bool
+	allowedSpecial    []string
+	disallowedSpecial []string
+	disallowedSet     bool
 }
 
 type EncodeOption func(eo *encodeOpts)
 
+// AllSpecial passed to WithAllowedSpecial or WithDisallowedSpecial stands for all special tokens.
+const AllSpecial = "all"
+
+// DisallowedSpecialTokenError is returned when the encoded text contains
+// a special token disallowed by WithDisallowedSpecial.
+type DisallowedSpecialTokenError struct {
+	Token string
+}
+
+func (e *DisallowedSpecialTokenError) Error() string {
+	return fmt.Sprintf("text contains disallowed special token %q", e.Token)
+}
+
+// cStringArray copies strings to a C array, call free to release it.
+func cStringArray(strs []string) (arr **C.char, free func()) {
+	if len(strs) == 0 {
+		return nil, func() {}
+	}
+	ptr := C.malloc(C.size_t(len(strs)) * C.size_t(unsafe.Sizeof((*C.char)(nil))))
+	elems := unsafe.Slice((**C.char)(ptr), len(strs))
+	for i, s := range strs {
+		elems[i] = C.CString(s)
+	}
+	return (**C.char)(ptr), func() {
+		for _, s := range elems {
+			C.free(unsafe.Pointer(s))
+		}
+		C.free(ptr)
+	}
+}
+
+func isAllSpecial(tokens []string) bool {
+	return len(tokens) == 1 && tokens[0] == AllSpecial
+}
+
+// cOptions converts the options for the C API, call free to release them.
+func (eo *encodeOpts) cOptions() (options C.struct_tokenizers_encode_options, free func()) {
+	options = C.struct_tokenizers_encode_options{
+		add_special_tokens:         eo.AddSpecialTokens,
+		return_type_ids:            eo.ReturnTypeIDs,
+		return_tokens:              eo.ReturnTokens,
+		return_special_tokens_mask: eo.ReturnSpecialTokensMask,
+		return_attention_mask:      eo.ReturnAttentionMask,
+		return_offsets:             eo.ReturnOffsets,
+		return_word_ids:            eo.ReturnWordIDs,
+		special_policy:             C.bool(eo.specialPolicy),
+		without_truncation_padding: C.bool(eo.withoutTruncationAndPadding),
+	}
+	freeAllowed, freeDisallowed := func() {}, func() {}
+	if eo.specialPolicy {
+		if isAllSpecial(eo.allowedSpecial) {
+			options.allow_all_special = C.bool(true)
+		} else {
+			options.allowed_special, freeAllowed = cStringArray(eo.allowedSpecial)
+			options.allowed_special_len = C.size_t(len(eo.allowedSpecial))
+		}
+		// like tiktoken, all special tokens that are not allowed are disallowed by default
+		if !eo.disallowedSet || isAllSpecial(eo.disallowedSpecial) {
+			options.disallow_all_special = C.bool(true)
+		} else {
+			options.disallowed_special, freeDisallowed = cStringArray(eo.disallowedSpecial)
+			options.disallowed_special_len = C.size_t(len(eo.disallowedSpecial))
+		}
+	}
+	return options, func() {
+		freeAllowed()
+		freeDisallowed()
+	}
+}
+
+// bufferError returns the error of the buffer and frees it.
+func bufferError(res C.struct_tokenizers_buffer) error {
+	if res.disallowed_special != nil {
+		token := C.GoString(res.disallowed_special)
+		C.tokenizers_free_string(res.disallowed_special)
+		if res.error != nil {
+			C.tokenizers_free_string(res.error)
+		}
+		return &DisallowedSpecialTokenError{Token: token}
+	}
+	if res.error != nil {
+		errStr := C.GoString(res.error)
+		C.tokenizers_free_string(res.error)
+		return fmt.Errorf("%s", errStr)
+	}
+	return nil
+}
+
+// encode calls tokenizers_encode, the returned buffer must be freed with
+// tokenizers_free_buffer unless an error is returned.
+func (t *Tokenizer) encode(str string, eo *encodeOpts) (C.struct_tokenizers_buffer, error) {
+	if t.strictUTF8 && !utf8.ValidString(str) {
+		return C.struct_tokenizers_buffer{}, ErrInvalidUTF8
+	}
+	cStr := C.CString(str)
+	defer C.free(unsafe.Pointer(cStr))
+
+	options, free := eo.cOptions()
+	defer free()
+
+	res := C.tokenizers_encode(t.tokenizer, cStr, &options)
+	return res, bufferError(res)
+}
+
 func uintVecToSlice(arrPtr *C.uint, len int) []uint32 {
 	arr := unsafe.Slice(arrPtr, len)
 	slice := make([]uint32, len)
@@ -391,17 +925,77 @@ func offsetVecToSlice(arrPtr *C.size_t, tokenLength int) []Offset {
 	return slice
 }
 
+func intVecToSlice(arrPtr *C.int32_t, len int) []int {
+	arr := unsafe.Slice(arrPtr, len)
+	slice := make([]int, len)
+	for i, v := range arr {
+		slice[i] = int(v)
+	}
+	return slice
+}
+
+// encodingFromBuffer copies the requested attributes of the buffer.
+func encodingFromBuffer(res C.struct_tokenizers_buffer, encOptions *encodeOpts) Encoding {
+	length := int(res.len)
+	encoding := Encoding{}
+	encoding.IDs = uintVecToSlice(res.ids, length)
+
+	if encOptions.ReturnTypeIDs && res.type_ids != nil {
+		encoding.TypeIDs = uintVecToSlice(res.type_ids, length)
+	}
+
+	if encOptions.ReturnTokens && res.tokens != nil {
+		tokens := make([]string, length)
+		for i, s := range (*[1 << 30]*C.char)(unsafe.Pointer(res.tokens))[:length:length] {
+			tokens[i] = C.GoString(s)
+		}
+		encoding.Tokens = tokens
+	}
+
+	if encOptions.ReturnSpecialTokensMask && res.special_tokens_mask != nil {
+		encoding.SpecialTokensMask = uintVecToSlice(res.special_tokens_mask, length)
+	}
+
+	if encOptions.ReturnAttentionMask && res.attention_mask != nil {
+		encoding.AttentionMask = uintVecToSlice(res.attention_mask, length)
+	}
+
+	if encOptions.ReturnOffsets && res.offsets != nil {
+		encoding.Offsets = offsetVecToSlice(res.offsets, length)
+	}
+
+	if encOptions.ReturnWordIDs && res.word_ids != nil {
+		encoding.WordIDs = intVecToSlice(res.word_ids, length)
+	}
+
+	if encOptions.ReturnWordIDs && res.sequence_ids != nil {
+		encoding.SequenceIDs = intVecToSlice(res.sequence_ids, length)
+	}
+
+	if res.overflowing != nil {
+		overflowing := unsafe.Slice(res.overflowing, int(res.overflowing_len))
+		encoding.Overflowing = make([]Encoding, len(overflowing))
+		for i, o := range overflowing {
+			encoding.Overflowing[i] = encodingFromBuffer(o, encOptions)
+		}
+	}
+
+	return encoding
+}
+
 func (t *Tokenizer) EncodeErr(str string, addSpecialTokens bool) ([]uint32, []string, error) {
 	if t == nil || t.tokenizer == nil {
 		return nil, nil, ErrTokenizerClosed
 	}
-	cStr := C.CString(str)
-	defer C.free(unsafe.Pointer(cStr))
 	options := encodeOpts{
 		AddSpecialTokens: C.bool(addSpecialTokens),
 		ReturnTokens:     C.bool(true),
 	}
-	res := C.tokenizers_encode(t.tokenizer, cStr, (*C.struct_tokenizers_encode_options)(unsafe.Pointer(&options)))
+	res, err := t.encode(str, &options)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer C.tokenizers_free_buffer(res)
 	len := int(res.len)
 	if len == 0 {
 		if str == "" {
@@ -412,7 +1006,6 @@ func (t *Tokenizer) EncodeErr(str string, addSpecialTokens bool) ([]uint32, []st
 		}
 		return nil, nil, nil
 	}
-	defer C.tokenizers_free_buffer(res)
 
 	ids := uintVecToSlice(res.ids, len)
 
@@ -430,18 +1023,19 @@ func (t *Tokenizer) Encode(str string, addSpecialTokens bool) ([]uint32, []strin
 	if t == nil || t.tokenizer == nil {
 		return nil, nil
 	}
-	cStr := C.CString(str)
-	defer C.free(unsafe.Pointer(cStr))
 	options := encodeOpts{
 		AddSpecialTokens: C.bool(addSpecialTokens),
 		ReturnTokens:     C.bool(true),
 	}
-	res := C.tokenizers_encode(t.tokenizer, cStr, (*C.struct_tokenizers_encode_options)(unsafe.Pointer(&options)))
+	res, err := t.encode(str, &options)
+	if err != nil {
+		return nil, nil
+	}
+	defer C.tokenizers_free_buffer(res)
 	len := int(res.len)
 	if len == 0 {
 		return nil, nil
 	}
-	defer C.tokenizers_free_buffer(res)
 
 	ids := uintVecToSlice(res.ids, len)
 
@@ -463,6 +1057,7 @@ func WithReturnAllAttributes() EncodeOption {
 		eo.ReturnAttentionMask = C.bool(true)
 		eo.ReturnTokens = C.bool(true)
 		eo.ReturnOffsets = C.bool(true)
+		eo.ReturnWordIDs = C.bool(true)
 	}
 }
 
@@ -496,13 +1091,63 @@ func WithReturnOffsets() EncodeOption {
 	}
 }
 
+// WithOffsetUnit returns Encoding.Offsets in unit instead of bytes. Offsets
+// always point into the original input, even if the normalizer changed it.
+func WithOffsetUnit(unit OffsetUnit) EncodeOption {
+	return func(eo *encodeOpts) {
+		eo.ReturnOffsets = C.bool(true)
+		eo.offsetUnit = unit
+	}
+}
+
+// WithReturnWordIDs returns Encoding.WordIDs and Encoding.SequenceIDs, to align
+// word-level labels with tokens. Words are the pieces of the pre-tokenizer,
+// or of the pattern for tiktoken tokenizers.
+func WithReturnWordIDs() EncodeOption {
+	return func(eo *encodeOpts) {
+		eo.ReturnWordIDs = C.bool(true)
+	}
+}
+
+// WithoutTruncationAndPadding encodes the whole text, ignoring the truncation and
+// padding of the tokenizer, e.g. to count its tokens.
+func WithoutTruncationAndPadding() EncodeOption {
+	return func(eo *encodeOpts) {
+		eo.withoutTruncationAndPadding = true
+	}
+}
+
+// WithAllowedSpecial sets the special tokens parsed from the text, regardless of
+// addSpecialTokens, other special tokens are encoded as text unless disallowed.
+// Pass AllSpecial to allow all special tokens. Semantics follow allowed_special
+// of OpenAI's tiktoken: unless WithDisallowedSpecial is set, text containing
+// special tokens that are not allowed fails with DisallowedSpecialTokenError.
+//
+// Hugging Face tokenizers either parse all special tokens of the text or none,
+// so text that contains both allowed and not allowed special tokens fails to encode.
+func WithAllowedSpecial(tokens []string) EncodeOption {
+	return func(eo *encodeOpts) {
+		eo.specialPolicy = true
+		eo.allowedSpecial = tokens
+	}
+}
+
+// WithDisallowedSpecial sets the special tokens that fail encoding with
+// DisallowedSpecialTokenError if found in the text. Defaults to AllSpecial,
+// i.e. all special tokens not allowed by WithAllowedSpecial. Pass nil to
+// encode special tokens that are not allowed as text instead.
+func WithDisallowedSpecial(tokens []string) EncodeOption {
+	return func(eo *encodeOpts) {
+		eo.specialPolicy = true
+		eo.disallowedSpecial = tokens
+		eo.disallowedSet = true
+	}
+}
+
 func (t *Tokenizer) EncodeWithOptionsErr(str string, addSpecialTokens bool, opts ...EncodeOption) (Encoding, error) {
 	if t == nil || t.tokenizer == nil {
 		return Encoding{}, ErrTokenizerClosed
 	}
-	cStr := C.CString(str)
-	defer C.free(unsafe.Pointer(cStr))
-
 	encOptions := encodeOpts{
 		AddSpecialTokens: C.bool(addSpecialTokens),
 	}
@@ -510,9 +1155,13 @@ func (t *Tokenizer) EncodeWithOptionsErr(str string, addSpecialTokens bool, opts
 		opt(&encOptions)
 	}
 
-	res := C.tokenizers_encode(t.tokenizer, cStr, (*C.struct_tokenizers_encode_options)(unsafe.Pointer(&encOptions)))
-	len := int(res.len)
-	if len == 0 {
+	res, err := t.encode(str, &encOptions)
+	if err != nil {
+		return Encoding{}, err
+	}
+	defer C.tokenizers_free_buffer(res)
+	// truncation to 0 tokens moves all tokens to overflowing encodings
+	if res.len == 0 && res.overflowing_len == 0 {
 		if str == "" {
 			return Encoding{}, nil
 		}
@@ -521,87 +1170,437 @@ func (t *Tokenizer) EncodeWithOptionsErr(str string, addSpecialTokens bool, opts
 		}
 		return Encoding{}, nil
 	}
-	defer C.tokenizers_free_buffer(res)
 
-	encoding := Encoding{}
-	encoding.IDs = uintVecToSlice(res.ids, len)
+	encoding := encodingFromBuffer(res, &encOptions)
+	convertOffsets(&encoding, str, encOptions.offsetUnit)
+	return encoding, nil
+}
 
-	if encOptions.ReturnTypeIDs && res.type_ids != nil {
-		encoding.TypeIDs = uintVecToSlice(res.type_ids, len)
+func (t *Tokenizer) EncodeWithOptions(str string, addSpecialTokens bool, opts ...EncodeOption) Encoding {
+	if t == nil || t.tokenizer == nil {
+		return Encoding{}
+	}
+	encOptions := encodeOpts{
+		AddSpecialTokens: C.bool(addSpecialTokens),
+	}
+	for _, opt := range opts {
+		opt(&encOptions)
 	}
 
-	if encOptions.ReturnTokens && res.tokens != nil {
-		tokens := make([]string, len)
-		for i, s := range (*[1 << 30]*C.char)(unsafe.Pointer(res.tokens))[:len:len] {
-			tokens[i] = C.GoString(s)
+	res, err := t.encode(str, &encOptions)
+	if err != nil {
+		return Encoding{}
+	}
+	defer C.tokenizers_free_buffer(res)
+	if res.len == 0 && res.overflowing_len == 0 {
+		return Encoding{}
+	}
+
+	encoding := encodingFromBuffer(res, &encOptions)
+	convertOffsets(&encoding, str, encOptions.offsetUnit)
+	return encoding
+}
+
+// EncodeWords encodes input already split into words, e.g. by a linguistic
+// tokenizer, like is_pretokenized of Hugging Face tokenizers. Word IDs are the
+// indices of words and offsets are relative to each word. The special token
+// policy options are not supported.
+func (t *Tokenizer) EncodeWords(words []string, addSpecialTokens bool, opts ...EncodeOption) (Encoding, error) {
+	return t.encodeWords([][]string{words}, addSpecialTokens, opts)
+}
+
+// EncodeWordsPair encodes a pair of inputs already split into words, see EncodeWords.
+// Sequence IDs tell which input a token belongs to.
+func (t *Tokenizer) EncodeWordsPair(words, pair []string, addSpecialTokens bool, opts ...EncodeOption) (Encoding, error) {
+	return t.encodeWords([][]string{words, pair}, addSpecialTokens, opts)
+}
+
+func (t *Tokenizer) encodeWords(sequences [][]string, addSpecialTokens bool, opts []EncodeOption) (Encoding, error) {
+	if t == nil || t.tokenizer == nil {
+		return Encoding{}, ErrTokenizerClosed
+	}
+	encOptions := encodeOpts{
+		AddSpecialTokens: C.bool(addSpecialTokens),
+	}
+	for _, opt := range opts {
+		opt(&encOptions)
+	}
+	if encOptions.specialPolicy {
+		return Encoding{}, fmt.Errorf("special token policy is not supported for pre-tokenized input")
+	}
+	cSequences := make([]**C.char, len(sequences))
+	invalid := false
+	for i, words := range sequences {
+		valid, copied := words, false
+		for j, word := range words {
+			if utf8.ValidString(word) {
+				continue
+			}
+			if t.strictUTF8 {
+				return Encoding{}, ErrInvalidUTF8
+			}
+			// like Encode, invalid UTF-8 is replaced by the replacement character
+			if !copied {
+				valid, copied = slices.Clone(words), true
+			}
+			valid[j] = lossyUTF8(word)
+			invalid = true
 		}
-		encoding.Tokens = tokens
+		var free func()
+		cSequences[i], free = cStringArray(valid)
+		defer free()
 	}
+	// offsets are converted per word, which requires word IDs
+	unit := encOptions.offsetUnit
+	convert := (unit != OffsetUnitBytes || invalid) && bool(encOptions.ReturnOffsets)
+	bufferOptions := encOptions
+	if convert {
+		bufferOptions.ReturnWordIDs = C.bool(true)
+	}
+	options, free := bufferOptions.cOptions()
+	defer free()
 
-	if encOptions.ReturnSpecialTokensMask && res.special_tokens_mask != nil {
-		encoding.SpecialTokensMask = uintVecToSlice(res.special_tokens_mask, len)
+	var pair **C.char
+	var pairLen int
+	if len(sequences) > 1 {
+		pair, pairLen = cSequences[1], len(sequences[1])
+	}
+	res := C.tokenizers_encode_words(t.tokenizer, cSequences[0], C.size_t(len(sequences[0])), pair, C.size_t(pairLen), C.bool(len(sequences) > 1), &options)
+	if err := bufferError(res); err != nil {
+		return Encoding{}, err
+	}
+	defer C.tokenizers_free_buffer(res)
+	if res.len == 0 && res.overflowing_len == 0 {
+		return Encoding{}, nil
 	}
 
-	if encOptions.ReturnAttentionMask && res.attention_mask != nil {
-		encoding.AttentionMask = uintVecToSlice(res.attention_mask, len)
+	encoding := encodingFromBuffer(res, &bufferOptions)
+	if convert {
+		convertWordOffsets(&encoding, sequences, unit)
+		if !encOptions.ReturnWordIDs {
+			clearWordIDs(&encoding)
+		}
 	}
+	return encoding, nil
+}
 
-	if encOptions.ReturnOffsets && res.offsets != nil {
-		encoding.Offsets = offsetVecToSlice(res.offsets, len)
+// clearWordIDs removes the word and sequence IDs, recursively for the overflowing encodings.
+func clearWordIDs(e *Encoding) {
+	e.WordIDs, e.SequenceIDs = nil, nil
+	for i := range e.Overflowing {
+		clearWordIDs(&e.Overflowing[i])
 	}
+}
 
-	return encoding, nil
+// cBuffer copies the encoding to a C buffer, attributes not set for every token are
+// left null. Call free to release it.
+func (e Encoding) cBuffer() (buf C.struct_tokenizers_buffer, free func()) {
+	n := len(e.IDs)
+	var ptrs []unsafe.Pointer
+	alloc := func(size uintptr) unsafe.Pointer {
+		ptr := C.malloc(C.size_t(uintptr(max(n, 1)) * size))
+		ptrs = append(ptrs, ptr)
+		return ptr
+	}
+	uint32s := func(src []uint32) *C.uint32_t {
+		if len(src) != n {
+			return nil
+		}
+		ptr := (*C.uint32_t)(alloc(unsafe.Sizeof(C.uint32_t(0))))
+		dst := unsafe.Slice(ptr, n)
+		for i, v := range src {
+			dst[i] = C.uint32_t(v)
+		}
+		return ptr
+	}
+	int32s := func(src []int) *C.int32_t {
+		if len(src) != n {
+			return nil
+		}
+		ptr := (*C.int32_t)(alloc(unsafe.Sizeof(C.int32_t(0))))
+		dst := unsafe.Slice(ptr, n)
+		for i, v := range src {
+			dst[i] = C.int32_t(v)
+		}
+		return ptr
+	}
+
+	buf.len = C.size_t(n)
+	buf.ids = uint32s(e.IDs)
+	buf.type_ids = uint32s(e.TypeIDs)
+	buf.special_tokens_mask = uint32s(e.SpecialTokensMask)
+	buf.attention_mask = uint32s(e.AttentionMask)
+	buf.word_ids = int32s(e.WordIDs)
+	buf.sequence_ids = int32s(e.SequenceIDs)
+	if len(e.Offsets) == n {
+		offsets := unsafe.Slice((*C.size_t)(alloc(2*unsafe.Sizeof(C.size_t(0)))), 2*n)
+		for i, o := range e.Offsets {
+			offsets[2*i], offsets[2*i+1] = C.size_t(o[0]), C.size_t(o[1])
+		}
+		buf.offsets = unsafe.SliceData(offsets)
+	}
+	freeTokens := func() {}
+	if len(e.Tokens) == n && n > 0 {
+		buf.tokens, freeTokens = cStringArray(e.Tokens)
+	}
+	return buf, func() {
+		for _, ptr := range ptrs {
+			C.free(ptr)
+		}
+		freeTokens()
+	}
 }
 
-func (t *Tokenizer) EncodeWithOptions(str string, addSpecialTokens bool, opts ...EncodeOption) Encoding {
+// PostProcess runs the post-processor of the tokenizer on already encoded sequences,
+// e.g. to add the special tokens of the template once to concatenated cached
+// encodings, second is nil for a single sequence. Truncation and padding of the
+// tokenizer are applied too. Tiktoken tokenizers have no post-processor, the
+// sequences are concatenated. All attributes of the result are returned.
+func (t *Tokenizer) PostProcess(first Encoding, second *Encoding, addSpecialTokens bool) (Encoding, error) {
 	if t == nil || t.tokenizer == nil {
-		return Encoding{}
+		return Encoding{}, ErrTokenizerClosed
 	}
-	cStr := C.CString(str)
-	defer C.free(unsafe.Pointer(cStr))
-
 	encOptions := encodeOpts{
 		AddSpecialTokens: C.bool(addSpecialTokens),
 	}
-	for _, opt := range opts {
-		opt(&encOptions)
-	}
+	WithReturnAllAttributes()(&encOptions)
+	options, free := encOptions.cOptions()
+	defer free()
 
-	res := C.tokenizers_encode(t.tokenizer, cStr, (*C.struct_tokenizers_encode_options)(unsafe.Pointer(&encOptions)))
-	len := int(res.len)
-	if len == 0 {
-		return Encoding{}
+	cFirst, freeFirst := first.cBuffer()
+	defer freeFirst()
+	var cSecond *C.struct_tokenizers_buffer
+	if second != nil {
+		buf, freeSecond := second.cBuffer()
+		defer freeSecond()
+		cSecond = &buf
+	}
+	res := C.tokenizers_post_process(t.tokenizer, &cFirst, cSecond, &options)
+	if err := bufferError(res); err != nil {
+		return Encoding{}, err
 	}
 	defer C.tokenizers_free_buffer(res)
+	if res.len == 0 && res.overflowing_len == 0 {
+		return Encoding{}, nil
+	}
 
-	encoding := Encoding{}
-	encoding.IDs = uintVecToSlice(res.ids, len)
+	return encodingFromBuffer(res, &encOptions), nil
+}
 
-	if encOptions.ReturnTypeIDs && res.type_ids != nil {
-		encoding.TypeIDs = uintVecToSlice(res.type_ids, len)
+// NumSpecialTokensToAdd returns the number of special tokens the post-processor adds
+// to a single sequence or to a pair of sequences, e.g. to budget the tokens of inputs.
+func (t *Tokenizer) NumSpecialTokensToAdd(pair bool) int {
+	if t == nil || t.tokenizer == nil {
+		return 0
+	}
+	return int(C.tokenizers_num_special_tokens_to_add(t.tokenizer, C.bool(pair)))
+}
+
+// BatchTensors holds a batch of encodings as row-major tensors of Shape, ready to
+// be passed to a model, e.g. with ONNX Runtime.
+type BatchTensors struct {
+	// Shape is [batch size, sequence length] of every tensor.
+	Shape [2]int
+
+	InputIDs      []int64
+	AttentionMask []int64
+	TokenTypeIDs  []int64
+
+	// Set instead of the int64 tensors by WithInt32Tensors.
+	InputIDs32      []int32
+	AttentionMask32 []int32
+	TokenTypeIDs32  []int32
+}
+
+type tensorOpts struct {
+	withoutSpecialTokens bool
+	int32                bool
+	paddingDirection     *PaddingDirection
+	padToMultipleOf      *uint32
+}
+
+type TensorOption func(to *tensorOpts)
+
+// WithoutSpecialTokens encodes the texts without adding special tokens, which are
+// added by default.
+func WithoutSpecialTokens() TensorOption {
+	return func(to *tensorOpts) {
+		to.withoutSpecialTokens = true
 	}
+}
 
-	if encOptions.ReturnTokens && res.tokens != nil {
-		tokens := make([]string, len)
-		for i, s := range (*[1 << 30]*C.char)(unsafe.Pointer(res.tokens))[:len:len] {
-			tokens[i] = C.GoString(s)
+// WithInt32Tensors returns int32 tensors instead of int64 tensors.
+func WithInt32Tensors() TensorOption {
+	return func(to *tensorOpts) {
+		to.int32 = true
+	}
+}
+
+// WithTensorPadding overrides the padding direction set by WithPadding, which
+// defaults to the right.
+func WithTensorPadding(dir PaddingDirection) TensorOption {
+	return func(to *tensorOpts) {
+		to.paddingDirection = &dir
+	}
+}
+
+// WithTensorPadToMultipleOf overrides the multiple set by WithPadToMultipleOf and
+// the length set by WithPadding, 0 disables rounding.
+func WithTensorPadToMultipleOf(multiple uint32) TensorOption {
+	return func(to *tensorOpts) {
+		to.padToMultipleOf = &multiple
+	}
+}
+
+// EncodeBatchTensors encodes the texts into tensors padded to the longest encoding,
+// rounded up to a multiple of the pad to multiple of setting. Truncation of the
+// tokenizer is applied to every encoding, its padding is only the default of the
+// tensor options, a fixed length included. The pad ID is the one of the pad token
+// of the tokenizer or, without padding, of the vocabulary, e.g. [PAD] or <pad>.
+// Each tensor is a single allocation.
+func (t *Tokenizer) EncodeBatchTensors(texts []string, opts ...TensorOption) (BatchTensors, error) {
+	if t == nil || t.tokenizer == nil {
+		return BatchTensors{}, ErrTokenizerClosed
+	}
+	var o tensorOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if t.strictUTF8 {
+		for _, text := range texts {
+			if !utf8.ValidString(text) {
+				return BatchTensors{}, ErrInvalidUTF8
+			}
 		}
-		encoding.Tokens = tokens
 	}
+	options := C.struct_tokenizers_tensor_options{
+		add_special_tokens: C.bool(!o.withoutSpecialTokens),
+	}
+	if o.paddingDirection != nil {
+		options.padding_direction_set = C.bool(true)
+		options.padding_direction = C.uint8_t(*o.paddingDirection)
+	}
+	if o.padToMultipleOf != nil {
+		options.pad_to_multiple_of_set = C.bool(true)
+		options.pad_to_multiple_of = C.size_t(*o.padToMultipleOf)
+	}
+	cTexts, free := cStringArray(texts)
+	defer free()
+
+	res := C.tokenizers_encode_batch_tensors(t.tokenizer, cTexts, C.size_t(len(texts)), &options)
+	if res.error != nil {
+		errStr := C.GoString(res.error)
+		C.tokenizers_free_string(res.error)
+		return BatchTensors{}, fmt.Errorf("%s", errStr)
+	}
+	defer C.tokenizers_free_tensors(res)
+
+	rows, cols := int(res.rows), int(res.cols)
+	tensors := BatchTensors{Shape: [2]int{rows, cols}}
+	size := rows * cols
+	if size == 0 {
+		return tensors, nil
+	}
+	inputIDs := unsafe.Slice((*int64)(unsafe.Pointer(res.input_ids)), size)
+	attentionMask := unsafe.Slice((*int64)(unsafe.Pointer(res.attention_mask)), size)
+	tokenTypeIDs := unsafe.Slice((*int64)(unsafe.Pointer(res.token_type_ids)), size)
+	if o.int32 {
+		tensors.InputIDs32 = toInt32(inputIDs)
+		tensors.AttentionMask32 = toInt32(attentionMask)
+		tensors.TokenTypeIDs32 = toInt32(tokenTypeIDs)
+	} else {
+		tensors.InputIDs = slices.Clone(inputIDs)
+		tensors.AttentionMask = slices.Clone(attentionMask)
+		tensors.TokenTypeIDs = slices.Clone(tokenTypeIDs)
+	}
+	return tensors, nil
+}
 
-	if encOptions.ReturnSpecialTokensMask && res.special_tokens_mask != nil {
-		encoding.SpecialTokensMask = uintVecToSlice(res.special_tokens_mask, len)
+func toInt32(src []int64) []int32 {
+	dst := make([]int32, len(src))
+	for i, v := range src {
+		dst[i] = int32(v)
 	}
+	return dst
+}
 
-	if encOptions.ReturnAttentionMask && res.attention_mask != nil {
-		encoding.AttentionMask = uintVecToSlice(res.attention_mask, len)
+// PreToken is a piece of the text split by the pre-tokenizer.
+type PreToken struct {
+	// Text is the normalized piece, e.g. with the byte-level alphabet of a ByteLevel pre-tokenizer
+	Text string
+	// Offset is the byte offset of the piece in the original text
+	Offset Offset
+}
+
+// Normalize runs only the normalizer of the tokenizer, it returns the normalized
+// text and the byte offsets in text of every byte of it. Tiktoken tokenizers
+// don't normalize the text.
+func (t *Tokenizer) Normalize(text string) (string, []Offset, error) {
+	if t == nil || t.tokenizer == nil {
+		return "", nil, ErrTokenizerClosed
+	}
+	if t.strictUTF8 && !utf8.ValidString(text) {
+		return "", nil, ErrInvalidUTF8
 	}
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
 
-	if encOptions.ReturnOffsets && res.offsets != nil {
-		encoding.Offsets = offsetVecToSlice(res.offsets, len)
+	var cNormalized *C.char
+	res := C.tokenizers_normalize(t.tokenizer, cText, &cNormalized)
+	if err := bufferError(res); err != nil {
+		return "", nil, err
+	}
+	defer C.tokenizers_free_buffer(res)
+	if cNormalized == nil {
+		return "", nil, fmt.Errorf("failed to normalize input")
 	}
+	defer C.tokenizers_free_string(cNormalized)
 
-	return encoding
+	var alignments []Offset
+	if res.len > 0 {
+		alignments = offsetVecToSlice(res.offsets, int(res.len))
+	}
+	if convert := lossyOffsetConverter(text); convert != nil {
+		for i, o := range alignments {
+			alignments[i] = convert(o)
+		}
+	}
+	return C.GoString(cNormalized), alignments, nil
+}
+
+// PreTokenize runs the normalizer and the pre-tokenizer of the tokenizer, or splits
+// the text with the pattern for tiktoken tokenizers. Special tokens are not parsed.
+func (t *Tokenizer) PreTokenize(text string) ([]PreToken, error) {
+	if t == nil || t.tokenizer == nil {
+		return nil, ErrTokenizerClosed
+	}
+	if t.strictUTF8 && !utf8.ValidString(text) {
+		return nil, ErrInvalidUTF8
+	}
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+
+	res := C.tokenizers_pre_tokenize(t.tokenizer, cText)
+	if err := bufferError(res); err != nil {
+		return nil, err
+	}
+	defer C.tokenizers_free_buffer(res)
+
+	length := int(res.len)
+	if length == 0 {
+		return nil, nil
+	}
+	offsets := offsetVecToSlice(res.offsets, length)
+	if convert := lossyOffsetConverter(text); convert != nil {
+		for i, o := range offsets {
+			offsets[i] = convert(o)
+		}
+	}
+	preTokens := make([]PreToken, length)
+	for i, s := range unsafe.Slice(res.tokens, length) {
+		preTokens[i] = PreToken{Text: C.GoString(s), Offset: offsets[i]}
+	}
+	return preTokens, nil
 }
 
 func (t *Tokenizer) DecodeErr(tokenIDs []uint32, skipSpecialTokens bool) (string, error) {
@@ -1,7 +1,10 @@
 package tokenizers_test
 
 import (
+	"bytes"
 	_ "embed"
+	"encoding/base64"
+	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -180,6 +183,56 @@ func TestEncodeSpecialTokens(t *testing.T) {
 	tk.Close()
 }
 
+func TestAllowedSpecial(t *testing.T) {
+	tk, err := tokenizers.FromBytes(embeddedBytes)
+	require.NoError(t, err)
+	defer tk.Close()
+
+	encoding, err := tk.EncodeWithOptionsErr("[CLS]fox[SEP]", false, tokenizers.WithAllowedSpecial([]string{tokenizers.AllSpecial}))
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 193284, 102}, encoding.IDs)
+
+	// special tokens that are not allowed are encoded as text
+	encoding, err = tk.EncodeWithOptionsErr("[CLS]fox[SEP]", false, tokenizers.WithAllowedSpecial(nil), tokenizers.WithDisallowedSpecial(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{164, 304910, 166, 193284, 164, 211703, 166}, encoding.IDs)
+
+	// special tokens that are not allowed are disallowed by default
+	_, err = tk.EncodeWithOptionsErr("[CLS]fox[SEP]", false, tokenizers.WithAllowedSpecial([]string{"[CLS]"}))
+	var disallowedErr *tokenizers.DisallowedSpecialTokenError
+	require.ErrorAs(t, err, &disallowedErr)
+	assert.Equal(t, "[SEP]", disallowedErr.Token)
+	_, err = tk.EncodeWithOptionsErr("fox[SEP]", true, tokenizers.WithDisallowedSpecial([]string{"[SEP]"}))
+	require.ErrorAs(t, err, &disallowedErr)
+
+	// Hugging Face tokenizers can't parse some special tokens and not others
+	_, err = tk.EncodeWithOptionsErr("[CLS]fox[SEP]", false, tokenizers.WithAllowedSpecial([]string{"[CLS]"}), tokenizers.WithDisallowedSpecial(nil))
+	require.Error(t, err)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+
+	text := "<|begin_of_text|>hi<|eot_id|>"
+	encoding, err = tk.EncodeWithOptionsErr(text, false, tokenizers.WithAllowedSpecial([]string{"<|begin_of_text|>"}), tokenizers.WithDisallowedSpecial(nil))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(128000), encoding.IDs[0])
+	assert.NotContains(t, encoding.IDs, uint32(128009))
+	assert.Equal(t, text, tk.Decode(encoding.IDs, false))
+
+	_, err = tk.EncodeWithOptionsErr(text, true, tokenizers.WithAllowedSpecial([]string{"<|begin_of_text|>"}))
+	require.ErrorAs(t, err, &disallowedErr)
+	assert.Equal(t, "<|eot_id|>", disallowedErr.Token)
+
+	encoding, err = tk.EncodeWithOptionsErr(text, false, tokenizers.WithAllowedSpecial([]string{tokenizers.AllSpecial}))
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{128000, 6151, 128009}, encoding.IDs)
+}
+
 func TestEncodeOptions(t *testing.T) {
 	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
 	require.NoError(t, err)
@@ -260,6 +313,344 @@ func TestEncodeOptions(t *testing.T) {
 	}
 }
 
+func TestWordIDs(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	encoding := tk.EncodeWithOptions("tokenization of brown fox", true, tokenizers.WithReturnTokens(), tokenizers.WithReturnWordIDs())
+	assert.Equal(t, []string{"[CLS]", "token", "##ization", "of", "brown", "fox", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []int{-1, 0, 0, 1, 2, 3, -1}, encoding.WordIDs)
+	assert.Equal(t, []int{-1, 0, 0, 0, 0, 0, -1}, encoding.SequenceIDs)
+	start, end, ok := encoding.WordToTokens(0)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"token", "##ization"}, encoding.Tokens[start:end])
+	_, _, ok = encoding.WordToTokens(4)
+	assert.False(t, ok)
+	assert.Equal(t, 0, encoding.TokenToWord(2))
+	assert.Equal(t, -1, encoding.TokenToWord(0))
+	assert.Equal(t, -1, encoding.TokenToWord(7))
+	assert.Nil(t, tk.EncodeWithOptions("brown fox", true).WordIDs)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	// words are the pieces of the pattern, special tokens are words of their own
+	encoding = tk.EncodeWithOptions("<|begin_of_text|>hi world", true, tokenizers.WithReturnWordIDs())
+	assert.Equal(t, []uint32{128000, 6151, 1917}, encoding.IDs)
+	assert.Equal(t, []int{0, 1, 2}, encoding.WordIDs)
+	assert.Equal(t, []int{0, 0, 0}, encoding.SequenceIDs)
+}
+
+func TestOffsetUnits(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	// the normalizer lowercases and strips accents, offsets still point into the input
+	str := "Héllo WÖRLD 😀 café"
+	tests := []struct {
+		unit tokenizers.OffsetUnit
+		want []tokenizers.Offset
+	}{
+		{tokenizers.OffsetUnitBytes, []tokenizers.Offset{{0, 6}, {7, 13}, {14, 18}, {19, 24}}},
+		{tokenizers.OffsetUnitRunes, []tokenizers.Offset{{0, 5}, {6, 11}, {12, 13}, {14, 18}}},
+		{tokenizers.OffsetUnitUTF16, []tokenizers.Offset{{0, 5}, {6, 11}, {12, 14}, {15, 19}}},
+	}
+	for _, tt := range tests {
+		encoding := tk.EncodeWithOptions(str, false, tokenizers.WithReturnTokens(), tokenizers.WithOffsetUnit(tt.unit))
+		assert.Equal(t, []string{"hello", "world", "[UNK]", "cafe"}, encoding.Tokens)
+		assert.Equal(t, tt.want, encoding.Offsets, "unit %d", tt.unit)
+	}
+
+	encoding := tk.EncodeWithOptions(str, true, tokenizers.WithOffsetUnit(tokenizers.OffsetUnitRunes), tokenizers.WithReturnWordIDs())
+	assert.Equal(t, 1, encoding.CharToToken(0))
+	assert.Equal(t, 2, encoding.CharToToken(10))
+	assert.Equal(t, -1, encoding.CharToToken(5))
+	assert.Equal(t, -1, encoding.CharToToken(18))
+	start, end, ok := encoding.TokenToChars(4)
+	assert.True(t, ok)
+	assert.Equal(t, "café", string([]rune(str)[start:end]))
+	_, _, ok = encoding.TokenToChars(6)
+	assert.False(t, ok)
+	first, last, ok := encoding.SpanToTokens(3, 8)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1, 3}, []int{first, last})
+	_, _, ok = encoding.SpanToTokens(5, 6)
+	assert.False(t, ok)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	// byte tokens in the middle of a character cover the whole character
+	encoding = tk.EncodeWithOptions("hi 😀", false, tokenizers.WithOffsetUnit(tokenizers.OffsetUnitUTF16))
+	require.NotEmpty(t, encoding.Offsets)
+	assert.Equal(t, tokenizers.Offset{0, 2}, encoding.Offsets[0])
+	assert.Equal(t, uint(5), encoding.Offsets[len(encoding.Offsets)-1][1])
+	for _, o := range encoding.Offsets {
+		assert.LessOrEqual(t, o[0], o[1])
+		assert.NotEqual(t, uint(4), o[0], "offset inside a surrogate pair")
+	}
+}
+
+func TestOffsetsInvalidUTF8(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	// invalid sequences are replaced by U+FFFD before encoding, which the normalizer
+	// removes, offsets still point into the input
+	str := "\xff\xffhello \xe2\x82 wörld"
+	encoding := tk.EncodeWithOptions(str, false, tokenizers.WithReturnTokens(), tokenizers.WithReturnOffsets())
+	assert.Equal(t, []string{"hello", "world"}, encoding.Tokens)
+	assert.Equal(t, []tokenizers.Offset{{2, 7}, {11, 17}}, encoding.Offsets)
+	assert.Equal(t, "wörld", str[11:17])
+	assert.Equal(t, 1, encoding.CharToToken(12))
+	first, last, ok := encoding.SpanToTokens(8, 12)
+	assert.True(t, ok)
+	assert.Equal(t, []int{1, 2}, []int{first, last})
+
+	// every invalid byte is a rune, like converting the string to []rune
+	encoding = tk.EncodeWithOptions(str, false, tokenizers.WithOffsetUnit(tokenizers.OffsetUnitRunes))
+	assert.Equal(t, []tokenizers.Offset{{2, 7}, {11, 16}}, encoding.Offsets)
+	assert.Equal(t, "wörld", string([]rune(str)[11:16]))
+	encoding = tk.EncodeWithOptions(str, false, tokenizers.WithOffsetUnit(tokenizers.OffsetUnitUTF16))
+	assert.Equal(t, []tokenizers.Offset{{2, 7}, {11, 16}}, encoding.Offsets)
+
+	encoding, err = tk.EncodeWords([]string{"h\xffi", "fox"}, false, tokenizers.WithReturnOffsets())
+	require.NoError(t, err)
+	assert.Equal(t, []tokenizers.Offset{{0, 3}, {0, 3}}, encoding.Offsets)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	str = "\xff\xff one two"
+	encoding = tk.EncodeWithOptions(str, false, tokenizers.WithReturnOffsets())
+	require.NotEmpty(t, encoding.Offsets)
+	for _, o := range encoding.Offsets {
+		assert.LessOrEqual(t, o[1], uint(len(str)))
+	}
+	o := encoding.Offsets[len(encoding.Offsets)-1]
+	assert.Equal(t, " two", str[o[0]:o[1]])
+}
+
+func TestEncodeWords(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	encoding, err := tk.EncodeWords([]string{"Tokenization", "of", "brown", "fox"}, true, tokenizers.WithReturnAllAttributes())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"[CLS]", "token", "##ization", "of", "brown", "fox", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []int{-1, 0, 0, 1, 2, 3, -1}, encoding.WordIDs)
+	// offsets are relative to each word
+	assert.Equal(t, []tokenizers.Offset{{0, 0}, {0, 5}, {5, 12}, {0, 2}, {0, 5}, {0, 3}, {0, 0}}, encoding.Offsets)
+
+	encoding, err = tk.EncodeWordsPair([]string{"brown", "fox"}, []string{"jumps"}, true, tokenizers.WithReturnTypeIDs(), tokenizers.WithReturnWordIDs())
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102, 14523, 102}, encoding.IDs)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 1, 1}, encoding.TypeIDs)
+	assert.Equal(t, []int{-1, 0, 1, -1, 0, -1}, encoding.WordIDs)
+	assert.Equal(t, []int{-1, 0, 0, -1, 1, -1}, encoding.SequenceIDs)
+
+	encoding, err = tk.EncodeWords([]string{"Héllo", "wörld"}, false, tokenizers.WithOffsetUnit(tokenizers.OffsetUnitRunes))
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{7592, 2088}, encoding.IDs)
+	assert.Equal(t, []tokenizers.Offset{{0, 5}, {0, 5}}, encoding.Offsets)
+	assert.Nil(t, encoding.WordIDs)
+
+	_, err = tk.EncodeWords([]string{"fox"}, true, tokenizers.WithAllowedSpecial([]string{tokenizers.AllSpecial}))
+	assert.Error(t, err)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	world, _ := tk.Encode("world", false)
+	encoding, err = tk.EncodeWords([]string{"hi", "world"}, false, tokenizers.WithReturnWordIDs())
+	require.NoError(t, err)
+	assert.Equal(t, append([]uint32{6151}, world...), encoding.IDs)
+	assert.Equal(t, []int{0, 1}, encoding.WordIDs)
+	encoding, err = tk.EncodeWordsPair([]string{"hi"}, []string{"world"}, false, tokenizers.WithReturnWordIDs())
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 0}, encoding.WordIDs)
+	assert.Equal(t, []int{0, 1}, encoding.SequenceIDs)
+}
+
+func TestNormalizeAndPreTokenize(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	normalized, alignments, err := tk.Normalize("Héllo")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", normalized)
+	// e is aligned to both bytes of é
+	assert.Equal(t, []tokenizers.Offset{{0, 1}, {1, 3}, {3, 4}, {4, 5}, {5, 6}}, alignments)
+	preTokens, err := tk.PreTokenize("Héllo, world")
+	require.NoError(t, err)
+	assert.Equal(t, []tokenizers.PreToken{
+		{Text: "hello", Offset: tokenizers.Offset{0, 6}},
+		{Text: ",", Offset: tokenizers.Offset{6, 7}},
+		{Text: "world", Offset: tokenizers.Offset{8, 13}},
+	}, preTokens)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	normalized, alignments, err = tk.Normalize("Hi")
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", normalized)
+	assert.Equal(t, []tokenizers.Offset{{0, 1}, {1, 2}}, alignments)
+	preTokens, err = tk.PreTokenize("hi world<|eot_id|>")
+	require.NoError(t, err)
+	assert.Equal(t, tokenizers.PreToken{Text: "hi", Offset: tokenizers.Offset{0, 2}}, preTokens[0])
+	assert.Equal(t, tokenizers.PreToken{Text: " world", Offset: tokenizers.Offset{2, 8}}, preTokens[1])
+}
+
+func TestPostProcess(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, 2, tk.NumSpecialTokensToAdd(false))
+	assert.Equal(t, 3, tk.NumSpecialTokensToAdd(true))
+
+	// post-processing an encoding without special tokens is the same as adding them when encoding
+	first := tk.EncodeWithOptions("brown fox", false, tokenizers.WithReturnAllAttributes())
+	encoding, err := tk.PostProcess(first, nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, tk.EncodeWithOptions("brown fox", true, tokenizers.WithReturnAllAttributes()), encoding)
+
+	// missing attributes are derived from the IDs
+	encoding, err = tk.PostProcess(tokenizers.Encoding{IDs: []uint32{2829, 4419}}, &tokenizers.Encoding{IDs: []uint32{14523}}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 2829, 4419, 102, 14523, 102}, encoding.IDs)
+	assert.Equal(t, []string{"[CLS]", "brown", "fox", "[SEP]", "jumps", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 1, 1}, encoding.TypeIDs)
+	assert.Equal(t, []uint32{1, 0, 0, 1, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, []int{-1, 0, 0, -1, 1, -1}, encoding.SequenceIDs)
+
+	encoding, err = tk.PostProcess(tokenizers.Encoding{IDs: []uint32{2829, 4419}}, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2829, 4419}, encoding.IDs)
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, 0, tk.NumSpecialTokensToAdd(true))
+	// there is no post-processor, sequences are concatenated
+	encoding, err = tk.PostProcess(tokenizers.Encoding{IDs: []uint32{128000, 6151}}, &tokenizers.Encoding{IDs: []uint32{1917}}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{128000, 6151, 1917}, encoding.IDs)
+	assert.Equal(t, []string{"<|begin_of_text|>", "hi", "Ġworld"}, encoding.Tokens)
+	assert.Equal(t, []uint32{1, 0, 0}, encoding.SpecialTokensMask)
+	assert.Equal(t, []int{0, 0, 1}, encoding.SequenceIDs)
+}
+
+func TestEncodeBatchTensors(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	texts := []string{"brown fox", "the lazy dog jumps"}
+
+	tensors, err := tk.EncodeBatchTensors(texts)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 6}, tensors.Shape)
+	assert.Equal(t, []int64{
+		101, 2829, 4419, 102, 0, 0,
+		101, 1996, 13971, 3899, 14523, 102,
+	}, tensors.InputIDs)
+	assert.Equal(t, []int64{
+		1, 1, 1, 1, 0, 0,
+		1, 1, 1, 1, 1, 1,
+	}, tensors.AttentionMask)
+	assert.Equal(t, make([]int64, 12), tensors.TokenTypeIDs)
+	assert.Nil(t, tensors.InputIDs32)
+
+	tensors, err = tk.EncodeBatchTensors(texts,
+		tokenizers.WithoutSpecialTokens(),
+		tokenizers.WithTensorPadding(tokenizers.PaddingDirectionLeft),
+		tokenizers.WithTensorPadToMultipleOf(3),
+		tokenizers.WithInt32Tensors(),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 6}, tensors.Shape)
+	assert.Equal(t, []int32{
+		0, 0, 0, 0, 2829, 4419,
+		0, 0, 1996, 13971, 3899, 14523,
+	}, tensors.InputIDs32)
+	assert.Equal(t, []int32{
+		0, 0, 0, 0, 1, 1,
+		0, 0, 1, 1, 1, 1,
+	}, tensors.AttentionMask32)
+	assert.Len(t, tensors.TokenTypeIDs32, 12)
+	assert.Nil(t, tensors.InputIDs)
+
+	tensors, err = tk.EncodeBatchTensors(nil)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{0, 0}, tensors.Shape)
+
+	// the padding of the tokenizer is the default
+	tk, err = tokenizers.FromFile("./test/data/bert-base-uncased.json",
+		tokenizers.WithPadding(0, tokenizers.PaddingDirectionLeft), tokenizers.WithPadToMultipleOf(4), tokenizers.WithPadToken("[PAD]"))
+	require.NoError(t, err)
+	defer tk.Close()
+	tensors, err = tk.EncodeBatchTensors(texts)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 8}, tensors.Shape)
+	assert.Equal(t, []int64{0, 0, 0, 0, 101, 2829, 4419, 102}, tensors.InputIDs[:8])
+	// the encodings are padded only once, in the direction of the options
+	tensors, err = tk.EncodeBatchTensors(texts, tokenizers.WithTensorPadding(tokenizers.PaddingDirectionRight))
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 8}, tensors.Shape)
+	assert.Equal(t, []int64{101, 2829, 4419, 102, 0, 0, 0, 0}, tensors.InputIDs[:8])
+	assert.Equal(t, []int64{1, 1, 1, 1, 0, 0, 0, 0}, tensors.AttentionMask[:8])
+
+	// the multiple of the options replaces the fixed length of the tokenizer
+	tk, err = tokenizers.FromFile("./test/data/bert-base-uncased.json",
+		tokenizers.WithPadding(16, tokenizers.PaddingDirectionRight), tokenizers.WithPadToken("[PAD]"))
+	require.NoError(t, err)
+	defer tk.Close()
+	tensors, err = tk.EncodeBatchTensors(texts)
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 16}, tensors.Shape)
+	tensors, err = tk.EncodeBatchTensors(texts, tokenizers.WithTensorPadToMultipleOf(4))
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 8}, tensors.Shape)
+	assert.Equal(t, []int64{101, 1996, 13971, 3899, 14523, 102, 0, 0}, tensors.InputIDs[8:])
+
+	tk, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	tensors, err = tk.EncodeBatchTensors([]string{"hi", "hi world"})
+	require.NoError(t, err)
+	assert.Equal(t, [2]int{2, 2}, tensors.Shape)
+	assert.Equal(t, []int64{6151, 6151, 1917}, []int64{tensors.InputIDs[0], tensors.InputIDs[2], tensors.InputIDs[3]})
+	assert.Equal(t, []int64{1, 0, 1, 1}, tensors.AttentionMask)
+}
+
 func TestEncodeWithTruncation(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -642,10 +1033,332 @@ func TestFromTiktoken(t *testing.T) {
 		assert.Equal(t, "Hello, world! 你好，世界！", decoded)
 	}
 
+	{
+		encoding := tk.EncodeWithOptions("<|im_middle|>Hello, world!", true, tokenizers.WithReturnAllAttributes())
+		assert.Equal(t, []uint32{163601, 19180, 11, 2695, 0}, encoding.IDs)
+		assert.Equal(t, []string{"<|im_middle|>", "Hello", ",", "Ġworld", "!"}, encoding.Tokens)
+		assert.Equal(t, []tokenizers.Offset{{0, 13}, {13, 18}, {18, 19}, {19, 25}, {25, 26}}, encoding.Offsets)
+		assert.Equal(t, []uint32{1, 0, 0, 0, 0}, encoding.SpecialTokensMask)
+		assert.Equal(t, []uint32{1, 1, 1, 1, 1}, encoding.AttentionMask)
+		assert.Equal(t, []uint32{0, 0, 0, 0, 0}, encoding.TypeIDs)
+
+		// offsets are in bytes
+		text := "Hello, world! 你好，世界！"
+		encoding = tk.EncodeWithOptions(text, false, tokenizers.WithReturnOffsets())
+		assert.Equal(t, uint(len(text)), encoding.Offsets[len(encoding.Offsets)-1][1])
+	}
+
 	vocabSize := tk.VocabSize()
 	assert.Equal(t, uint32(163840), vocabSize)
 }
 
+func TestFromTiktokenBytes(t *testing.T) {
+	pattern := `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	want, err := tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		pattern,
+	)
+	require.NoError(t, err)
+	defer want.Close()
+
+	model, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tiktoken.model")
+	require.NoError(t, err)
+	config, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tokenizer_config.json")
+	require.NoError(t, err)
+
+	fromBytes, err := tokenizers.FromTiktokenBytes(model, config, pattern)
+	require.NoError(t, err)
+	defer fromBytes.Close()
+
+	fromReader, err := tokenizers.FromTiktokenReader(bytes.NewReader(model), bytes.NewReader(config), pattern)
+	require.NoError(t, err)
+	defer fromReader.Close()
+
+	text := "<|begin_of_text|>brown fox jumps over the lazy dog<|eot_id|>"
+	wantIDs, _ := want.Encode(text, true)
+	for _, tk := range []*tokenizers.Tokenizer{fromBytes, fromReader} {
+		assert.Equal(t, want.VocabSize(), tk.VocabSize())
+		ids, _ := tk.Encode(text, true)
+		assert.Equal(t, wantIDs, ids)
+		assert.Equal(t, text, tk.Decode(ids, false))
+	}
+
+	_, err = tokenizers.FromTiktokenBytes(model, []byte("{"), pattern)
+	require.Error(t, err)
+	_, err = tokenizers.FromTiktokenBytes(nil, config, pattern)
+	require.Error(t, err)
+}
+
+func TestTiktokenSpecialTokens(t *testing.T) {
+	pattern := tokenizers.PatternLlama3
+	model, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tiktoken.model")
+	require.NoError(t, err)
+	config, err := os.ReadFile("./test/data/meta-llama-3-8b-instruct/tokenizer_config.json")
+	require.NoError(t, err)
+
+	want, err := tokenizers.FromTiktokenBytes(model, config, pattern)
+	require.NoError(t, err)
+	defer want.Close()
+
+	// no config, special tokens provided in code
+	tk, err := tokenizers.FromTiktokenBytes(model, nil, pattern, tokenizers.WithTiktokenSpecialTokens(map[string]uint32{
+		"<|begin_of_text|>": 128000,
+		"<|eot_id|>":        128009,
+	}))
+	require.NoError(t, err)
+	defer tk.Close()
+	text := "<|begin_of_text|>brown fox jumps over the lazy dog<|eot_id|>"
+	wantIDs, _ := want.Encode(text, true)
+	ids, _ := tk.Encode(text, true)
+	assert.Equal(t, wantIDs, ids)
+	assert.Equal(t, "brown fox jumps over the lazy dog", tk.Decode(ids, true))
+
+	// override a reserved token of the config
+	tk, err = tokenizers.FromTiktokenBytes(model, config, pattern, tokenizers.WithTiktokenSpecialTokens(map[string]uint32{
+		"<|custom|>": 128002,
+	}))
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, want.VocabSize(), tk.VocabSize())
+	ids, _ = tk.Encode("<|custom|>hi", false)
+	assert.Equal(t, uint32(128002), ids[0])
+	assert.Equal(t, "hi", tk.Decode(ids, true))
+
+	// bos_token as an AddedToken object, null eos_token and no added_tokens_decoder
+	tk, err = tokenizers.FromTiktokenBytes(model, []byte(`{
+		"bos_token": {"content": "<|begin_of_text|>", "lstrip": false},
+		"eos_token": null
+	}`), pattern, tokenizers.WithTiktokenSpecialTokens(map[string]uint32{"<|begin_of_text|>": 128000}))
+	require.NoError(t, err)
+	defer tk.Close()
+	ids, _ = tk.Encode("<|begin_of_text|>brown", true)
+	assert.Equal(t, wantIDs[:2], ids)
+}
+
+func TestTiktokenEncodeSpecialTokens(t *testing.T) {
+	tk, err := tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+		tokenizers.WithEncodeSpecialTokens(),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+
+	text := "<|begin_of_text|>hi<|eot_id|>"
+	ids, _ := tk.Encode(text, true)
+	assert.NotContains(t, ids, uint32(128000))
+	assert.NotContains(t, ids, uint32(128009))
+	assert.Equal(t, text, tk.Decode(ids, true))
+}
+
+func TestCapabilities(t *testing.T) {
+	bert, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer bert.Close()
+	llama, err := tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer llama.Close()
+	tekken, err := tokenizers.FromTekken("./test/data/tekken/tekken.json")
+	require.NoError(t, err)
+	defer tekken.Close()
+
+	assert.Equal(t, tokenizers.Capabilities{
+		EncodeSpecialTokens: true,
+		Truncation:          true,
+		Padding:             true,
+		AddedTokens:         true,
+	}, bert.Capabilities())
+	assert.Equal(t, tokenizers.Capabilities{
+		Tiktoken:              true,
+		EncodeSpecialTokens:   true,
+		Truncation:            true,
+		Padding:               true,
+		TiktokenSpecialTokens: true,
+	}, llama.Capabilities())
+	assert.False(t, tekken.Capabilities().TiktokenSpecialTokens)
+	assert.False(t, tekken.Capabilities().AddedTokens)
+	assert.True(t, tekken.Capabilities().Tiktoken)
+}
+
+func TestTiktokenReservedTokenFormat(t *testing.T) {
+	// rank 256 is missing and gets filled with a reserved token
+	var model bytes.Buffer
+	for b := 0; b < 256; b++ {
+		fmt.Fprintf(&model, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b)
+	}
+	fmt.Fprintf(&model, "%s 257\n", base64.StdEncoding.EncodeToString([]byte("ab")))
+
+	tk, err := tokenizers.FromTiktokenBytes(model.Bytes(), nil, tokenizers.PatternCL100K,
+		tokenizers.WithTiktokenReservedTokenFormat("<|unused_%d|>"))
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, "<|unused_0|>", tk.Decode([]uint32{256}, false))
+
+	_, err = tokenizers.FromTiktokenBytes(model.Bytes(), nil, tokenizers.PatternCL100K,
+		tokenizers.WithTiktokenReservedTokenFormat("<|unused|>"))
+	require.Error(t, err)
+	_, err = tokenizers.FromBytes([]byte("{}"), tokenizers.WithTiktokenReservedTokenFormat("<|unused_%d|>"))
+	require.Error(t, err)
+}
+
+func TestTruncationAndPadding(t *testing.T) {
+	data, err := os.ReadFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	tk, err := tokenizers.FromBytes(data,
+		tokenizers.WithTruncation(4, tokenizers.TruncationDirectionRight),
+		tokenizers.WithTruncationStride(1),
+		tokenizers.WithPadding(6, tokenizers.PaddingDirectionRight),
+		tokenizers.WithPadToken("[PAD]"),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	encoding := tk.EncodeWithOptions("brown fox jumps over the lazy dog", false, tokenizers.WithReturnAttentionMask())
+	assert.Equal(t, []uint32{2829, 4419, 14523, 2058, 0, 0}, encoding.IDs)
+	assert.Equal(t, []uint32{1, 1, 1, 1, 0, 0}, encoding.AttentionMask)
+	require.Len(t, encoding.Overflowing, 1)
+	assert.Equal(t, []uint32{2058, 1996, 13971, 3899, 0, 0}, encoding.Overflowing[0].IDs)
+
+	kimiModel := "./test/data/kimi-k2-instruct/tiktoken.model"
+	kimiConfig := "./test/data/kimi-k2-instruct/tokenizer_config.json"
+	tk, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2,
+		tokenizers.WithTruncation(4, tokenizers.TruncationDirectionRight),
+		tokenizers.WithTruncationStride(1),
+		// pad_token of tokenizer_config.json
+		tokenizers.WithPadding(4, tokenizers.PaddingDirectionRight),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	encoding = tk.EncodeWithOptions("Hello, world! 你好，世界！", false, tokenizers.WithReturnAttentionMask())
+	assert.Equal(t, []uint32{19180, 11, 2695, 0}, encoding.IDs)
+	require.Len(t, encoding.Overflowing, 2)
+	assert.Equal(t, []uint32{0, 220, 33845, 378}, encoding.Overflowing[0].IDs)
+	assert.Equal(t, []uint32{378, 2243, 856, 163839}, encoding.Overflowing[1].IDs)
+	assert.Equal(t, []uint32{1, 1, 1, 0}, encoding.Overflowing[1].AttentionMask)
+	ids, _ := tk.Encode("Hello, world! 你好，世界！", false)
+	assert.Equal(t, []uint32{19180, 11, 2695, 0}, ids)
+
+	tk, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2,
+		tokenizers.WithPadding(0, tokenizers.PaddingDirectionLeft),
+		tokenizers.WithPadToMultipleOf(8),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	ids, _ = tk.Encode("Hello, world!", false)
+	assert.Equal(t, []uint32{163839, 163839, 163839, 163839, 19180, 11, 2695, 0}, ids)
+
+	// all tokens overflow
+	tk, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2,
+		tokenizers.WithTruncation(0, tokenizers.TruncationDirectionRight),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	encoding, err = tk.EncodeWithOptionsErr("Hello, world!", false)
+	require.NoError(t, err)
+	assert.Empty(t, encoding.IDs)
+	require.Len(t, encoding.Overflowing, 1)
+	assert.Equal(t, []uint32{19180, 11, 2695, 0}, encoding.Overflowing[0].IDs)
+	encoding = tk.EncodeWithOptions("Hello, world!", false)
+	require.Len(t, encoding.Overflowing, 1)
+	assert.Equal(t, []uint32{19180, 11, 2695, 0}, encoding.Overflowing[0].IDs)
+
+	_, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2, tokenizers.WithTruncationStride(1))
+	require.Error(t, err)
+	_, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2,
+		tokenizers.WithTruncation(2, tokenizers.TruncationDirectionRight), tokenizers.WithTruncationStride(2))
+	require.Error(t, err)
+	// no pad_token in tokenizer_config.json
+	_, err = tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+		tokenizers.WithPadding(8, tokenizers.PaddingDirectionRight),
+	)
+	require.Error(t, err)
+}
+
+func TestTokenizerOptions(t *testing.T) {
+	// every constructor accepts the same options
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json",
+		tokenizers.WithAddedTokens("newtoken"),
+		tokenizers.WithAddedSpecialTokens("<ctx>"),
+		tokenizers.WithTruncation(3, tokenizers.TruncationDirectionRight),
+		tokenizers.WithStrictUTF8(),
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	assert.Equal(t, uint32(30524), tk.VocabSize())
+	encoding := tk.EncodeWithOptions("<ctx>hello newtoken fox", false, tokenizers.WithReturnTokens(), tokenizers.WithReturnSpecialTokensMask())
+	assert.Equal(t, []string{"<ctx>", "hello", "newtoken"}, encoding.Tokens)
+	assert.Equal(t, []uint32{30523, 7592, 30522}, encoding.IDs)
+	assert.Equal(t, []uint32{1, 0, 0}, encoding.SpecialTokensMask)
+	_, _, err = tk.EncodeErr("fox\xff", false)
+	require.ErrorIs(t, err, tokenizers.ErrInvalidUTF8)
+
+	pretrained, err := tokenizers.FromPretrainedDir(legacyBertDir(t), tokenizers.WithTruncation(2, tokenizers.TruncationDirectionRight))
+	require.NoError(t, err)
+	defer pretrained.Close()
+	ids, _ := pretrained.Encode("brown fox jumps", false)
+	assert.Equal(t, []uint32{2829, 4419}, ids)
+
+	tekken, err := tokenizers.FromTekken("./test/data/tekken/tekken.json", tokenizers.WithTruncation(1, tokenizers.TruncationDirectionRight))
+	require.NoError(t, err)
+	defer tekken.Close()
+	ids, _ = tekken.Encode("brown fox jumps", false)
+	assert.Len(t, ids, 1)
+
+	kimiModel := "./test/data/kimi-k2-instruct/tiktoken.model"
+	kimiConfig := "./test/data/kimi-k2-instruct/tokenizer_config.json"
+	tk, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2, tokenizers.WithStrictUTF8())
+	require.NoError(t, err)
+	defer tk.Close()
+	_, _, err = tk.EncodeErr("fox\xff", false)
+	require.ErrorIs(t, err, tokenizers.ErrInvalidUTF8)
+
+	// unsupported combinations
+	_, err = tokenizers.FromTiktoken(kimiModel, kimiConfig, tokenizers.PatternKimiK2, tokenizers.WithAddedTokens("newtoken"))
+	require.Error(t, err)
+	_, err = tokenizers.FromFile("./test/data/bert-base-uncased.json", tokenizers.WithCacheDir(t.TempDir()))
+	require.Error(t, err)
+	_, err = tokenizers.FromFile("./test/data/bert-base-uncased.json", tokenizers.WithTiktokenReservedTokenFormat("<%d>"))
+	require.Error(t, err)
+	_, err = tokenizers.FromTekken("./test/data/tekken/tekken.json", tokenizers.WithTiktokenSpecialTokens(map[string]uint32{"<ctx>": 0}))
+	require.Error(t, err)
+	// rejected before downloading anything
+	_, err = tokenizers.FromPretrained("bert-base-uncased", tokenizers.WithTiktokenReservedTokenFormat("<%d>"))
+	require.Error(t, err)
+}
+
+func TestFromTekken(t *testing.T) {
+	tk, err := tokenizers.FromTekken("./test/data/tekken/tekken.json")
+	require.NoError(t, err)
+	defer tk.Close()
+
+	// 265 of 266 tokens after 5 reserved special tokens
+	assert.Equal(t, uint32(270), tk.VocabSize())
+
+	ids, _ := tk.Encode("hello world", false)
+	assert.Equal(t, []uint32{264, 269}, ids)
+	// "lo" is truncated from the vocab by default_vocab_size
+	ids, _ = tk.Encode("lo", false)
+	assert.Equal(t, []uint32{113, 116}, ids)
+
+	text := "<s>[INST]hello world</s><SPECIAL_4>"
+	ids, _ = tk.Encode(text, true)
+	assert.Equal(t, []uint32{1, 3, 264, 269, 2, 4}, ids)
+	assert.Equal(t, text, tk.Decode(ids, false))
+	// [INST] is not a control token
+	assert.Equal(t, "[INST]hello world", tk.Decode(ids, true))
+
+	_, err = tokenizers.FromTekken("./test/data/tekken/missing.json")
+	require.Error(t, err)
+}
+
 func TestTiktokenReplacementCharacter(t *testing.T) {
 	// Test tiktoken tokenizer with replacement character (U+FFFD)
 	// Source: https://github.com/meta-llama/llama3/blob/main/llama/tokenizer.py
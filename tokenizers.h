@@ -9,10 +9,33 @@ struct tokenizers_encode_options {
   bool return_special_tokens_mask;
   bool return_attention_mask;
   bool return_offsets;
+  bool return_word_ids;
+  bool special_policy;
+  const char *const *allowed_special;
+  size_t allowed_special_len;
+  bool allow_all_special;
+  const char *const *disallowed_special;
+  size_t disallowed_special_len;
+  bool disallow_all_special;
+  bool without_truncation_padding;
 };
 
 struct tokenizers_options {
   bool encode_special_tokens;
+  const char *reserved_token_format;
+  bool truncation;
+  size_t max_length;
+  uint8_t truncation_direction;
+  size_t stride;
+  bool padding;
+  size_t pad_length;
+  uint8_t padding_direction;
+  size_t pad_to_multiple_of;
+  const char *pad_token;
+  const char *const *added_tokens;
+  size_t added_tokens_len;
+  const char *const *added_special_tokens;
+  size_t added_special_tokens_len;
 };
 
 struct tokenizers_buffer {
@@ -22,21 +45,60 @@ struct tokenizers_buffer {
   uint32_t *attention_mask;
   char **tokens;
   size_t *offsets;
+  int32_t *word_ids;
+  int32_t *sequence_ids;
   size_t len;
+  char *error;
+  char *disallowed_special;
+  struct tokenizers_buffer *overflowing;
+  size_t overflowing_len;
+};
+
+struct tokenizers_tensor_options {
+  bool add_special_tokens;
+  bool padding_direction_set;
+  uint8_t padding_direction;
+  bool pad_to_multiple_of_set;
+  size_t pad_to_multiple_of;
+};
+
+struct tokenizers_tensors {
+  int64_t *input_ids;
+  int64_t *attention_mask;
+  int64_t *token_type_ids;
+  size_t rows;
+  size_t cols;
+  char *error;
 };
 
 const char *tokenizers_version();
 
 void *tokenizers_from_bytes(const uint8_t *config, uint32_t len, const struct tokenizers_options *options, char **error);
 
-void *tokenizers_from_bytes_with_truncation(const uint8_t *config, uint32_t len, size_t max_len, uint8_t direction, char **error);
-
-void *tokenizers_from_file(const char *config, char **error);
+void *tokenizers_from_file(const char *config, const struct tokenizers_options *options, char **error);
 
 void *tokenizers_from_tiktoken(const char *model_file, const char *config_file, const char *pattern, char **error);
 
+void *tokenizers_from_tiktoken_bytes(const uint8_t *model, uint32_t model_len, const uint8_t *config, uint32_t config_len, const char *pattern, const struct tokenizers_options *options, char **error);
+
+void *tokenizers_from_tekken(const char *path, const struct tokenizers_options *options, char **error);
+
 struct tokenizers_buffer tokenizers_encode(void *ptr, const char *message, const struct tokenizers_encode_options *options);
 
+struct tokenizers_buffer tokenizers_encode_words(void *ptr, const char *const *words, size_t words_len, const char *const *pair, size_t pair_len, bool is_pair, const struct tokenizers_encode_options *options);
+
+struct tokenizers_buffer tokenizers_normalize(void *ptr, const char *text, char **normalized);
+
+struct tokenizers_buffer tokenizers_pre_tokenize(void *ptr, const char *text);
+
+struct tokenizers_buffer tokenizers_post_process(void *ptr, const struct tokenizers_buffer *first, const struct tokenizers_buffer *second, const struct tokenizers_encode_options *options);
+
+size_t tokenizers_num_special_tokens_to_add(void *ptr, bool is_pair);
+
+struct tokenizers_tensors tokenizers_encode_batch_tensors(void *ptr, const char *const *texts, size_t len, const struct tokenizers_tensor_options *options);
+
+void tokenizers_free_tensors(struct tokenizers_tensors tensors);
+
 char *tokenizers_decode(void *ptr, const uint32_t *ids, uint32_t len, bool skip_special_tokens);
 
 uint32_t tokenizers_vocab_size(void *ptr);
@@ -0,0 +1,136 @@
+package tokenizers
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// TruncationStrategy is the part of the text kept by TruncateText.
+type TruncationStrategy int
+
+const (
+	// TruncateHead keeps the first tokens of the text.
+	TruncateHead TruncationStrategy = iota
+	// TruncateTail keeps the last tokens of the text.
+	TruncateTail
+	// TruncateMiddle keeps the first and the last tokens of the text, joined by Ellipsis.
+	TruncateMiddle
+)
+
+// Ellipsis joins the parts of the text kept by TruncateMiddle.
+const Ellipsis = "…"
+
+// ErrCannotTruncate is returned by TruncateText when no part of the text fits the
+// max tokens, e.g. when its first token alone encodes to more tokens.
+var ErrCannotTruncate = errors.New("text can't be truncated to the max tokens")
+
+// TruncateText returns the longest part of text of at most maxTokens tokens, without
+// special tokens, and its number of tokens. The text is cut at the offsets of tokens,
+// so it is never split inside a character and normalization doesn't leak into it.
+// Tokens are counted without the truncation and padding of the tokenizer.
+func (t *Tokenizer) TruncateText(text string, maxTokens int, strategy TruncationStrategy) (string, int, error) {
+	if maxTokens < 0 {
+		return "", 0, fmt.Errorf("max tokens must not be negative, got %d", maxTokens)
+	}
+	if strategy < TruncateHead || strategy > TruncateMiddle {
+		return "", 0, fmt.Errorf("invalid truncation strategy %d", strategy)
+	}
+	encoding, err := t.EncodeWithOptionsErr(text, false, WithReturnOffsets(), WithoutTruncationAndPadding())
+	if err != nil {
+		return "", 0, err
+	}
+	if len(encoding.IDs) <= maxTokens {
+		return text, len(encoding.IDs), nil
+	}
+	if maxTokens == 0 {
+		return "", 0, nil
+	}
+
+	ellipsisTokens := 0
+	if strategy == TruncateMiddle {
+		if ellipsisTokens, err = t.countTokens(Ellipsis); err != nil {
+			return "", 0, err
+		}
+	}
+	// tokens at the cut may encode differently on their own, e.g. the start of a
+	// word with BPE, so keep fewer tokens until the truncated text fits, stepping
+	// down by at least the excess and twice as far after every failed attempt
+	step := 1
+	for keep := maxTokens; keep > 0; {
+		truncated, ok := cutText(text, encoding.Offsets, keep, strategy, ellipsisTokens)
+		if !ok {
+			keep--
+			continue
+		}
+		n, err := t.countTokens(truncated)
+		if err != nil {
+			return "", 0, err
+		}
+		if n <= maxTokens {
+			return truncated, n, nil
+		}
+		keep -= max(n-maxTokens, step)
+		step *= 2
+	}
+	return "", 0, fmt.Errorf("%w: %d", ErrCannotTruncate, maxTokens)
+}
+
+// countTokens returns the number of tokens of text without special tokens, ignoring
+// the truncation and padding of the tokenizer.
+func (t *Tokenizer) countTokens(text string) (int, error) {
+	encoding, err := t.EncodeWithOptionsErr(text, false, WithoutTruncationAndPadding())
+	if err != nil {
+		return 0, err
+	}
+	return len(encoding.IDs), nil
+}
+
+// cutText returns the part of text covered by keep tokens by strategy, it returns
+// false if the text can't be cut at the tokens, e.g. a token ends inside a character.
+func cutText(text string, offsets []Offset, keep int, strategy TruncationStrategy, ellipsisTokens int) (string, bool) {
+	switch strategy {
+	case TruncateHead:
+		end, ok := headCut(text, offsets, keep)
+		return text[:end], ok
+	case TruncateTail:
+		start, ok := tailCut(text, offsets, keep)
+		return text[start:], ok
+	default:
+		keep -= ellipsisTokens
+		if keep <= 0 {
+			return "", false
+		}
+		end, headOK := headCut(text, offsets, (keep+1)/2)
+		start, tailOK := tailCut(text, offsets, keep/2)
+		if !headOK || !tailOK || end > start {
+			return "", false
+		}
+		return text[:end] + Ellipsis + text[start:], true
+	}
+}
+
+// headCut returns the end of the first n tokens in text.
+func headCut(text string, offsets []Offset, n int) (int, bool) {
+	if n == 0 {
+		return 0, true
+	}
+	end := min(int(offsets[n-1][1]), len(text))
+	if n < len(offsets) && int(offsets[n][0]) < end {
+		return 0, false
+	}
+	return end, end == len(text) || utf8.RuneStart(text[end])
+}
+
+// tailCut returns the start of the last n tokens in text.
+func tailCut(text string, offsets []Offset, n int) (int, bool) {
+	if n == 0 {
+		return len(text), true
+	}
+	i := len(offsets) - n
+	start := min(int(offsets[i][0]), len(text))
+	if i > 0 && int(offsets[i-1][1]) > start {
+		return len(text), false
+	}
+	return start, start == len(text) || utf8.RuneStart(text[start])
+}
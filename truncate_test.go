@@ -0,0 +1,111 @@
+package tokenizers_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateText(t *testing.T) {
+	tk, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer tk.Close()
+	// the normalizer lowercases and strips accents, the original text is kept
+	text := "Héllo WÖRLD over the lazy dog"
+	tests := []struct {
+		name      string
+		maxTokens int
+		strategy  tokenizers.TruncationStrategy
+		want      string
+		wantLen   int
+	}{
+		{"head", 3, tokenizers.TruncateHead, "Héllo WÖRLD over", 3},
+		{"tail", 2, tokenizers.TruncateTail, "lazy dog", 2},
+		{"middle", 5, tokenizers.TruncateMiddle, "Héllo WÖRLD…lazy dog", 5},
+		{"fits", 6, tokenizers.TruncateHead, text, 6},
+		{"empty", 0, tokenizers.TruncateTail, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			truncated, n, err := tk.TruncateText(text, tt.maxTokens, tt.strategy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, truncated)
+			assert.Equal(t, tt.wantLen, n)
+		})
+	}
+	_, _, err = tk.TruncateText(text, -1, tokenizers.TruncateHead)
+	assert.Error(t, err)
+	_, _, err = tk.TruncateText(text, 1, tokenizers.TruncationStrategy(3))
+	assert.Error(t, err)
+	// the ellipsis leaves no room for the text
+	_, _, err = tk.TruncateText(text, 1, tokenizers.TruncateMiddle)
+	assert.ErrorIs(t, err, tokenizers.ErrCannotTruncate)
+
+	// truncation and padding of the tokenizer don't count
+	tk, err = tokenizers.FromFile("./test/data/bert-base-uncased.json",
+		tokenizers.WithTruncation(4, tokenizers.TruncationDirectionRight),
+		tokenizers.WithPadding(16, tokenizers.PaddingDirectionRight), tokenizers.WithPadToken("[PAD]"))
+	require.NoError(t, err)
+	defer tk.Close()
+	truncated, n, err := tk.TruncateText(text, 8, tokenizers.TruncateHead)
+	require.NoError(t, err)
+	assert.Equal(t, text, truncated)
+	assert.Equal(t, 6, n)
+	truncated, n, err = tk.TruncateText(text, 2, tokenizers.TruncateTail)
+	require.NoError(t, err)
+	assert.Equal(t, "lazy dog", truncated)
+	assert.Equal(t, 2, n)
+}
+
+func TestTruncateTextTiktoken(t *testing.T) {
+	tk, err := tokenizers.FromTiktoken(
+		"./test/data/meta-llama-3-8b-instruct/tiktoken.model",
+		"./test/data/meta-llama-3-8b-instruct/tokenizer_config.json",
+		tokenizers.PatternLlama3,
+	)
+	require.NoError(t, err)
+	defer tk.Close()
+	data, err := os.ReadFile("./test/data/long_text.txt")
+	require.NoError(t, err)
+	// byte tokens of characters outside of the vocabulary are kept or dropped together
+	text := "hi 😀🦜 world " + string(data)
+
+	for _, maxTokens := range []int{1, 2, 3, 4, 5, 64, 500} {
+		head, n, err := tk.TruncateText(text, maxTokens, tokenizers.TruncateHead)
+		require.NoError(t, err)
+		assert.True(t, utf8.ValidString(head))
+		assert.True(t, strings.HasPrefix(text, head))
+		assert.LessOrEqual(t, n, maxTokens)
+		ids, _ := tk.Encode(head, false)
+		assert.Len(t, ids, n)
+
+		tail, n, err := tk.TruncateText(text, maxTokens, tokenizers.TruncateTail)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(text, tail))
+		assert.LessOrEqual(t, n, maxTokens)
+		ids, _ = tk.Encode(tail, false)
+		assert.Len(t, ids, n)
+
+		middle, n, err := tk.TruncateText(text, maxTokens, tokenizers.TruncateMiddle)
+		if maxTokens == 1 {
+			// the ellipsis takes the only token
+			assert.ErrorIs(t, err, tokenizers.ErrCannotTruncate)
+			continue
+		}
+		require.NoError(t, err)
+		assert.True(t, utf8.ValidString(middle))
+		assert.LessOrEqual(t, n, maxTokens)
+		if middle != "" {
+			prefix, suffix, ok := strings.Cut(middle, tokenizers.Ellipsis)
+			assert.True(t, ok)
+			assert.True(t, strings.HasPrefix(text, prefix))
+			assert.True(t, strings.HasSuffix(text, suffix))
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// addedToken mirrors an entry of the "added_tokens" list in tokenizer.json.
+type addedToken struct {
+	ID         uint32 `json:"id"`
+	Content    string `json:"content"`
+	SingleWord bool   `json:"single_word"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Normalized bool   `json:"normalized"`
+	Special    bool   `json:"special"`
+}
+
+// readVocabTxt reads a WordPiece vocabulary with one token per line,
+// the line number being the token ID.
+func readVocabTxt(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vocab file %s: %w", path, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	vocab := make([]string, len(lines))
+	for i, line := range lines {
+		vocab[i] = strings.TrimSuffix(line, "\r")
+	}
+	return vocab, nil
+}
+
+// readVocabJSON reads a BPE vocabulary mapping tokens to their IDs.
+func readVocabJSON(path string) (map[string]uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vocab file %s: %w", path, err)
+	}
+	var vocab map[string]uint32
+	if err := json.Unmarshal(data, &vocab); err != nil {
+		return nil, fmt.Errorf("failed to parse vocab file %s: %w", path, err)
+	}
+	return vocab, nil
+}
+
+// readMergesTxt reads BPE merges with one space separated pair per line,
+// skipping the optional "#version" header.
+func readMergesTxt(path string) ([][2]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read merges file %s: %w", path, err)
+	}
+	var merges [][2]string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || (i == 0 && strings.HasPrefix(line, "#version")) {
+			continue
+		}
+		parts := strings.Split(line, " ")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid merge at line %d of %s: %q", i+1, path, line)
+		}
+		merges = append(merges, [2]string{parts[0], parts[1]})
+	}
+	return merges, nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// WordPieceOptions customizes the tokenizer built by FromWordPieceVocab.
+// The zero value builds an uncased BERT tokenizer equivalent to bert-base-uncased.
+//
+// Normalizer, PreTokenizer, PostProcessor and Decoder replace the default
+// components with their tokenizer.json definitions, e.g.
+// json.RawMessage(`{"type": "NFC"}`). Use json.RawMessage("null") to drop a component.
+type WordPieceOptions struct {
+	// Cased disables lowercasing in the default normalizer.
+	Cased bool
+	// StripAccents overrides accent stripping, which by default follows lowercasing.
+	StripAccents *bool
+
+	// Special tokens default to [UNK], [CLS], [SEP], [PAD] and [MASK].
+	UnkToken  string
+	ClsToken  string
+	SepToken  string
+	PadToken  string
+	MaskToken string
+
+	// ContinuingSubwordPrefix defaults to "##".
+	ContinuingSubwordPrefix string
+	// MaxInputCharsPerWord defaults to 100.
+	MaxInputCharsPerWord int
+
+	Normalizer    json.RawMessage
+	PreTokenizer  json.RawMessage
+	PostProcessor json.RawMessage
+	Decoder       json.RawMessage
+}
+
+// BPEOptions customizes the tokenizer built by FromBPEFiles.
+// The zero value builds a GPT-2 style byte-level BPE tokenizer.
+//
+// Normalizer, PreTokenizer, PostProcessor and Decoder replace the default
+// components with their tokenizer.json definitions, e.g. a RobertaProcessing
+// post-processor. Use json.RawMessage("null") to drop a component.
+type BPEOptions struct {
+	// AddPrefixSpace adds a space to the input like GPT-2 does for the first word.
+	AddPrefixSpace bool
+	// UnkToken is the token for unknown symbols, none by default.
+	UnkToken string
+	// SpecialTokens lists vocabulary tokens to register as special tokens,
+	// e.g. "<|endoftext|>".
+	SpecialTokens []string
+
+	ContinuingSubwordPrefix string
+	EndOfWordSuffix         string
+
+	Normalizer    json.RawMessage
+	PreTokenizer  json.RawMessage
+	PostProcessor json.RawMessage
+	Decoder       json.RawMessage
+}
+
+// FromWordPieceVocab creates a WordPiece tokenizer from a vocab.txt file,
+// with one token per line.
+func FromWordPieceVocab(vocabPath string, opts WordPieceOptions, loadOpts ...TokenizerOption) (*Tokenizer, error) {
+	vocab, err := readVocabTxt(vocabPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := wordPieceTokenizerJSON(vocab, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data, loadOpts...)
+}
+
+// FromBPEFiles creates a BPE tokenizer from a vocab.json file mapping tokens
+// to IDs and a merges.txt file with one merge per line.
+func FromBPEFiles(vocabPath, mergesPath string, opts BPEOptions, loadOpts ...TokenizerOption) (*Tokenizer, error) {
+	vocab, err := readVocabJSON(vocabPath)
+	if err != nil {
+		return nil, err
+	}
+	merges, err := readMergesTxt(mergesPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := bpeTokenizerJSON(vocab, merges, opts)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data, loadOpts...)
+}
+
+// setComponent sets the tokenizer.json component to override if one is provided,
+// or to the default otherwise.
+func setComponent(doc map[string]any, name string, override json.RawMessage, fallback any) {
+	if override != nil {
+		doc[name] = override
+		return
+	}
+	doc[name] = fallback
+}
+
+// wordPieceTokenizerJSON assembles a BERT-style tokenizer.json from a WordPiece vocabulary.
+func wordPieceTokenizerJSON(vocab []string, opts WordPieceOptions) ([]byte, error) {
+	ids := make(map[string]uint32, len(vocab))
+	for i, token := range vocab {
+		ids[token] = uint32(i)
+	}
+
+	unk := orDefault(opts.UnkToken, "[UNK]")
+	cls := orDefault(opts.ClsToken, "[CLS]")
+	sep := orDefault(opts.SepToken, "[SEP]")
+	if _, ok := ids[unk]; !ok {
+		return nil, fmt.Errorf("vocab is missing special token %s", unk)
+	}
+	if opts.PostProcessor == nil {
+		for _, token := range []string{cls, sep} {
+			if _, ok := ids[token]; !ok {
+				return nil, fmt.Errorf("vocab is missing special token %s", token)
+			}
+		}
+	}
+
+	var addedTokens []addedToken
+	for _, token := range []string{orDefault(opts.PadToken, "[PAD]"), unk, cls, sep, orDefault(opts.MaskToken, "[MASK]")} {
+		if id, ok := ids[token]; ok {
+			addedTokens = append(addedTokens, addedToken{ID: id, Content: token, Special: true})
+		}
+	}
+	sort.Slice(addedTokens, func(i, j int) bool { return addedTokens[i].ID < addedTokens[j].ID })
+
+	prefix := orDefault(opts.ContinuingSubwordPrefix, "##")
+	maxInputChars := opts.MaxInputCharsPerWord
+	if maxInputChars == 0 {
+		maxInputChars = 100
+	}
+	var stripAccents any
+	if opts.StripAccents != nil {
+		stripAccents = *opts.StripAccents
+	}
+
+	doc := map[string]any{
+		"version":      "1.0",
+		"truncation":   nil,
+		"padding":      nil,
+		"added_tokens": addedTokens,
+		"model": map[string]any{
+			"type":                      "WordPiece",
+			"unk_token":                 unk,
+			"continuing_subword_prefix": prefix,
+			"max_input_chars_per_word":  maxInputChars,
+			"vocab":                     ids,
+		},
+	}
+	setComponent(doc, "normalizer", opts.Normalizer, map[string]any{
+		"type":                 "BertNormalizer",
+		"clean_text":           true,
+		"handle_chinese_chars": true,
+		"strip_accents":        stripAccents,
+		"lowercase":            !opts.Cased,
+	})
+	setComponent(doc, "pre_tokenizer", opts.PreTokenizer, map[string]any{"type": "BertPreTokenizer"})
+	if opts.PostProcessor != nil {
+		doc["post_processor"] = opts.PostProcessor
+	} else {
+		doc["post_processor"] = templateProcessing(cls, ids[cls], sep, ids[sep])
+	}
+	setComponent(doc, "decoder", opts.Decoder, map[string]any{
+		"type":    "WordPiece",
+		"prefix":  prefix,
+		"cleanup": true,
+	})
+	return json.Marshal(doc)
+}
+
+// templateProcessing returns a post-processor adding cls/sep around single
+// sequences and cls A sep B sep for pairs, like BERT does.
+func templateProcessing(cls string, clsID uint32, sep string, sepID uint32) map[string]any {
+	special := func(id string, typeID int) map[string]any {
+		return map[string]any{"SpecialToken": map[string]any{"id": id, "type_id": typeID}}
+	}
+	sequence := func(id string, typeID int) map[string]any {
+		return map[string]any{"Sequence": map[string]any{"id": id, "type_id": typeID}}
+	}
+	return map[string]any{
+		"type":   "TemplateProcessing",
+		"single": []any{special(cls, 0), sequence("A", 0), special(sep, 0)},
+		"pair":   []any{special(cls, 0), sequence("A", 0), special(sep, 0), sequence("B", 1), special(sep, 1)},
+		"special_tokens": map[string]any{
+			cls: map[string]any{"id": cls, "ids": []uint32{clsID}, "tokens": []string{cls}},
+			sep: map[string]any{"id": sep, "ids": []uint32{sepID}, "tokens": []string{sep}},
+		},
+	}
+}
+
+// bpeTokenizerJSON assembles a tokenizer.json from a BPE vocabulary and merges,
+// defaulting to GPT-2 style byte-level components.
+func bpeTokenizerJSON(vocab map[string]uint32, merges [][2]string, opts BPEOptions) ([]byte, error) {
+	if merges == nil {
+		merges = [][2]string{}
+	}
+	addedTokens := []addedToken{}
+	for _, token := range opts.SpecialTokens {
+		id, ok := vocab[token]
+		if !ok {
+			return nil, fmt.Errorf("vocab is missing special token %s", token)
+		}
+		addedTokens = append(addedTokens, addedToken{ID: id, Content: token, Special: true})
+	}
+	sort.Slice(addedTokens, func(i, j int) bool { return addedTokens[i].ID < addedTokens[j].ID })
+
+	nullable := func(s string) any {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	byteLevel := func(addPrefixSpace, trimOffsets bool) map[string]any {
+		return map[string]any{
+			"type":             "ByteLevel",
+			"add_prefix_space": addPrefixSpace,
+			"trim_offsets":     trimOffsets,
+			"use_regex":        true,
+		}
+	}
+	doc := map[string]any{
+		"version":      "1.0",
+		"truncation":   nil,
+		"padding":      nil,
+		"added_tokens": addedTokens,
+		"model": map[string]any{
+			"type":                      "BPE",
+			"dropout":                   nil,
+			"unk_token":                 nullable(opts.UnkToken),
+			"continuing_subword_prefix": nullable(opts.ContinuingSubwordPrefix),
+			"end_of_word_suffix":        nullable(opts.EndOfWordSuffix),
+			"fuse_unk":                  false,
+			"byte_fallback":             false,
+			"ignore_merges":             false,
+			"vocab":                     vocab,
+			"merges":                    merges,
+		},
+	}
+	setComponent(doc, "normalizer", opts.Normalizer, nil)
+	setComponent(doc, "pre_tokenizer", opts.PreTokenizer, byteLevel(opts.AddPrefixSpace, true))
+	setComponent(doc, "post_processor", opts.PostProcessor, byteLevel(true, false))
+	setComponent(doc, "decoder", opts.Decoder, byteLevel(true, true))
+	return json.Marshal(doc)
+}
+
+// mergeAddedTokens adds extra tokens to the "added_tokens" of a tokenizer.json
+// and marks the tokens in isSpecial as special, registering them as added
+// tokens if they are only part of the model vocabulary. Tokens already present
+// keep their ID, a new token with the ID of another added token is an error.
+// It returns the updated tokenizer.json along with the content to ID mapping
+// of all added tokens.
+func mergeAddedTokens(data []byte, extra []addedToken, isSpecial map[string]bool) ([]byte, map[string]uint32, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse tokenizer.json: %w", err)
+	}
+	var tokens []addedToken
+	if raw, ok := doc["added_tokens"]; ok {
+		if err := json.Unmarshal(raw, &tokens); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse added_tokens: %w", err)
+		}
+	}
+
+	index := make(map[string]int, len(tokens))
+	ids := make(map[uint32]int, len(tokens))
+	for i, token := range tokens {
+		index[token.Content] = i
+		ids[token.ID] = i
+	}
+	changed := false
+	add := func(token addedToken) error {
+		if i, ok := index[token.Content]; ok {
+			if token.Special && !tokens[i].Special {
+				tokens[i].Special = true
+				changed = true
+			}
+			return nil
+		}
+		if i, ok := ids[token.ID]; ok {
+			return fmt.Errorf("added token %q has the ID %d of added token %q", token.Content, token.ID, tokens[i].Content)
+		}
+		index[token.Content] = len(tokens)
+		ids[token.ID] = len(tokens)
+		tokens = append(tokens, token)
+		changed = true
+		return nil
+	}
+
+	sort.Slice(extra, func(i, j int) bool { return extra[i].ID < extra[j].ID })
+	for _, token := range extra {
+		if err := add(token); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var special []string
+	for content := range isSpecial {
+		special = append(special, content)
+	}
+	sort.Strings(special)
+	var vocab map[string]uint32
+	for _, content := range special {
+		if i, ok := index[content]; ok {
+			if !tokens[i].Special {
+				tokens[i].Special = true
+				changed = true
+			}
+			continue
+		}
+		if vocab == nil {
+			vocab = modelVocab(doc["model"])
+		}
+		if id, ok := vocab[content]; ok {
+			if err := add(addedToken{ID: id, Content: content, Special: true}); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	addedTokens := make(map[string]uint32, len(tokens))
+	for _, token := range tokens {
+		addedTokens[token.Content] = token.ID
+	}
+	if !changed {
+		return data, addedTokens, nil
+	}
+
+	sort.SliceStable(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	raw, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc["added_tokens"] = raw
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, addedTokens, nil
+}
+
+// modelVocab returns the token to ID mapping of a tokenizer.json model,
+// supporting both map (WordPiece, BPE) and list (Unigram) vocabularies.
+func modelVocab(model json.RawMessage) map[string]uint32 {
+	var m struct {
+		Vocab json.RawMessage `json:"vocab"`
+	}
+	if err := json.Unmarshal(model, &m); err != nil || len(m.Vocab) == 0 {
+		return map[string]uint32{}
+	}
+	var vocab map[string]uint32
+	if err := json.Unmarshal(m.Vocab, &vocab); err == nil {
+		return vocab
+	}
+	var pieces [][2]json.RawMessage
+	if err := json.Unmarshal(m.Vocab, &pieces); err != nil {
+		return map[string]uint32{}
+	}
+	vocab = make(map[string]uint32, len(pieces))
+	for id, piece := range pieces {
+		var content string
+		if err := json.Unmarshal(piece[0], &content); err == nil {
+			vocab[content] = uint32(id)
+		}
+	}
+	return vocab
+}
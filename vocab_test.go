@@ -0,0 +1,93 @@
+package tokenizers_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daulet/tokenizers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromWordPieceVocab(t *testing.T) {
+	want, err := tokenizers.FromFile("./test/data/bert-base-uncased.json")
+	require.NoError(t, err)
+	defer want.Close()
+
+	tk, err := tokenizers.FromWordPieceVocab(filepath.Join(legacyBertDir(t), "vocab.txt"), tokenizers.WordPieceOptions{})
+	require.NoError(t, err)
+	defer tk.Close()
+
+	assert.Equal(t, want.VocabSize(), tk.VocabSize())
+	for _, str := range []string{
+		"brown fox jumps over the lazy dog",
+		"Brown Fox JUMPS över the lazy dog!",
+		"",
+		"\x91D",
+	} {
+		for _, addSpecial := range []bool{false, true} {
+			assert.Equal(t,
+				want.EncodeWithOptions(str, addSpecial, tokenizers.WithReturnAllAttributes()),
+				tk.EncodeWithOptions(str, addSpecial, tokenizers.WithReturnAllAttributes()),
+				"encoding mismatch for %q (add special tokens: %v)", str, addSpecial)
+		}
+	}
+	ids := []uint32{101, 2829, 4419, 14523, 2058, 1996, 13971, 3899, 102}
+	assert.Equal(t, want.Decode(ids, false), tk.Decode(ids, false))
+}
+
+func TestFromWordPieceVocabOptions(t *testing.T) {
+	tk, err := tokenizers.FromWordPieceVocab(filepath.Join(legacyBertDir(t), "vocab.txt"), tokenizers.WordPieceOptions{
+		Cased:         true,
+		PostProcessor: json.RawMessage("null"),
+	})
+	require.NoError(t, err)
+	defer tk.Close()
+
+	// the uncased vocab has no capitalized tokens
+	ids, tokens := tk.Encode("Brown fox", true)
+	assert.Equal(t, []uint32{100, 4419}, ids)
+	assert.Equal(t, []string{"[UNK]", "fox"}, tokens)
+
+	_, err = tokenizers.FromWordPieceVocab(filepath.Join(legacyBertDir(t), "vocab.txt"), tokenizers.WordPieceOptions{
+		ClsToken: "<s>",
+	})
+	require.Error(t, err)
+}
+
+func TestFromBPEFiles(t *testing.T) {
+	dir := t.TempDir()
+	vocabPath := filepath.Join(dir, "vocab.json")
+	mergesPath := filepath.Join(dir, "merges.txt")
+	vocab := map[string]uint32{
+		"h": 0, "e": 1, "l": 2, "o": 3, "Ġ": 4, "w": 5, "r": 6, "d": 7,
+		"he": 8, "ll": 9, "llo": 10, "hello": 11, "Ġw": 12, "or": 13, "Ġwor": 14, "ld": 15, "Ġworld": 16,
+		"<|endoftext|>": 17,
+	}
+	data, err := json.Marshal(vocab)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(vocabPath, data, 0o644))
+	merges := "#version: 0.2\nh e\nl l\nll o\nhe llo\nĠ w\no r\nĠw or\nl d\nĠwor ld\n"
+	require.NoError(t, os.WriteFile(mergesPath, []byte(merges), 0o644))
+
+	tk, err := tokenizers.FromBPEFiles(vocabPath, mergesPath, tokenizers.BPEOptions{
+		SpecialTokens: []string{"<|endoftext|>"},
+	})
+	require.NoError(t, err)
+	defer tk.Close()
+
+	encoding := tk.EncodeWithOptions("hello world<|endoftext|>", true, tokenizers.WithReturnAllAttributes())
+	assert.Equal(t, []uint32{11, 16, 17}, encoding.IDs)
+	assert.Equal(t, []string{"hello", "Ġworld", "<|endoftext|>"}, encoding.Tokens)
+	assert.Equal(t, []uint32{0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, []tokenizers.Offset{{0, 5}, {5, 11}, {11, 24}}, encoding.Offsets)
+	assert.Equal(t, "hello world", tk.Decode(encoding.IDs, true))
+
+	_, err = tokenizers.FromBPEFiles(vocabPath, mergesPath, tokenizers.BPEOptions{
+		SpecialTokens: []string{"</s>"},
+	})
+	require.Error(t, err)
+}